@@ -0,0 +1,74 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a fixed-size cache that evicts the least recently used item when full.
+// It is safe for concurrent use.
+type lruCache[V any] struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // Most recently used first.
+}
+
+// Represents an item stored in an lruCache.
+type lruCacheItem[V any] struct {
+	key   string
+	value V
+}
+
+// newLRUCache creates an empty cache that holds up to capacity items.
+func newLRUCache[V any](capacity int) *lruCache[V] {
+	return &lruCache[V]{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value stored for a key, marking it as recently used.
+// Returns false if the key is not in the cache.
+func (cache *lruCache[V]) Get(key string) (V, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, exists := cache.items[key]
+	if !exists {
+		var zero V
+		return zero, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruCacheItem[V]).value, true
+}
+
+// Set stores a value for a key, evicting the least recently used item if the cache is full.
+func (cache *lruCache[V]) Set(key string, value V) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, exists := cache.items[key]
+	if exists {
+		element.Value.(*lruCacheItem[V]).value = value
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.items[key] = cache.order.PushFront(&lruCacheItem[V]{key: key, value: value})
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.items, oldest.Value.(*lruCacheItem[V]).key)
+	}
+}
+
+// Clear removes all items from the cache.
+func (cache *lruCache[V]) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.items = make(map[string]*list.Element)
+	cache.order.Init()
+}
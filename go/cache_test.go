@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache[int](2)
+
+	_, found := cache.Get("a")
+	if found {
+		t.Fatal("Get() found a key in an empty cache")
+	}
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	if value, found := cache.Get("a"); !found || value != 1 {
+		t.Errorf("Get(%q) = %d, %t, want 1, true", "a", value, found)
+	}
+
+	// "b" is now the least recently used item, so it is evicted.
+	cache.Set("c", 3)
+	if _, found := cache.Get("b"); found {
+		t.Errorf("Get(%q) found an evicted key", "b")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if value, found := cache.Get(key); !found || value != want {
+			t.Errorf("Get(%q) = %d, %t, want %d, true", key, value, found, want)
+		}
+	}
+
+	// Updating a key does not grow the cache.
+	cache.Set("c", 4)
+	if value, _ := cache.Get("c"); value != 4 {
+		t.Errorf("Get(%q) = %d after an update, want 4", "c", value)
+	}
+	if value, found := cache.Get("a"); !found || value != 1 {
+		t.Errorf("Get(%q) = %d, %t after an update, want 1, true", "a", value, found)
+	}
+
+	cache.Clear()
+	for _, key := range []string{"a", "c"} {
+		if _, found := cache.Get(key); found {
+			t.Errorf("Get(%q) found a key after Clear()", key)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// CommandUsage describes the command-line subcommands. Without a subcommand, the
+// HTTP server is started.
+const CommandUsage = `Usage:
+  dsff                      Start the HTTP server.
+  dsff validate [FILE]      Validate a data file, DATA_FILE, or data.json.gz.
+  dsff lookup [FLAGS] QUERY Print the entries matching a search, or a concept.
+  dsff whitelist [FILE]     List the lists of phrases that are split incorrectly.
+`
+
+// LookupUsage describes the flags of the lookup subcommand.
+const LookupUsage = `Usage: dsff lookup [-mode MODE] [-camp sinonims|exemples|definicio] [-concepte] [-limit N] QUERY
+`
+
+// runCommand runs a command-line subcommand instead of the HTTP server, writing its
+// output to stdout. It returns the exit code of the process.
+func runCommand(args []string, stdout io.Writer) int {
+	switch args[0] {
+	case "validate":
+		return runValidateCommand(args[1:], stdout)
+	case "lookup":
+		return runLookupCommand(args[1:], stdout)
+	case "whitelist":
+		return runWhitelistCommand(args[1:], stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, CommandUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n%s", args[0], CommandUsage)
+		return 2
+	}
+}
+
+// runValidateCommand loads a data file and prints a report of its problems, without
+// starting the server. This lets CI check an export before it is deployed.
+//
+// Postconditions:
+//   - Returns 0 if the data file has no fatal problems, even with warnings
+//   - Returns 1 if the data file cannot be loaded or has fatal problems
+//   - Returns 2 on invalid arguments
+func runValidateCommand(args []string, stdout io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprint(os.Stderr, CommandUsage)
+		return 2
+	}
+
+	dataFile := getEnvOrDefault("DATA_FILE", DefaultDataFile)
+	if len(args) == 1 {
+		dataFile = args[0]
+	}
+
+	err := loadDataFromFile(dataFile)
+	if err != nil {
+		fmt.Fprintf(stdout, "FATAL: %v\n", err)
+		return 1
+	}
+
+	fatalCount := 0
+	problems := getDataProblems()
+	for _, problem := range problems {
+		severity := "WARNING"
+		if problem.IsFatal {
+			severity = "FATAL"
+			fatalCount++
+		}
+		fmt.Fprintf(stdout, "%s: entry %d (%q): %s\n", severity, problem.Index, problem.Title, problem.Message)
+	}
+
+	fmt.Fprintf(stdout, "%s: %d entries, %d concepts, %d problems (%d fatal)\n",
+		dataFile, len(AllEntries), len(ConceptsBySlug), len(problems), fatalCount)
+	if fatalCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runLookupCommand prints, as plain text, the entries matching a search, as on the
+// search page, or the entries of a concept. The data is loaded as for the server.
+//
+// Postconditions:
+//   - Returns 0 if any entry is found
+//   - Returns 1 if no entry is found, or the data cannot be loaded
+//   - Returns 2 on invalid arguments
+func runLookupCommand(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), LookupUsage)
+		flags.PrintDefaults()
+	}
+	mode := flags.String("mode", SearchModeConte, "search mode, as in the search form")
+	field := flags.String("camp", "", `also search in "sinonims" (synonyms), "exemples" (examples), or "definicio" (definitions)`)
+	byConcept := flags.Bool("concepte", false, "print the entries of the concept instead of searching")
+	limit := flags.Int("limit", DefaultPageSize, "maximum number of entries to print")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *limit < 1 || !slices.Contains(SearchModes, *mode) {
+		flags.Usage()
+		return 2
+	}
+	query := flags.Arg(0)
+
+	err := loadDataFromFileOrSample()
+	if err != nil && !errors.Is(err, ErrDataEmpty) {
+		fmt.Fprintf(os.Stderr, "Failed to load data: %v\n", err)
+		return 1
+	}
+
+	var entries []Entry
+	var total int
+	if *byConcept {
+		entries = getEntriesByConceptSlug(getConceptSlug(query))
+		total = len(entries)
+		entries = paginate(entries, 1, *limit)
+	} else {
+		searchOptions := SearchOptions{Mode: *mode, Field: *field}
+		entries, total = getEntries(normalizeForSearch(query), searchOptions, 1, *limit)
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintln(stdout, renderEntryPlainText(entry))
+	}
+	fmt.Fprintf(stdout, "%d of %d entries\n", len(entries), total)
+
+	if total == 0 {
+		return 1
+	}
+	return 0
+}
+
+// runWhitelistCommand loads a data file and prints the lists of phrases that are split
+// incorrectly when rendered, so that maintainers can add them to PhrasesWhitelist.
+// Each line contains the phrase that is broken apart and the list, separated by a tab.
+// See getWhitelistCandidates.
+//
+// Postconditions:
+//   - Returns 0 if the data file is loaded, even if there are candidates
+//   - Returns 1 if the data file cannot be loaded
+//   - Returns 2 on invalid arguments
+func runWhitelistCommand(args []string, stdout io.Writer) int {
+	if len(args) > 1 {
+		fmt.Fprint(os.Stderr, CommandUsage)
+		return 2
+	}
+
+	dataFile := getEnvOrDefault("DATA_FILE", DefaultDataFile)
+	if len(args) == 1 {
+		dataFile = args[0]
+	}
+
+	err := loadDataFromFile(dataFile)
+	if err != nil && !errors.Is(err, ErrDataEmpty) {
+		fmt.Fprintf(os.Stderr, "Failed to load data: %v\n", err)
+		return 1
+	}
+
+	for _, candidate := range getWhitelistCandidates() {
+		fmt.Fprintf(stdout, "%s\t%s\n", candidate.Phrase, candidate.List)
+	}
+	return 0
+}
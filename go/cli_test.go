@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunValidateCommand(t *testing.T) {
+	var stdout bytes.Buffer
+	dataFile := writeGzippedTestFile(t, TestEntries)
+	exitCode := runCommand([]string{"validate", dataFile}, &stdout)
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0, output: %s", exitCode, stdout.String())
+	}
+	want := dataFile + ": 30 entries, 11 concepts, 0 problems (0 fatal)\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRunValidateCommandProblems(t *testing.T) {
+	validEntry := newTestEntry("CALLAR", "fer el mort")
+	validEntry.Definicio = "No dir res."
+	withoutDefinition := newTestEntry("CALLAR", "no badar boca")
+	withoutConcept := newTestEntry("", "fer-se el mort")
+	withoutConcept.Definicio = "No moure's."
+	withoutInitial := newTestEntry("1714", "caure Barcelona")
+	withoutInitial.Definicio = "Perdre les llibertats."
+	accentedNormalized := newTestEntry("CALLAR", "no dir ni piu")
+	accentedNormalized.Definicio = "No dir res."
+	accentedNormalized.TitleNormalizedWpc = "no dir ni piú"
+	uppercaseNormalized := newTestEntry("CALLAR", "Mut i a la gàbia")
+	uppercaseNormalized.Definicio = "Callar."
+	uppercaseNormalized.TitleNormalizedWp = "Mut i a la gabia"
+
+	tests := []struct {
+		name        string
+		entries     []Entry
+		wantCode    int
+		wantProblem string
+	}{
+		{"warnings only", []Entry{validEntry, withoutDefinition, validEntry}, 0, `WARNING: entry 2 ("fer el mort"): duplicate of entry 0`},
+		{"empty definition", []Entry{validEntry, withoutDefinition}, 0, `WARNING: entry 1 ("no badar boca"): empty definition`},
+		{"empty concept", []Entry{validEntry, withoutConcept}, 1, `FATAL: entry 1 ("fer-se el mort"): empty concept`},
+		{"concept without initial", []Entry{validEntry, withoutInitial}, 0, `WARNING: entry 1 ("caure Barcelona"): concept "1714" does not start with a letter, it is not listed in letter pages`},
+		{"accented normalized title", []Entry{validEntry, accentedNormalized}, 0, `WARNING: entry 1 ("no dir ni piu"): title_normalized_wpc "no dir ni piú" has uppercase or accented letters, it cannot be searched`},
+		{"uppercase normalized title", []Entry{validEntry, uppercaseNormalized}, 0, `WARNING: entry 1 ("Mut i a la gàbia"): title_normalized_wp "Mut i a la gabia" has uppercase or accented letters, it cannot be searched`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := json.Marshal(test.entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout bytes.Buffer
+			exitCode := runCommand([]string{"validate", writeGzippedTestFile(t, content)}, &stdout)
+			if exitCode != test.wantCode {
+				t.Errorf("exit code = %d, want %d", exitCode, test.wantCode)
+			}
+			if !strings.Contains(stdout.String(), test.wantProblem+"\n") {
+				t.Errorf("output = %q, want %q", stdout.String(), test.wantProblem)
+			}
+		})
+	}
+
+	// Files that cannot be loaded are fatal.
+	var stdout bytes.Buffer
+	exitCode := runCommand([]string{"validate", writeTestFile(t, []byte("not gzipped"))}, &stdout)
+	if exitCode != 1 || !strings.HasPrefix(stdout.String(), "FATAL: ") {
+		t.Errorf("validate of a corrupt file = %d, %q, want 1 and a fatal problem", exitCode, stdout.String())
+	}
+}
+
+func TestRunWhitelistCommand(t *testing.T) {
+	var stdout bytes.Buffer
+	exitCode := runCommand([]string{"whitelist", writeGzippedTestFile(t, TestEntries)}, &stdout)
+	if exitCode != 0 {
+		t.Fatalf("exit code = %d, want 0", exitCode)
+	}
+
+	// The Sinonims field of "a les dues, a les tres" is split into "a la una, a les dues"
+	// and "a les tres", which breaks apart "a les dues, a les tres".
+	want := "a les dues, a les tres\ta la una, a les dues, a les tres\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRunWhitelistCommandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"missing file", []string{"whitelist", "testdata/missing.json.gz"}, 1},
+		{"too many arguments", []string{"whitelist", "a.json.gz", "b.json.gz"}, 2},
+		{"unknown command", []string{"xyz"}, 2},
+		{"validate with too many arguments", []string{"validate", "a.json.gz", "b.json.gz"}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if got := runCommand(test.args, &stdout); got != test.want {
+				t.Errorf("exit code = %d, want %d", got, test.want)
+			}
+			if stdout.Len() != 0 {
+				t.Errorf("output = %q, want none", stdout.String())
+			}
+		})
+	}
+}
+
+func TestRunLookupCommand(t *testing.T) {
+	t.Setenv("DATA_FILE", writeGzippedTestFile(t, TestEntries))
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "search",
+			args:     []string{"lookup", "-mode", "Coincident", "-limit", "2", "fer el mort"},
+			wantCode: 0,
+			want: "fer el mort [sv] — CALLAR\n" +
+				"  no dir res per no comprometre's\n" +
+				"  Exemples: Quan li van preguntar pels diners, va fer el mort\n" +
+				"  Sinònims: no dir ni piu, tancar la boca\n" +
+				"\n" +
+				"fer el mort [sv] — ENGANYAR\n" +
+				"  fer veure que no se sap res\n" +
+				"  Exemples: Fa el mort per no pagar\n" +
+				"\n" +
+				"2 of 4 entries\n",
+		},
+		{
+			name:     "concept",
+			args:     []string{"lookup", "-concepte", "CAP1"},
+			wantCode: 0,
+			want: "de cap a peus [sp] — CAP1\n" +
+				"  completament\n" +
+				"  Exemples: Es va mullar de cap a peus\n" +
+				"\n" +
+				"1 of 1 entries\n",
+		},
+		{
+			name:     "no results",
+			args:     []string{"lookup", "xyzxyz"},
+			wantCode: 1,
+			want:     "0 of 0 entries\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if got := runCommand(test.args, &stdout); got != test.wantCode {
+				t.Errorf("exit code = %d, want %d", got, test.wantCode)
+			}
+			if got := stdout.String(); got != test.want {
+				t.Errorf("output = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRunLookupCommandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"missing query", []string{"lookup"}},
+		{"unknown mode", []string{"lookup", "-mode", "Desconegut", "mort"}},
+		{"invalid limit", []string{"lookup", "-limit", "0", "mort"}},
+		{"unknown flag", []string{"lookup", "-desconegut", "mort"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			if got := runCommand(test.args, &stdout); got != 2 {
+				t.Errorf("exit code = %d, want 2", got)
+			}
+		})
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
+	"maps"
 	"net/http"
 	"slices"
 	"strconv"
-
-	"golang.org/x/text/collate"
-	"golang.org/x/text/language"
+	"strings"
 )
 
 // basicPageHandler returns an HTTP handler function for rendering basic static pages.
@@ -34,36 +36,85 @@ func basicPageHandler(title string) http.HandlerFunc {
 			// No-op
 		}
 
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		err := MainTemplate.Execute(w, pageData)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			serveInternalError(w, r, err)
 		}
 	}
 }
 
 // searchHandler handles requests for the homepage and search queries.
-// It processes the search query, search mode, and pagination from the URL parameters,
+// It processes the search query, search mode, search field, and pagination from the URL parameters,
 // retrieves the corresponding dictionary entries, and renders the results using the main template.
 // If no query is provided, it displays the homepage.
 //
 // Additionally:
-//   - Serves a 404 page for non-root paths
 //   - Renders search results with proper pagination and sorting
 //   - Page numbers are normalized (invalid values default to 1)
+//   - Page sizes can be set with the mida parameter, up to MaxSearchPageSize, and
+//     otherwise default to the page size configured for the search mode
+//   - Queries shorter than MinQueryLength are not run, and a message is shown instead
+//   - Queries without results are retried with hyphens and spaces swapped, with a note
+//   - Rendered results are cached, see getSearchResultsPage
+//   - Results are grouped by concept if the agrupa parameter is set to GroupByConcept
+//   - New incorporations are excluded if the exclou parameter is set to ExcludeNovetats
+//   - Phrases without examples are excluded if the amb parameter is set to WithExemples
+//   - Only phrases of a category are kept if the categoria parameter is set to its key,
+//     and links to each category of the results are shown, see getCategoryFacets
+//   - Results keep the export order if the ordre parameter is set to OrderExport, for debugging
+//   - Results matching the accents of the query come first if the accents parameter is
+//     set to AccentsExact
+//   - Shows the concepts recently viewed by the client on the homepage
 func searchHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		serveNotFound(w)
-		return
-	}
-
 	// Add build date header to the homepage for debugging and tracking purposes.
 	if BuildDate != "" {
 		w.Header().Set("X-Build-Date", BuildDate)
 	}
 
+	pageData := getSearchPageData(r)
+
+	// Arbitrary queries make an infinite crawlable space, so only the bare homepage,
+	// and the concept and letter pages, are indexable.
+	pageData.NoIndex = pageData.SearchQuery != ""
+
+	if pageData.SearchQuery == "" {
+		recentConcepts := getRecentConcepts(r)
+		if len(recentConcepts) > 0 {
+			pageData.RecentConceptsHTML = template.HTML(renderConceptsByLetter(recentConcepts))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := MainTemplate.Execute(w, pageData)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// searchFragmentHandler renders only the search results and their pagination, as HTML
+// fragments, for htmx-style front-ends. The pagination is marked to be swapped
+// out-of-band, so that the client can update both regions from one request.
+// It takes the same query parameters as searchHandler.
+func searchFragmentHandler(w http.ResponseWriter, r *http.Request) {
+	pageData := getSearchPageData(r)
+	pageData.IsFragment = true
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := MainTemplate.ExecuteTemplate(w, "fragment", pageData)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// getSearchPageData processes the search query, search mode, search field, and pagination
+// from the URL parameters, and retrieves the corresponding page of results.
+func getSearchPageData(r *http.Request) PageData {
 	query := r.URL.Query().Get("frase")
-	searchMode := r.URL.Query().Get("mode")
+	searchOptions := getSearchOptions(r)
+	groupByConcept := r.URL.Query().Get("agrupa") == GroupByConcept
 	pageNumberParam := r.URL.Query().Get("pagina")
+	explicitPageSize := min(parsePositiveInt(r.URL.Query().Get("mida")), MaxSearchPageSize)
 
 	pageNumber := 1
 	parsedPageNumber, err := strconv.Atoi(pageNumberParam)
@@ -77,20 +128,60 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	pageData := PageData{
-		IsHomepage:   true,
-		SearchQuery:  query,
-		SearchMode:   searchMode,
-		SearchModes:  []string{SearchModeConte, SearchModeComencaPer, SearchModeAcabaEn, SearchModeCoincident},
-		Title:        title,
-		CurrentPage:  pageNumber,
-		CanonicalURL: getCanonicalURL(r),
+		IsHomepage:       true,
+		SearchQuery:      query,
+		SearchMode:       searchOptions.Mode,
+		SearchField:      searchOptions.Field,
+		GroupByConcept:   groupByConcept,
+		ExcludeNew:       searchOptions.ExcludeNew,
+		OnlyWithExamples: searchOptions.OnlyWithExamples,
+		Category:         searchOptions.Category,
+		ExportOrder:      searchOptions.ExportOrder,
+		AccentsExact:     searchOptions.AccentedQuery != "",
+		SearchFilters:    getSearchFilters(searchOptions, groupByConcept, explicitPageSize),
+		SearchModes:      SearchModes,
+		Title:            title,
+		CurrentPage:      pageNumber,
+		PageSize:         explicitPageSize,
+		CanonicalURL:     getCanonicalURL(r),
 	}
 
 	normalizedQuery := normalizeForSearch(query)
-	if normalizedQuery != "" {
-		entries, total := getEntries(normalizedQuery, searchMode, pageNumber, DefaultPageSize)
-		pageData.PhrasesHTML = template.HTML(renderEntriesForSearch(entries))
-		pageData.TotalPages = (total + DefaultPageSize - 1) / DefaultPageSize
+	if normalizedQuery != "" && isQueryTooShort(normalizedQuery, searchOptions.Mode) {
+		pageData.IsQueryTooShort = true
+		pageData.MinQueryLength = MinQueryLength
+	} else if normalizedQuery != "" {
+		// The search form always sends the mode, so default to it for links without
+		// one, so that both share the cached pages.
+		if searchOptions.Mode == "" {
+			searchOptions.Mode = SearchModeConte
+		}
+		pageSize := explicitPageSize
+		if pageSize == 0 {
+			pageSize = getDefaultPageSize(searchOptions.Mode)
+		}
+		searchResultsPage := getSearchResultsPage(normalizedQuery, searchOptions, groupByConcept, pageNumber, pageSize)
+		total := searchResultsPage.Total
+
+		pageData.PhrasesHTML = template.HTML(searchResultsPage.PhrasesHTML)
+		pageData.FallbackQuery = searchResultsPage.FallbackQuery
+		pageData.TotalResults = total
+		pageData.CategoryFacets = getCategoryFacets(searchResultsPage.CategoryCounts, searchOptions.Category, func(categoryKey string) string {
+			filters := maps.Clone(pageData.SearchFilters)
+			if categoryKey == "" {
+				filters.Del("categoria")
+			} else {
+				filters.Set("categoria", categoryKey)
+			}
+			return searchURL(query, searchOptions.Mode, 1, filters)
+		})
+		pageData.DidYouMean = searchResultsPage.DidYouMean
+		if searchResultsPage.DidYouMeanConcept != "" {
+			pageData.DidYouMeanURL = getConceptPath(searchResultsPage.DidYouMeanConcept)
+		} else if searchResultsPage.DidYouMean != "" {
+			pageData.DidYouMeanURL = searchURL(searchResultsPage.DidYouMean, searchOptions.Mode, 1, pageData.SearchFilters)
+		}
+		pageData.TotalPages = (total + pageSize - 1) / pageSize
 		if pageNumber > 1 {
 			pageData.PreviousPage = pageNumber - 1
 		}
@@ -99,10 +190,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = MainTemplate.Execute(w, pageData)
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-	}
+	return pageData
 }
 
 // letterHandler handles requests for browsing dictionary entries by the first letter of a concept.
@@ -111,17 +199,22 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 //
 // Additionally:
 //   - Serves a 404 page for invalid letters or letters with no concepts
+//   - Responds with 304 Not Modified if the client has the current version
 //   - Sorts concepts using the Catalan locale
 func letterHandler(w http.ResponseWriter, r *http.Request) {
 	letter := r.PathValue("letter")
 
 	if len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z' {
-		serveNotFound(w)
+		serveNotFound(w, r)
 		return
 	}
 
 	if len(ConceptsByFirstLetter[letter]) == 0 {
-		serveNotFound(w)
+		serveNotFound(w, r)
+		return
+	}
+
+	if checkNotModified(w, r) {
 		return
 	}
 
@@ -133,69 +226,751 @@ func letterHandler(w http.ResponseWriter, r *http.Request) {
 		CanonicalURL: getCanonicalURL(r),
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := MainTemplate.Execute(w, pageData)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// categoryHandler handles requests for browsing the phrases of a grammatical category.
+// It expects a URL path in the format /categoria/{key}, where {key} is a category key
+// of the export, e.g. "sv". It renders a page of the phrases of the category, grouped
+// by concept, with CategoryPageSize phrases per page.
+//
+// Additionally:
+//   - Serves a 404 page for unknown categories, categories with no phrases, and pages
+//     past the last one
+//   - Responds with 304 Not Modified if the client has the current version
+//   - Sorts phrases by concept and phrase, using the Catalan locale
+func categoryHandler(w http.ResponseWriter, r *http.Request) {
+	categoryKey := r.PathValue("key")
+	categoryName, exists := getCategoryNames()[categoryKey]
+	if !exists {
+		serveNotFound(w, r)
+		return
+	}
+
+	pageNumber := 1
+	pageNumberParam := r.URL.Query().Get("pagina")
+	if pageNumberParam != "" {
+		pageNumber = parsePositiveInt(pageNumberParam)
+	}
+
+	entries := getEntriesByCategory(categoryKey)
+	totalPages := (len(entries) + CategoryPageSize - 1) / CategoryPageSize
+	if pageNumber < 1 || pageNumber > totalPages {
+		serveNotFound(w, r)
+		return
+	}
+
+	if checkNotModified(w, r) {
+		return
+	}
+
+	pageData := PageData{
+		Title:          fmt.Sprintf("Categoria: %s", categoryName),
+		IsCategoryPage: true,
+		CategoryKey:    categoryKey,
+		CategoryName:   categoryName,
+		PhrasesHTML:    template.HTML(renderEntriesGroupedByConcept(paginate(entries, pageNumber, CategoryPageSize))),
+		CurrentPage:    pageNumber,
+		TotalPages:     totalPages,
+		TotalResults:   len(entries),
+		CanonicalURL:   BaseCanonicalURL + getCategoryPath(categoryKey, pageNumber),
+	}
+	if pageNumber > 1 {
+		pageData.PreviousPage = pageNumber - 1
+	}
+	if pageNumber < totalPages {
+		pageData.NextPage = pageNumber + 1
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err := MainTemplate.Execute(w, pageData)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		serveInternalError(w, r, err)
 	}
 }
 
+// letterTextHandler handles requests for downloading all the concepts of a letter and
+// their phrases as plain text, for printing or studying offline. It expects a URL path
+// in the format /lletra/{letter}/text, and validates the letter as letterHandler does.
+// See renderLetterPlainText.
+func letterTextHandler(w http.ResponseWriter, r *http.Request) {
+	letter := r.PathValue("letter")
+
+	if len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z' {
+		serveNotFound(w, r)
+		return
+	}
+
+	if len(ConceptsByFirstLetter[letter]) == 0 {
+		serveNotFound(w, r)
+		return
+	}
+
+	if checkNotModified(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dsff-lletra-%s.txt"`, letter))
+	_, _ = io.WriteString(w, renderLetterPlainText(letter))
+}
+
 // conceptHandler handles requests for displaying all phrases related to a specific concept.
 // It expects a URL path in the format /concepte/{conceptSlug}, where {conceptSlug} is the
 // URL-friendly version of the concept name. It retrieves all entries for that concept and
 // renders them on a dedicated concept page.
 //
 // Additionally:
-//   - Serves a 404 page if no entries found for the concept
+//   - Redirects to the canonical path if it does not follow the trailing slash policy,
+//     or if the slug is not normalized, e.g. uppercase or with stray spaces
+//   - Serves a 404 page if no entries found for the concept, or a 410 page if the
+//     concept has been retired
+//   - Responds with 304 Not Modified if the client has the current version
 //   - Sorts entries by accepció, antònim, and phrase
+//   - Filters the phrases by the frase query parameter, if present
+//   - Filters the phrases by the exclou, amb, and categoria query parameters, as in
+//     searchHandler, and links to each category of the phrases
+//   - Highlights the phrase whose slug is given in the destaca query parameter, if present
+//   - Adds the concept to the client's recently viewed concepts cookie
+//   - Links to the other senses of the concept, if it is a homograph
 func conceptHandler(w http.ResponseWriter, r *http.Request) {
+	if redirectToCanonicalConceptPath(w, r) {
+		return
+	}
+
 	entries := getEntriesByConceptSlug(r.PathValue("concept"))
+	if len(entries) == 0 && RetiredConceptSlugs[getConceptSlug(r.PathValue("concept"))] {
+		serveGone(w, r)
+		return
+	}
 	if len(entries) == 0 {
-		serveNotFound(w)
+		serveNotFound(w, r)
+		return
+	}
+
+	// Entries of the same concept may spell it inconsistently, so use its
+	// representative spelling, which does not depend on the order of the entries.
+	concept := getRepresentativeConcept(entries[0].Concepte)
+
+	setRecentConceptsCookie(w, r, concept)
+
+	if checkNotModified(w, r) {
 		return
 	}
 
 	// Sort entries for this concept by accepció, antònim, and phrase.
-	// This ensures a consistent and logical order for display.
-	collator := collate.New(language.Catalan)
-	slices.SortFunc(entries, func(a, b Entry) int {
-		// 1) Compare by the numbered meaning from the concept.
-		comparison := collator.CompareString(a.AccepcioConcepte, b.AccepcioConcepte)
-		if comparison != 0 {
-			return comparison
-		}
-
-		// 2) Put antonyms at the end.
-		if a.AntonimConcepte != b.AntonimConcepte {
-			if a.AntonimConcepte {
-				return 1
-			}
-			return -1
-		}
+	// This ensures a consistent and logical order for display. For debugging, the
+	// entries can be kept in export order instead.
+	exportOrder := r.URL.Query().Get("ordre") == OrderExport
+	if !exportOrder {
+		sortConceptEntries(entries)
+	}
 
-		// 3) Compare by phrase without parentheses content.
-		return collator.CompareString(a.TitleNormalizedWpc, b.TitleNormalizedWpc)
+	// Optionally, filter the phrases of this concept.
+	query := r.URL.Query().Get("frase")
+	normalizedQuery := normalizeForSearch(query)
+	if normalizedQuery != "" {
+		entries = filterEntries(entries, normalizedQuery, SearchOptions{Mode: r.URL.Query().Get("mode")})
+	}
+	excludeNew := r.URL.Query().Get("exclou") == ExcludeNovetats
+	if excludeNew {
+		entries = slices.DeleteFunc(entries, func(entry Entry) bool {
+			return entry.NovaIncorporacio
+		})
+	}
+	withExamples := r.URL.Query().Get("amb") == WithExemples
+	if withExamples {
+		entries = slices.DeleteFunc(entries, func(entry Entry) bool {
+			return !hasExamples(entry)
+		})
+	}
+
+	// Count the phrases of each category before filtering by category, so that
+	// the links to the other categories keep their counts.
+	category := r.URL.Query().Get("categoria")
+	categoryFacets := getCategoryFacets(countEntriesByCategory(entries), category, func(categoryKey string) string {
+		values := r.URL.Query()
+		if categoryKey == "" {
+			values.Del("categoria")
+		} else {
+			values.Set("categoria", categoryKey)
+		}
+		if len(values) == 0 {
+			return getConceptPath(concept)
+		}
+		return getConceptPath(concept) + "?" + values.Encode()
 	})
+	if category != "" {
+		entries = slices.DeleteFunc(entries, func(entry Entry) bool {
+			return entry.Categoria != category
+		})
+	}
 
 	pageData := PageData{
-		Title:         getConceptTitle(entries[0].Concepte),
-		IsConceptPage: true,
-		Concept:       template.HTML(getConceptTitleHTML(entries[0].Concepte)),
-		PhrasesHTML:   template.HTML(renderEntriesForConceptPage(entries)),
-		CanonicalURL:  getCanonicalURL(r),
+		Title:            getConceptTitle(concept),
+		IsConceptPage:    true,
+		Concept:          template.HTML(getConceptTitleHTML(concept)),
+		Homographs:       template.HTML(renderHomographs(getHomographs(concept))),
+		PhrasesHTML:      template.HTML(renderEntriesForConceptPage(entries, r.URL.Query().Get("destaca"))),
+		SearchQuery:      query,
+		ExcludeNew:       excludeNew,
+		OnlyWithExamples: withExamples,
+		Category:         category,
+		CategoryFacets:   categoryFacets,
+		ExportOrder:      exportOrder,
+		CanonicalURL:     getCanonicalURL(r),
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err := MainTemplate.Execute(w, pageData)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		serveInternalError(w, r, err)
+	}
+}
+
+// exportJSONHandler streams the dictionary entries as a JSON array, in export order.
+// Entries are encoded one at a time, so the whole response is never buffered.
+//
+// Additionally:
+//   - Returns a single page of entries if the mida (and pagina) query parameters are present
+//   - Sets the X-Total-Count header to the total number of entries, so clients can gauge progress
+//   - Responds with 304 Not Modified if the client has the current version of the data,
+//     see getDataETag
+func exportJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if checkETagNotModified(w, r, getDataETag(r)) {
+		return
+	}
+
+	entries := getExportEntries(r)
+	totalCount := len(AllEntries)
+	// Streaming to slow clients can take long, so do not make reloads wait for it.
+	releaseDataLock(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(totalCount))
+
+	// Stop streaming on write errors, as the client has probably gone away.
+	_, err := io.WriteString(w, "[")
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(w)
+	for i, entry := range entries {
+		if i > 0 {
+			_, err = io.WriteString(w, ",")
+			if err != nil {
+				return
+			}
+		}
+		err = encoder.Encode(entry)
+		if err != nil {
+			return
+		}
+	}
+	_, _ = io.WriteString(w, "]\n")
+}
+
+// exportCSVHandler streams the dictionary entries as CSV, in export order, for opening
+// them in a spreadsheet. The header row has the names of the fields of the JSON export,
+// see EntryCSVColumns. Rows are written one at a time, so the whole response is never
+// buffered.
+//
+// Additionally:
+//   - Only exports the entries of the concepts listed under a letter if the lletra
+//     query parameter is set, e.g. "A", see getConceptInitial
+//   - Responds with 400 Bad Request if the letter is not a single letter from A to Z
+//   - Sets the X-Total-Count header to the number of exported entries
+//   - Responds with 304 Not Modified if the client has the current version of the data,
+//     see getDataETag
+func exportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	letter := strings.ToUpper(r.URL.Query().Get("lletra"))
+	if letter != "" && (len(letter) != 1 || letter[0] < 'A' || letter[0] > 'Z') {
+		http.Error(w, "The lletra parameter must be a letter from A to Z", http.StatusBadRequest)
+		return
+	}
+
+	if checkETagNotModified(w, r, getDataETag(r)) {
+		return
+	}
+
+	entries := AllEntries
+	if letter != "" {
+		entries = nil
+		for _, entry := range AllEntries {
+			initial, hasInitial := getConceptInitial(entry.Concepte)
+			if hasInitial && initial == letter {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	// Streaming to slow clients can take long, so do not make reloads wait for it.
+	releaseDataLock(r)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="dsff.csv"`)
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(entries)))
+
+	// Stop streaming on write errors, as the client has probably gone away.
+	csvWriter := csv.NewWriter(w)
+	err := csvWriter.Write(EntryCSVColumns)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		err = csvWriter.Write(getEntryCSVRecord(entry))
+		if err != nil {
+			return
+		}
+	}
+	csvWriter.Flush()
+}
+
+// synonymsHandler returns the synonyms of the phrase in the frase query parameter as
+// a JSON array. Synonyms are taken from the Sinonims field of the entries whose phrase
+// matches it exactly, and are de-duplicated. This is meant for tools that need quick
+// synonym suggestions.
+func synonymsHandler(w http.ResponseWriter, r *http.Request) {
+	synonyms := getSynonyms(r.URL.Query().Get("frase"))
+	if synonyms == nil {
+		// Encode an empty array rather than null.
+		synonyms = []Synonym{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(synonyms)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// letterIndexHandler returns, as JSON, the initial letters of the concepts with their
+// number of concepts, sorted alphabetically. This is useful for rendering a navigation
+// bar of letters.
+//
+// Additionally:
+//   - Responds with 304 Not Modified if the client has the current version
+func letterIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(getLetterCounts())
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// conceptsByLetterHandler returns, as JSON, the concepts of each initial letter along
+// with their slugs and paths. This is meant for generating a static version of the site.
+//
+// Additionally:
+//   - Responds with 304 Not Modified if the client has the current version
+func conceptsByLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if checkNotModified(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(getConceptsByLetter())
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// apiSearchHandler returns, as JSON, a page of the results of a search, with the entries
+// as in the export rather than rendered. The search and the page are given by the same
+// query parameters as in searchHandler, and results are sorted in the same way.
+//
+// Additionally:
+//   - Renders the fields of the entries as HTML if the render parameter is set to RenderHTML,
+//     and adds the whole entry rendered as on the HTML pages in their html field
+//   - Responds with 400 Bad Request if the query is missing or too short
+//   - Returns an empty array of entries, not null, if nothing is found
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	normalizedQuery := normalizeForSearch(r.URL.Query().Get("frase"))
+	searchOptions := getSearchOptions(r)
+	if normalizedQuery == "" {
+		serveJSONError(w, r, http.StatusBadRequest, "Missing frase parameter")
+		return
+	}
+	if isQueryTooShort(normalizedQuery, searchOptions.Mode) {
+		serveJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("The frase parameter must have at least %d characters", MinQueryLength))
+		return
+	}
+
+	page := max(parsePositiveInt(r.URL.Query().Get("pagina")), 1)
+	pageSize := min(parsePositiveInt(r.URL.Query().Get("mida")), MaxSearchPageSize)
+	if pageSize == 0 {
+		pageSize = getDefaultPageSize(searchOptions.Mode)
+	}
+
+	entries, total := getEntries(normalizedQuery, searchOptions, page, pageSize)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(SearchAPIResponse{
+		Total:      total,
+		Page:       page,
+		TotalPages: (total + pageSize - 1) / pageSize,
+		Entries:    getAPIEntries(r, entries),
+	})
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// dataVersionHandler returns, as JSON, the version of the loaded data, i.e. a hash of
+// its contents, so that clients that keep a copy of the export can detect changes
+// without downloading it again.
+func dataVersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	err := json.NewEncoder(w).Encode(DataVersionResponse{
+		Version:    DataVersion,
+		EntryCount: len(AllEntries),
+	})
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// apiConceptHandler returns, as JSON, a concept and its entries, in the same order as on
+// the concept page. Entries are returned as in the export, unless the render parameter
+// is set to RenderHTML. See getAPIEntries.
+//
+// Additionally:
+//   - Paginates the entries if the mida (page size) parameter is set, up to
+//     MaxSearchPageSize, returning the page given by the pagina parameter
+//   - Lists the concepts that share phrases with this one, see getRelatedConcepts
+//   - Responds with 404 Not Found if the concept does not exist
+//   - Responds with 304 Not Modified if the client has the current version
+func apiConceptHandler(w http.ResponseWriter, r *http.Request) {
+	entries := getEntriesByConceptSlug(r.PathValue("concept"))
+	if len(entries) == 0 {
+		serveJSONError(w, r, http.StatusNotFound, "Concept not found")
+		return
+	}
+
+	if checkNotModified(w, r) {
+		return
+	}
+
+	sortConceptEntries(entries)
+
+	// Return all entries in a single page, unless a page size is given.
+	total := len(entries)
+	page := max(parsePositiveInt(r.URL.Query().Get("pagina")), 1)
+	pageSize := min(parsePositiveInt(r.URL.Query().Get("mida")), MaxSearchPageSize)
+	if pageSize == 0 {
+		page, pageSize = 1, total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	conceptSlug := getConceptSlug(entries[0].Concepte)
+	err := encoder.Encode(ConceptAPIResponse{
+		Concept:    getRepresentativeConcept(entries[0].Concepte),
+		Total:      total,
+		Page:       page,
+		TotalPages: (total + pageSize - 1) / pageSize,
+		Entries:    getAPIEntries(r, paginate(entries, page, pageSize)),
+		Related:    getRelatedConcepts(conceptSlug, entries),
+	})
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// suggestionsHandler returns, as JSON, an array of the phrases starting with the q query
+// parameter, for suggesting them as the user types in the search box. See getSuggestions.
+func suggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(getSuggestions(normalizeForSearch(r.URL.Query().Get("q"))))
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// openSearchSuggestionsHandler returns the phrases starting with the q query parameter in
+// the OpenSearch Suggestions format, [query, [completions], [descriptions], [urls]], so
+// that browsers can show them as the user types in the address bar. The phrases are
+// the same as in suggestionsHandler, and the URLs are searches for each of them.
+func openSearchSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	suggestions := getSuggestions(normalizeForSearch(query))
+	descriptions := make([]string, len(suggestions))
+	urls := make([]string, len(suggestions))
+	for i, suggestion := range suggestions {
+		urls[i] = BaseCanonicalURL + getPhraseSearchPath(suggestion)
+	}
+
+	w.Header().Set("Content-Type", "application/x-suggestions+json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode([]any{query, suggestions, descriptions, urls})
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// searchPositionHandler returns, as JSON, the position of a phrase among the results of
+// a search. The search is given by the frase, mode, camp, exclou, amb, categoria,
+// and ordre query parameters, as in searchHandler, and the phrase to look up by the
+// entrada query parameter.
+//
+// Additionally:
+//   - Responds with 400 Bad Request if either parameter is missing, or the query is
+//     too short, as in searchHandler
+//   - Responds with 404 Not Found if the phrase is not among the results
+func searchPositionHandler(w http.ResponseWriter, r *http.Request) {
+	normalizedQuery := normalizeForSearch(r.URL.Query().Get("frase"))
+	searchOptions := getSearchOptions(r)
+	phrase := r.URL.Query().Get("entrada")
+	if normalizedQuery == "" || phrase == "" {
+		serveJSONError(w, r, http.StatusBadRequest, "Missing frase or entrada parameter")
+		return
+	}
+	if isQueryTooShort(normalizedQuery, searchOptions.Mode) {
+		serveJSONError(w, r, http.StatusBadRequest, fmt.Sprintf("The frase parameter must have at least %d characters", MinQueryLength))
+		return
+	}
+
+	results := getAllSearchResults(normalizedQuery, searchOptions)
+	result, found := findSearchResult(results, phrase)
+	if !found {
+		serveJSONError(w, r, http.StatusNotFound, "Phrase not found among the results")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(SearchPosition{
+		Phrase:   result.Entry.Title,
+		Concept:  result.Entry.Concepte,
+		Position: result.Position,
+		Total:    len(results),
+	})
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// compareConceptsHandler returns, as JSON, the phrases shared by the two concepts given
+// by the a and b query parameters, as slugs, and those unique to each. This helps to
+// find overlaps between near-synonym concepts.
+//
+// Additionally:
+//   - Responds with 400 Bad Request if either slug is missing
+//   - Responds with 404 Not Found if either concept does not exist
+func compareConceptsHandler(w http.ResponseWriter, r *http.Request) {
+	slugA := r.URL.Query().Get("a")
+	slugB := r.URL.Query().Get("b")
+	if slugA == "" || slugB == "" {
+		serveJSONError(w, r, http.StatusBadRequest, "Missing a or b parameter")
+		return
+	}
+
+	entriesA := getEntriesByConceptSlug(slugA)
+	entriesB := getEntriesByConceptSlug(slugB)
+	if len(entriesA) == 0 || len(entriesB) == 0 {
+		serveJSONError(w, r, http.StatusNotFound, "Concept not found")
+		return
+	}
+
+	comparison := compareConcepts(
+		getRepresentativeConcept(entriesA[0].Concepte), entriesA,
+		getRepresentativeConcept(entriesB[0].Concepte), entriesB,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(comparison)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// openSearchHandler renders the OpenSearch description document, which lets browsers
+// add the dictionary as a search engine. The short name and description are configurable,
+// and the description includes the number of entries in the dictionary.
+func openSearchHandler(w http.ResponseWriter, r *http.Request) {
+	openSearchData := OpenSearchData{
+		ShortName:   OpenSearchShortName,
+		Description: OpenSearchDescription,
+		BaseURL:     BaseCanonicalURL,
+		EntryCount:  len(AllEntries),
+	}
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	err := OpenSearchTemplate.Execute(w, openSearchData)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// sitemapHandler renders the sitemap of the site, listing the homepage and all letter
+// and concept pages, so that search engines do not need to crawl them.
+//
+// Additionally:
+//   - If there are more than MaxSitemapURLs pages, renders a sitemap index instead,
+//     pointing to the pages of the sitemap, given by the pagina query parameter
+//   - Serves a 404 page for pages out of range
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	sitemapData := SitemapData{LastMod: BuildDate}
+	urls := getSitemapURLs()
+
+	pageNumberParam := r.URL.Query().Get("pagina")
+	if pageNumberParam != "" {
+		pageNumber := parsePositiveInt(pageNumberParam)
+		if pageNumber > 0 {
+			sitemapData.URLs = paginate(urls, pageNumber, MaxSitemapURLs)
+		}
+		if sitemapData.URLs == nil {
+			serveNotFound(w, r)
+			return
+		}
+	} else if len(urls) > MaxSitemapURLs {
+		sitemapData.Sitemaps = getSitemapIndexURLs(len(urls), MaxSitemapURLs)
+	} else {
+		sitemapData.URLs = urls
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	err := SitemapTemplate.Execute(w, sitemapData)
+	if err != nil {
+		serveInternalError(w, r, err)
+	}
+}
+
+// robotsHandler renders the robots.txt file. Production deployments allow crawling
+// everything but the health checks, and point to the sitemap. Other deployments, e.g.
+// staging, block all crawling, so that they are not indexed. See isProductionRequest.
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if !isProductionRequest(r) {
+		_, _ = io.WriteString(w, "# Block crawling of non-production deployments\nUser-agent: *\nDisallow: /\n")
+		return
+	}
+
+	_, _ = io.WriteString(w, "# Allow crawling of all content, except health checks\nUser-agent: *\n")
+	for _, path := range HealthCheckPaths {
+		_, _ = fmt.Fprintf(w, "Disallow: %s\n", path)
+	}
+	_, _ = fmt.Fprintf(w, "\nSitemap: %s/sitemap.xml\n", BaseCanonicalURL)
+}
+
+// healthHandler reports whether the server is healthy, for monitoring purposes.
+// It responds with 503 Service Unavailable and "degraded" if no entries are loaded,
+// as every search would silently return nothing.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !isDataLoaded() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, "degraded: no entries loaded\n")
+		return
+	}
+
+	_, _ = io.WriteString(w, "ok\n")
+}
+
+// livenessHandler responds with 200 OK as long as the server is up, for the liveness
+// probe of container orchestrators. Unlike healthHandler and readinessHandler, it does
+// not depend on the loaded data, so that the server is not restarted because of it.
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeProbeResponse(w, r, http.StatusOK, ProbeStatusOK)
+}
+
+// readinessHandler responds with 200 OK once the dictionary data has been loaded, for
+// the readiness probe of container orchestrators. It responds with 503 Service
+// Unavailable while no entries are loaded, so that no traffic is routed to the server.
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if !isDataLoaded() {
+		writeProbeResponse(w, r, http.StatusServiceUnavailable, ProbeStatusNotReady)
+		return
+	}
+
+	writeProbeResponse(w, r, http.StatusOK, ProbeStatusOK)
+}
+
+// writeProbeResponse writes the JSON response of the liveness and readiness probes.
+func writeProbeResponse(w http.ResponseWriter, r *http.Request, statusCode int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(ProbeResponse{Status: status})
+	if err != nil {
+		getRequestLogger(r).Error("Failed to write probe response", "error", err)
+	}
+}
+
+// serveInternalError logs an error with the context of the request, and responds
+// with a generic 500 Internal Server Error.
+func serveInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	getRequestLogger(r).Error("Failed to serve request", "error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// serveJSONError responds with an error of the JSON API, as {"error": message}, so that
+// API clients can always decode the response. See ErrorResponse.
+func serveJSONError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+	if err != nil {
+		getRequestLogger(r).Error("Failed to write error response", "error", err)
+	}
+}
+
+// serveGone renders a 410 Gone error page, for concepts permanently removed from the dictionary.
+func serveGone(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusGone)
+
+	err := GoneTemplate.Execute(w, nil)
+	if err != nil {
+		serveInternalError(w, r, err)
 	}
 }
 
 // serveNotFound renders a standard 404 Not Found error page.
-func serveNotFound(w http.ResponseWriter) {
+// It is also registered as the handler of all unknown paths.
+//
+// Additionally:
+//   - API requests get a JSON error instead, see isAPIRequest
+//   - Requests for missing static files get a bare plain text error, see isAssetRequest
+func serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		serveJSONError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
+	if isAssetRequest(r) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusNotFound)
 
 	err := NotFoundTemplate.Execute(w, nil)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		serveInternalError(w, r, err)
 	}
 }
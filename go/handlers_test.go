@@ -0,0 +1,2207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSearchHandlerMinQueryLength(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousMinQueryLength := MinQueryLength
+	t.Cleanup(func() {
+		MinQueryLength = previousMinQueryLength
+	})
+	MinQueryLength = 3
+
+	tests := []struct {
+		name      string
+		query     string
+		mode      string
+		wantShort bool
+	}{
+		{name: "below", query: "fe", mode: SearchModeConte, wantShort: true},
+		{name: "below, before normalization", query: " (fe) ", mode: SearchModeConte, wantShort: true},
+		{name: "at", query: "fer", mode: SearchModeConte},
+		{name: "above", query: "fer el", mode: SearchModeConte},
+		{name: "below, coincident", query: "fe", mode: SearchModeCoincident},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serveTestRequest(searchHandler, "/?"+url.Values{"frase": {test.query}, "mode": {test.mode}}.Encode())
+			if response.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", response.Code, http.StatusOK)
+			}
+
+			body := response.Body.String()
+			isShort := strings.Contains(body, "Introduïu almenys 3 caràcters.")
+			if isShort != test.wantShort {
+				t.Errorf("shows the minimum length message = %t, want %t", isShort, test.wantShort)
+			}
+			hasResults := strings.Contains(body, `<article class="entry frase">`)
+			if test.wantShort && hasResults {
+				t.Error("shows results for a query that is too short")
+			}
+			if !test.wantShort && test.mode == SearchModeConte && !hasResults {
+				t.Error("shows no results for a query that is long enough")
+			}
+		})
+	}
+}
+
+func TestConceptHandlerFilter(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	tests := []struct {
+		target      string
+		wantPhrases []string
+		wantMissing []string
+	}{
+		{
+			target:      "/concepte/callar",
+			wantPhrases: []string{"fer el mort", "no fer el mort", "no dir ni piu", "tancar la boca"},
+		},
+		{
+			target:      "/concepte/callar?frase=",
+			wantPhrases: []string{"fer el mort", "no fer el mort", "no dir ni piu", "tancar la boca"},
+		},
+		{
+			target:      "/concepte/callar?frase=Mort",
+			wantPhrases: []string{"fer el mort", "no fer el mort"},
+			wantMissing: []string{"no dir ni piu", "tancar la boca"},
+		},
+		{
+			target:      "/concepte/callar?frase=no&mode=" + url.QueryEscape(SearchModeComencaPer),
+			wantPhrases: []string{"no fer el mort", "no dir ni piu"},
+			wantMissing: []string{"tancar la boca"},
+		},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(mux.ServeHTTP, test.target)
+		if response.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", test.target, response.Code, http.StatusOK)
+			continue
+		}
+		body := response.Body.String()
+		for _, phrase := range test.wantPhrases {
+			if !hasEntry(body, phrase) {
+				t.Errorf("GET %s does not have %q", test.target, phrase)
+			}
+		}
+		for _, phrase := range test.wantMissing {
+			if hasEntry(body, phrase) {
+				t.Errorf("GET %s has %q, which does not match the filter", test.target, phrase)
+			}
+		}
+	}
+}
+
+func TestConceptHandlerHighlight(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	tests := []struct {
+		target string
+		want   string // The id of the highlighted entry, if any.
+	}{
+		{target: "/concepte/callar?destaca=no_dir_ni_piu", want: "no_dir_ni_piu"},
+		{target: "/concepte/callar?destaca=fer_el_mort_davant_d'algu", want: "fer_el_mort_davant_d'algu"},
+		{target: "/concepte/callar?destaca=no_existeix"},
+		{target: "/concepte/callar"},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(mux.ServeHTTP, test.target).Body.String()
+		highlighted := highlightedEntryRegexp.FindAllStringSubmatch(body, -1)
+		if test.want == "" {
+			if len(highlighted) > 0 {
+				t.Errorf("GET %s highlights %q, want none", test.target, highlighted[0][1])
+			}
+			continue
+		}
+		if len(highlighted) != 1 || highlighted[0][1] != test.want {
+			t.Errorf("GET %s highlights %q, want %q only", test.target, highlighted, test.want)
+		}
+	}
+
+	// Search results link to the entry on the concept page.
+	body := serveTestRequest(searchHandler, "/?frase=dir+ni+piu").Body.String()
+	want := `href="/concepte/callar?destaca=no_dir_ni_piu#no_dir_ni_piu"`
+	if !strings.Contains(body, want) {
+		t.Errorf("search results do not link with %s", want)
+	}
+}
+
+// highlightedEntryRegexp matches the highlighted entry of a concept page, capturing its id.
+var highlightedEntryRegexp = regexp.MustCompile(`<article class="entry frase(?: repetida)? destacada" id="([^"]*)">`)
+
+func TestExportJSONHandler(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		target    string
+		wantStart int // Index in AllEntries of the first entry.
+		wantCount int
+	}{
+		{target: "/export.json", wantStart: 0, wantCount: len(AllEntries)},
+		{target: "/export.json?mida=7", wantStart: 0, wantCount: 7},
+		{target: "/export.json?mida=7&pagina=2", wantStart: 7, wantCount: 7},
+		{target: "/export.json?mida=7&pagina=5", wantStart: 28, wantCount: len(AllEntries) - 28},
+		{target: "/export.json?mida=7&pagina=6", wantCount: 0},
+		{target: "/export.json?mida=7&pagina=x", wantStart: 0, wantCount: 7},
+		{target: "/export.json?mida=0", wantStart: 0, wantCount: len(AllEntries)},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(exportJSONHandler, test.target)
+		if response.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("GET %s has Content-Type %q", test.target, response.Header().Get("Content-Type"))
+		}
+		if response.Header().Get("X-Total-Count") != strconv.Itoa(len(AllEntries)) {
+			t.Errorf("GET %s has X-Total-Count %q, want %d", test.target, response.Header().Get("X-Total-Count"), len(AllEntries))
+		}
+
+		var entries []Entry
+		err := json.Unmarshal(response.Body.Bytes(), &entries)
+		if err != nil {
+			t.Errorf("GET %s is not a JSON array of entries: %v", test.target, err)
+			continue
+		}
+		if len(entries) != test.wantCount {
+			t.Errorf("GET %s returned %d entries, want %d", test.target, len(entries), test.wantCount)
+			continue
+		}
+		for i, entry := range entries {
+			want := AllEntries[test.wantStart+i]
+			if entry.Title != want.Title || entry.Concepte != want.Concepte {
+				t.Errorf("GET %s returned %q at %d, want %q", test.target, entry.Title, i, want.Title)
+			}
+		}
+	}
+}
+
+func TestExportCSVHandler(t *testing.T) {
+	loadTestData(t)
+
+	countEntries := func(letter string) int {
+		count := 0
+		for _, entry := range AllEntries {
+			if initial, hasInitial := getConceptInitial(entry.Concepte); hasInitial && initial == letter {
+				count++
+			}
+		}
+		return count
+	}
+	tests := []struct {
+		target    string
+		wantCount int
+	}{
+		{target: "/export.csv", wantCount: len(AllEntries)},
+		{target: "/export.csv?lletra=A", wantCount: countEntries("A")},
+		{target: "/export.csv?lletra=c", wantCount: countEntries("C")},
+		{target: "/export.csv?lletra=Q", wantCount: 0},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(exportCSVHandler, test.target)
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", test.target, response.Code, http.StatusOK)
+		}
+		if contentType := response.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+			t.Errorf("GET %s has Content-Type %q", test.target, contentType)
+		}
+		if disposition := response.Header().Get("Content-Disposition"); disposition != `attachment; filename="dsff.csv"` {
+			t.Errorf("GET %s has Content-Disposition %q", test.target, disposition)
+		}
+		if totalCount := response.Header().Get("X-Total-Count"); totalCount != strconv.Itoa(test.wantCount) {
+			t.Errorf("GET %s has X-Total-Count %q, want %d", test.target, totalCount, test.wantCount)
+		}
+
+		records, err := csv.NewReader(response.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("GET %s is not CSV: %v", test.target, err)
+		}
+		if len(records) == 0 || !slices.Equal(records[0], EntryCSVColumns) {
+			t.Fatalf("GET %s has no header row of EntryCSVColumns", test.target)
+		}
+		if len(records)-1 != test.wantCount {
+			t.Errorf("GET %s has %d rows, want %d", test.target, len(records)-1, test.wantCount)
+		}
+	}
+	if test := tests[1]; test.wantCount == 0 || test.wantCount == len(AllEntries) {
+		t.Errorf("the letter A has %d of the %d entries, want some of them", test.wantCount, len(AllEntries))
+	}
+
+	// The rows parse back to the fields of the JSON export, with the same names.
+	records, err := csv.NewReader(serveTestRequest(exportCSVHandler, "/export.csv").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range records[1:] {
+		row := map[string]any{}
+		for j, column := range EntryCSVColumns {
+			row[column] = record[j]
+		}
+		for _, column := range []string{"antonim_concepte", "nova_incorporacio"} {
+			row[column] = row[column] == "true"
+		}
+		row["changed"] = 0
+		content, err := json.Marshal(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entry Entry
+		err = json.Unmarshal(content, &entry)
+		if err != nil {
+			t.Fatalf("row %d does not have the fields of the JSON export: %v", i, err)
+		}
+		want := AllEntries[i]
+		if entry.Title != want.Title || entry.Concepte != want.Concepte || entry.NovaIncorporacio != want.NovaIncorporacio ||
+			entry.Definicio != want.Definicio || entry.Exemples != want.Exemples || entry.Sinonims != want.Sinonims {
+			t.Errorf("row %d = %+v, want the fields of %q", i, record, want.Title)
+		}
+	}
+
+	for _, target := range []string{"/export.csv?lletra=AB", "/export.csv?lletra=1", "/export.csv?lletra=%C3%80"} {
+		if code := serveTestRequest(exportCSVHandler, target).Code; code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want %d", target, code, http.StatusBadRequest)
+		}
+	}
+
+	// The ETag is that of the data, as in the JSON export.
+	etag := serveTestRequest(exportCSVHandler, "/export.csv").Header().Get("ETag")
+	request := httptest.NewRequest(http.MethodGet, "/export.csv", nil)
+	request.Header.Set("If-None-Match", etag)
+	recorder := httptest.NewRecorder()
+	exportCSVHandler(recorder, request)
+	if etag == "" || recorder.Code != http.StatusNotModified {
+		t.Errorf("GET /export.csv with the current ETag %q = %d, want %d", etag, recorder.Code, http.StatusNotModified)
+	}
+}
+
+func TestOpenSearchHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousShortName, previousDescription := OpenSearchShortName, OpenSearchDescription
+	t.Cleanup(func() {
+		OpenSearchShortName, OpenSearchDescription = previousShortName, previousDescription
+	})
+	OpenSearchShortName = "Frases & sinònims"
+	OpenSearchDescription = "Cerca <frases> fetes"
+
+	response := serveTestRequest(openSearchHandler, "/opensearch.xml")
+	if response.Header().Get("Content-Type") != "application/opensearchdescription+xml" {
+		t.Errorf("Content-Type = %q", response.Header().Get("Content-Type"))
+	}
+
+	var description struct {
+		ShortName   string
+		Description string
+		URLs        []struct {
+			Type     string `xml:"type,attr"`
+			Template string `xml:"template,attr"`
+		} `xml:"Url"`
+	}
+	err := xml.Unmarshal(response.Body.Bytes(), &description)
+	if err != nil {
+		t.Fatalf("opensearch.xml is not valid XML: %v\n%s", err, response.Body)
+	}
+	if description.ShortName != OpenSearchShortName {
+		t.Errorf("ShortName = %q, want %q", description.ShortName, OpenSearchShortName)
+	}
+	wantDescription := OpenSearchDescription + " Conté " + strconv.Itoa(len(AllEntries)) + " frases fetes."
+	if description.Description != wantDescription {
+		t.Errorf("Description = %q, want %q", description.Description, wantDescription)
+	}
+	wantTemplates := map[string]string{
+		"text/html":                      BaseCanonicalURL + "/?frase={searchTerms}",
+		"application/x-suggestions+json": BaseCanonicalURL + "/api/suggeriments/opensearch?q={searchTerms}",
+	}
+	if len(description.URLs) != len(wantTemplates) {
+		t.Errorf("got %d Url elements, want %d", len(description.URLs), len(wantTemplates))
+	}
+	for _, url := range description.URLs {
+		if url.Template != wantTemplates[url.Type] {
+			t.Errorf("Url template of type %q = %q, want %q", url.Type, url.Template, wantTemplates[url.Type])
+		}
+	}
+}
+
+func TestOpenSearchSuggestionsHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/api/suggeriments/opensearch?q=Fer+el+M")
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/x-suggestions+json" {
+		t.Errorf("Content-Type = %q, want %q", contentType, "application/x-suggestions+json")
+	}
+	var suggestions []json.RawMessage
+	err := json.Unmarshal(response.Body.Bytes(), &suggestions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 4 {
+		t.Fatalf("got %d elements, want 4: %s", len(suggestions), response.Body)
+	}
+
+	// The query is returned as typed, and the phrases are those of /api/suggeriments.
+	var query string
+	var completions, descriptions, urls []string
+	for i, target := range []any{&query, &completions, &descriptions, &urls} {
+		err := json.Unmarshal(suggestions[i], target)
+		if err != nil {
+			t.Fatalf("element %d: %v", i, err)
+		}
+	}
+	if query != "Fer el M" {
+		t.Errorf("query = %q, want %q", query, "Fer el M")
+	}
+	wantCompletions := getSuggestions(normalizeForSearch(query))
+	if len(wantCompletions) == 0 || !slices.Equal(completions, wantCompletions) {
+		t.Errorf("completions = %q, want %q", completions, wantCompletions)
+	}
+	if len(descriptions) != len(completions) || len(urls) != len(completions) {
+		t.Fatalf("got %d descriptions and %d URLs, want %d", len(descriptions), len(urls), len(completions))
+	}
+	for i, completion := range completions {
+		if wantURL := BaseCanonicalURL + getPhraseSearchPath(completion); urls[i] != wantURL {
+			t.Errorf("URL of %q = %q, want %q", completion, urls[i], wantURL)
+		}
+	}
+
+	// Without suggestions, the lists are empty rather than null.
+	response = serveTestRequest(mux.ServeHTTP, "/api/suggeriments/opensearch?q=inexistent")
+	if got := strings.TrimSpace(response.Body.String()); got != `["inexistent",[],[],[]]` {
+		t.Errorf("response without suggestions = %s, want empty lists", got)
+	}
+}
+
+func TestSearchHandlerSearchField(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	response := serveTestRequest(searchHandler, "/?mode=Coincident&frase=a+les+tres")
+	if hasEntry(response.Body.String(), "a les dues, a les tres") {
+		t.Errorf("search without camp matched a synonym")
+	}
+
+	response = serveTestRequest(searchHandler, "/?mode=Coincident&frase=a+les+tres&camp=sinonims")
+	body := response.Body.String()
+	if !hasEntry(body, "a les dues, a les tres") {
+		t.Errorf("search with camp=sinonims did not return the concept of the synonym")
+	}
+	if !strings.Contains(body, `value="sinonims" selected`) {
+		t.Errorf("search with camp=sinonims does not keep the field selected")
+	}
+}
+
+func TestSynonymsHandler(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{target: "/api/sinonims?frase=fer+el+mort", want: []string{"no dir ni piu", "tancar la boca", "fer el mort"}},
+		{target: "/api/sinonims?frase=no+fer+el+mort", want: []string{}},
+		{target: "/api/sinonims", want: []string{}},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(synonymsHandler, test.target)
+		if response.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("GET %s has Content-Type %q", test.target, response.Header().Get("Content-Type"))
+		}
+
+		var synonyms []Synonym
+		err := json.Unmarshal(response.Body.Bytes(), &synonyms)
+		if err != nil || synonyms == nil {
+			t.Errorf("GET %s is not a JSON array: %v\n%s", test.target, err, response.Body)
+			continue
+		}
+		got := make([]string, 0, len(synonyms))
+		for _, synonym := range synonyms {
+			got = append(got, synonym.Phrase)
+			if synonym.URL == "" {
+				t.Errorf("GET %s: synonym %q has no URL", test.target, synonym.Phrase)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("GET %s = %q, want %q", test.target, got, test.want)
+		}
+	}
+}
+
+func TestHealthHandlerEmptyData(t *testing.T) {
+	loadEmptyTestData(t)
+
+	response := serveTestRequest(healthHandler, "/salut")
+	if response.Code != http.StatusServiceUnavailable || !strings.HasPrefix(response.Body.String(), "degraded") {
+		t.Errorf("/salut = %d %q, want %d and degraded", response.Code, response.Body.String(), http.StatusServiceUnavailable)
+	}
+
+	loadTestData(t)
+	response = serveTestRequest(healthHandler, "/salut")
+	if response.Code != http.StatusOK || response.Body.String() != "ok\n" {
+		t.Errorf("/salut = %d %q with data loaded, want %d and ok", response.Code, response.Body.String(), http.StatusOK)
+	}
+}
+
+func TestSearchHandlerResultCount(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/?mode=Coincident&frase=vendre+fum", want: "1 resultat trobat"},
+		{target: "/?mode=Coincident&frase=fer+el+mort", want: "4 resultats trobats"},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(searchHandler, test.target)
+		if !strings.Contains(response.Body.String(), test.want) {
+			t.Errorf("GET %s does not show %q", test.target, test.want)
+		}
+	}
+}
+
+var (
+	canonicalURLRegexp = regexp.MustCompile(`<link rel="canonical" href="([^"]+)">`)
+	conceptLinkRegexp  = regexp.MustCompile(`href="(/concepte/[^"?]*)[?"]`)
+)
+
+func TestConceptURLTrailingSlash(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousTrailingSlash := ConceptURLTrailingSlash
+	t.Cleanup(func() {
+		ConceptURLTrailingSlash = previousTrailingSlash
+	})
+	mux := newServeMux()
+
+	for _, trailingSlash := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trailing slash %t", trailingSlash), func(t *testing.T) {
+			ConceptURLTrailingSlash = trailingSlash
+
+			// Collect the internal links to concept pages, from the letter pages and search results.
+			var conceptPaths []string
+			for _, target := range []string{"/lletra/A", "/lletra/C", "/lletra/D", "/lletra/E", "/lletra/M", "/?frase=mort"} {
+				response := serveTestRequest(mux.ServeHTTP, target)
+				for _, match := range conceptLinkRegexp.FindAllStringSubmatch(response.Body.String(), -1) {
+					conceptPaths = append(conceptPaths, match[1])
+				}
+			}
+			if len(conceptPaths) == 0 {
+				t.Fatal("found no links to concept pages")
+			}
+
+			for _, conceptPath := range conceptPaths {
+				if strings.HasSuffix(conceptPath, "/") != trailingSlash {
+					t.Errorf("link to %s does not follow the trailing slash policy", conceptPath)
+				}
+
+				// Links are served without a redirect, and are their own canonical URL.
+				response := serveTestRequest(mux.ServeHTTP, conceptPath)
+				if response.Code != http.StatusOK {
+					t.Errorf("GET %s = %d, want %d", conceptPath, response.Code, http.StatusOK)
+					continue
+				}
+				match := canonicalURLRegexp.FindStringSubmatch(response.Body.String())
+				if match == nil || match[1] != BaseCanonicalURL+conceptPath {
+					t.Errorf("canonical URL of %s = %q, want %q", conceptPath, match, BaseCanonicalURL+conceptPath)
+				}
+
+				// The other form is redirected to the link, keeping the query.
+				otherPath := conceptPath + "/"
+				if trailingSlash {
+					otherPath = strings.TrimSuffix(conceptPath, "/")
+				}
+				response = serveTestRequest(mux.ServeHTTP, otherPath+"?destaca=x")
+				location := response.Header().Get("Location")
+				if response.Code != http.StatusMovedPermanently || location != conceptPath+"?destaca=x" {
+					t.Errorf("GET %s = %d to %q, want %d to %q", otherPath, response.Code, location,
+						http.StatusMovedPermanently, conceptPath+"?destaca=x")
+				}
+			}
+
+			// Paths under a concept page are not concept pages.
+			for _, path := range []string{"/concepte/callar/x", "/concepte/callar/x/", "/concepte/callar/x/y/"} {
+				response := serveTestRequest(mux.ServeHTTP, path)
+				if response.Code != http.StatusNotFound {
+					t.Errorf("GET %s = %d, want %d", path, response.Code, http.StatusNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchHandlerHyphenFallback(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	tests := []struct {
+		target       string
+		wantPhrase   string
+		wantFallback string
+	}{
+		// Only the variant with a hyphen matches.
+		{target: "/?frase=fer+se", wantPhrase: "fer-se el mort", wantFallback: "fer-se"},
+		// Only the variant with a space matches.
+		{target: "/?frase=fer-la+migdiada", wantPhrase: "fer la migdiada", wantFallback: "fer la migdiada"},
+		// The query matches as written, so there is no fallback.
+		{target: "/?frase=fer-se", wantPhrase: "fer-se el mort"},
+		// Neither variant matches.
+		{target: "/?frase=fer-se+la+migdiada"},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(searchHandler, test.target).Body.String()
+		if test.wantPhrase != "" && !hasEntry(body, test.wantPhrase) {
+			t.Errorf("GET %s does not show %q", test.target, test.wantPhrase)
+		}
+		note := "Es mostren els resultats per a «" + test.wantFallback + "»"
+		if test.wantFallback != "" && !strings.Contains(body, note) {
+			t.Errorf("GET %s does not show the note %q", test.target, note)
+		}
+		if test.wantFallback == "" && strings.Contains(body, "Es mostren els resultats per a") {
+			t.Errorf("GET %s shows a fallback note", test.target)
+		}
+	}
+}
+
+// conceptHeadingRegexp matches the concept heading of an entry in search results.
+var conceptHeadingRegexp = regexp.MustCompile(`<h2 class="concepte"><a [^>]*>([^<]*)</a></h2>`)
+
+func TestConceptSpellingVariants(t *testing.T) {
+	parseTemplates()
+	mux := newServeMux()
+
+	// The same concept, spelled with differing casing in each entry. The most common
+	// spelling is shown, whatever the order of the entries.
+	entries := []Entry{
+		newTestEntry("Ànima", "ànima en pena"),
+		newTestEntry("ÀNIMA", "en cos i ànima"),
+		newTestEntry("ànima", "ànima de càntir"),
+		newTestEntry("ÀNIMA", "amb l'ànima als peus"),
+	}
+	for _, reversed := range []bool{false, true} {
+		if reversed {
+			slices.Reverse(entries)
+		}
+		setTestEntries(t, entries)
+
+		if got := getRepresentativeConcept("ànima"); got != "ÀNIMA" {
+			t.Errorf("getRepresentativeConcept(%q) = %q, want %q", "ànima", got, "ÀNIMA")
+		}
+
+		body := serveTestRequest(mux.ServeHTTP, "/concepte/%C3%A0nima").Body.String()
+		if !strings.Contains(body, `<h1 class="concepte">ÀNIMA</h1>`) {
+			t.Errorf("concept page (reversed %t) does not show the most common spelling", reversed)
+		}
+		for _, entry := range entries {
+			if !hasEntry(body, entry.Title) {
+				t.Errorf("concept page (reversed %t) does not show %q", reversed, entry.Title)
+			}
+		}
+
+		// Search results show the same spelling for every entry of the concept.
+		body = serveTestRequest(searchHandler, "/?frase=anima").Body.String()
+		headings := conceptHeadingRegexp.FindAllStringSubmatch(body, -1)
+		if len(headings) != len(entries) {
+			t.Errorf("search (reversed %t) shows %d concept headings, want %d", reversed, len(headings), len(entries))
+		}
+		for _, heading := range headings {
+			if heading[1] != "ÀNIMA" {
+				t.Errorf("search (reversed %t) shows the concept as %q, want %q", reversed, heading[1], "ÀNIMA")
+			}
+		}
+
+		// Grouped search results show the concept once, with all its entries.
+		body = serveTestRequest(searchHandler, "/?frase=anima&agrupa=concepte").Body.String()
+		headings = conceptHeadingRegexp.FindAllStringSubmatch(body, -1)
+		if len(headings) != 1 || headings[0][1] != "ÀNIMA" {
+			t.Errorf("grouped search (reversed %t) shows concept headings %q, want only %q", reversed, headings, "ÀNIMA")
+		}
+		for _, entry := range entries {
+			if !hasEntry(body, entry.Title) {
+				t.Errorf("grouped search (reversed %t) does not show %q", reversed, entry.Title)
+			}
+		}
+	}
+}
+
+func TestSearchPositionHandler(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+		want       SearchPosition
+	}{
+		{
+			name:       "first",
+			query:      url.Values{"frase": {"fer el mort"}, "entrada": {"fer el mort"}},
+			wantStatus: http.StatusOK,
+			want:       SearchPosition{Phrase: "fer el mort", Concept: "CALLAR", Position: 1, Total: 5},
+		},
+		{
+			name:       "parentheses content",
+			query:      url.Values{"frase": {"fer el mort"}, "entrada": {"fer el mort (davant d'algú)"}},
+			wantStatus: http.StatusOK,
+			want:       SearchPosition{Phrase: "fer el mort (davant d'algú)", Concept: "CALLAR", Position: 4, Total: 5},
+		},
+		{
+			name:       "last",
+			query:      url.Values{"frase": {"fer el mort"}, "entrada": {"No fer el mort"}},
+			wantStatus: http.StatusOK,
+			want:       SearchPosition{Phrase: "no fer el mort", Concept: "CALLAR", Position: 5, Total: 5},
+		},
+		{
+			name:       "with a mode",
+			query:      url.Values{"frase": {"fer"}, "mode": {SearchModeComencaPer}, "entrada": {"fer-se el mort"}},
+			wantStatus: http.StatusOK,
+			want:       SearchPosition{Phrase: "fer-se el mort", Concept: "MORIR", Position: 9, Total: 9},
+		},
+		{
+			name:       "not among the results",
+			query:      url.Values{"frase": {"fer el mort"}, "entrada": {"estirar la pota"}},
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "missing phrase", query: url.Values{"frase": {"fer el mort"}}, wantStatus: http.StatusBadRequest},
+		{name: "missing query", query: url.Values{"entrada": {"fer el mort"}}, wantStatus: http.StatusBadRequest},
+		{name: "query too short", query: url.Values{"frase": {"f"}, "entrada": {"fer el mort"}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Positions must not change between requests.
+			for range 2 {
+				response := serveTestRequest(searchPositionHandler, "/api/posicio?"+test.query.Encode())
+				if response.Code != test.wantStatus {
+					t.Fatalf("status = %d, want %d", response.Code, test.wantStatus)
+				}
+				if test.wantStatus != http.StatusOK {
+					return
+				}
+
+				var got SearchPosition
+				err := json.Unmarshal(response.Body.Bytes(), &got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != test.want {
+					t.Errorf("got %+v, want %+v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTMLContentType(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{target: "/", wantStatus: http.StatusOK},
+		{target: "/?frase=fer+el+mort", wantStatus: http.StatusOK},
+		{target: "/lletra/C", wantStatus: http.StatusOK},
+		{target: "/concepte/callar", wantStatus: http.StatusOK},
+		{target: "/abreviatures", wantStatus: http.StatusOK},
+		{target: "/coneix", wantStatus: http.StatusOK},
+		{target: "/credits", wantStatus: http.StatusOK},
+		{target: "/presentacio", wantStatus: http.StatusOK},
+		{target: "/lletra/9", wantStatus: http.StatusNotFound},
+		{target: "/concepte/no_existeix", wantStatus: http.StatusNotFound},
+		{target: "/no-existeix", wantStatus: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(mux.ServeHTTP, test.target)
+		if response.Code != test.wantStatus {
+			t.Errorf("GET %s = %d, want %d", test.target, response.Code, test.wantStatus)
+		}
+		contentType := response.Header().Get("Content-Type")
+		if contentType != "text/html; charset=utf-8" {
+			t.Errorf("GET %s has Content-Type %q, want an explicit HTML charset", test.target, contentType)
+		}
+	}
+}
+
+func TestConceptHandlerHomographs(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	body := serveTestRequest(mux.ServeHTTP, "/concepte/cap1").Body.String()
+	want := `<p class="homografs">Altres accepcions: ` +
+		`<a class="concepte" href="/concepte/cap2">CAP<sup>2</sup></a> · ` +
+		`<a class="concepte" href="/concepte/cap12">CAP<sup>12</sup></a></p>`
+	if !strings.Contains(body, want) {
+		t.Errorf("concept page of CAP1 does not contain %q", want)
+	}
+
+	body = serveTestRequest(mux.ServeHTTP, "/concepte/callar").Body.String()
+	if strings.Contains(body, `class="homografs"`) {
+		t.Errorf("concept page of CALLAR links to homographs")
+	}
+}
+
+func TestSearchHandlerPageSize(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousPageSizes := PageSizesByMode
+	t.Cleanup(func() {
+		PageSizesByMode = previousPageSizes
+	})
+	PageSizesByMode = map[string]int{SearchModeCoincident: 2}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		// "fer el mort" has 4 exact matches.
+		{target: "/?mode=Coincident&frase=fer+el+mort", want: "Pàgina 1 de 2"},
+		{target: "/?mode=Coincident&frase=fer+el+mort&mida=1", want: "Pàgina 1 de 4"},
+		{target: "/?mode=Coincident&frase=fer+el+mort&mida=1", want: "&amp;mida=1&amp;pagina=2"},
+		{target: "/?mode=Coincident&frase=fer+el+mort&mida=0", want: "Pàgina 1 de 2"},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(searchHandler, test.target)
+		if !strings.Contains(response.Body.String(), test.want) {
+			t.Errorf("GET %s does not show %q", test.target, test.want)
+		}
+	}
+
+	// Modes without a setting use DefaultPageSize, so 4 results fit in one page.
+	PageSizesByMode = map[string]int{}
+	response := serveTestRequest(searchHandler, "/?mode=Coincident&frase=fer+el+mort")
+	if strings.Contains(response.Body.String(), `class="pagination"`) {
+		t.Errorf("results are paginated with the default page size")
+	}
+}
+
+func TestConceptHandlerRetired(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousRetiredSlugs := RetiredConceptSlugs
+	t.Cleanup(func() {
+		RetiredConceptSlugs = previousRetiredSlugs
+	})
+	RetiredConceptSlugs = map[string]bool{getConceptSlug("CAP3"): true, getConceptSlug("CALLAR"): true}
+	mux := newServeMux()
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{target: "/concepte/cap3", want: http.StatusGone},
+		{target: "/concepte/cap4", want: http.StatusNotFound},
+		// Retired concepts that are still in the data are served as usual.
+		{target: "/concepte/callar", want: http.StatusOK},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(mux.ServeHTTP, test.target)
+		if response.Code != test.want {
+			t.Errorf("GET %s status = %d, want %d", test.target, response.Code, test.want)
+		}
+	}
+}
+
+func TestSearchHandlerGroupByConcept(t *testing.T) {
+	parseTemplates()
+	setTestEntries(t, []Entry{
+		newTestEntry("CALLAR", "fer el mort"),
+		newTestEntry("MORIR", "fer el darrer badall"),
+		newTestEntry("CALLAR", "fer el sord"),
+	})
+
+	body := removeHighlights(serveTestRequest(searchHandler, "/?mode=Comença+per&frase=fer+el&agrupa=concepte").Body.String())
+	var concepts []string
+	for _, heading := range conceptHeadingRegexp.FindAllStringSubmatch(body, -1) {
+		concepts = append(concepts, heading[1])
+	}
+	// Results are sorted alphabetically, so "fer el darrer badall" comes first.
+	if want := []string{"MORIR", "CALLAR"}; !slices.Equal(concepts, want) {
+		t.Errorf("grouped search shows concepts %q, want %q", concepts, want)
+	}
+	// The phrases of each concept follow its heading.
+	if strings.Index(body, "fer el sord") < strings.Index(body, ">CALLAR<") {
+		t.Errorf("grouped search does not show %q under CALLAR", "fer el sord")
+	}
+	if !strings.Contains(body, `<article class="entry frase" id="fer_el_sord">`) {
+		t.Errorf("grouped search does not render phrases as on concept pages")
+	}
+
+	body = serveTestRequest(searchHandler, "/?mode=Comença+per&frase=fer+el").Body.String()
+	if got := len(conceptHeadingRegexp.FindAllString(body, -1)); got != 3 {
+		t.Errorf("search without grouping shows %d concept headings, want 3", got)
+	}
+}
+
+func TestLetterIndexHandler(t *testing.T) {
+	loadTestData(t)
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2025-01-01"
+
+	response := serveTestRequest(letterIndexHandler, "/api/index")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /api/index = %d, want %d", response.Code, http.StatusOK)
+	}
+	var letterCounts []LetterCount
+	err := json.Unmarshal(response.Body.Bytes(), &letterCounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letterCounts) != len(ConceptsByFirstLetter) {
+		t.Errorf("got %d letters, want %d", len(letterCounts), len(ConceptsByFirstLetter))
+	}
+	if !slices.IsSortedFunc(letterCounts, func(a, b LetterCount) int { return strings.Compare(a.Letter, b.Letter) }) {
+		t.Errorf("letters are not sorted: %v", letterCounts)
+	}
+	for _, letterCount := range letterCounts {
+		want := len(ConceptsByFirstLetter[letterCount.Letter])
+		if letterCount.ConceptCount != want || want == 0 {
+			t.Errorf("letter %q has %d concepts, want %d", letterCount.Letter, letterCount.ConceptCount, want)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/index", nil)
+	request.Header.Set("If-None-Match", response.Header().Get("ETag"))
+	recorder := httptest.NewRecorder()
+	letterIndexHandler(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("GET /api/index with the current ETag = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+}
+
+func TestUnknownPaths(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	targets := []string{
+		"/no-existeix",
+		"/index.html",
+		"/cerca/",
+		"/concepte/",
+		"/concepte/callar/fer_el_mort",
+		"/api/desconeguda",
+		"/public/css/main.min.css",
+	}
+	for _, target := range targets {
+		response := serveTestRequest(mux.ServeHTTP, target)
+		if response.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", target, response.Code, http.StatusNotFound)
+		}
+	}
+
+	// Unknown paths get a 404 for any method.
+	request := httptest.NewRequest(http.MethodPost, "/no-existeix", nil)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("POST /no-existeix = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearchFragmentHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/resultats?mode=Coincident&frase=fer+el+mort&mida=1")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /resultats = %d, want %d", response.Code, http.StatusOK)
+	}
+	body := response.Body.String()
+	if strings.Contains(body, "<html") {
+		t.Error("fragment contains the whole page")
+	}
+	if !strings.HasPrefix(body, `<div id="resultats">`) || !hasEntry(body, "fer el mort") {
+		t.Error("fragment does not contain the results")
+	}
+	if !strings.Contains(body, `<nav id="paginacio" hx-swap-oob="true">`) || !strings.Contains(body, "Pàgina 1 de 4") {
+		t.Error("fragment does not contain the out-of-band pagination")
+	}
+
+	// The homepage renders the same blocks, but does not swap the pagination out-of-band.
+	body = serveTestRequest(mux.ServeHTTP, "/?mode=Coincident&frase=fer+el+mort&mida=1").Body.String()
+	if !strings.Contains(body, `<nav id="paginacio">`) || !strings.Contains(body, "Pàgina 1 de 4") {
+		t.Error("homepage does not contain the pagination")
+	}
+
+	body = serveTestRequest(mux.ServeHTTP, "/resultats?frase=xyzxyz").Body.String()
+	if !strings.Contains(body, "No s'ha trobat cap resultat.") {
+		t.Errorf("fragment without results = %q, want a message", body)
+	}
+}
+
+func TestLongConceptNames(t *testing.T) {
+	parseTemplates()
+	mux := newServeMux()
+
+	concepts := []string{
+		"QUEDAR-SE AMB UN PAM DE NAS, O AMB ELS ULLS COM LES TAPADORES D'UNA OLLA (I SENSE SABER QUÈ DIR)?",
+		"ÉSSER FORA DE LLOC / ANAR DE BÒLIT #2",
+		"PAGAR_A_TERMINIS",
+		"TENIR  MOLTA   BARRA1",
+	}
+	var entries []Entry
+	for _, concept := range concepts {
+		entries = append(entries, newTestEntry(concept, "frase de "+strings.ToLower(concept)))
+	}
+	setTestEntries(t, entries)
+
+	for _, concept := range concepts {
+		conceptSlug := getConceptSlug(concept)
+		got := getEntriesByConceptSlug(conceptSlug)
+		if len(got) != 1 || got[0].Concepte != concept {
+			t.Errorf("getEntriesByConceptSlug(%q) = %v, want the entry of %q", conceptSlug, got, concept)
+		}
+
+		conceptPath := getConceptPath(concept)
+		response := serveTestRequest(mux.ServeHTTP, conceptPath)
+		if response.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", conceptPath, response.Code, http.StatusOK)
+			continue
+		}
+		want := `<h1 class="concepte">` + getConceptTitleHTML(concept) + `</h1>`
+		if !strings.Contains(response.Body.String(), want) {
+			t.Errorf("concept page of %q does not contain %q", concept, want)
+		}
+	}
+}
+
+func TestConceptsByLetterHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/api/conceptes")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /api/conceptes = %d, want %d", response.Code, http.StatusOK)
+	}
+	var letterConcepts []LetterConcepts
+	err := json.Unmarshal(response.Body.Bytes(), &letterConcepts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every letter is listed, in the order of /api/index.
+	var letters []string
+	for _, letterCount := range getLetterCounts() {
+		letters = append(letters, letterCount.Letter)
+	}
+	var gotLetters []string
+	for _, letter := range letterConcepts {
+		gotLetters = append(gotLetters, letter.Letter)
+	}
+	if !slices.Equal(gotLetters, letters) {
+		t.Errorf("letters = %q, want %q", gotLetters, letters)
+	}
+
+	// Every concept is listed once, whatever its spelling: concepts that differ only
+	// in casing or accents, such as ÀNIMA, ANIMA, and Ànima, are listed together.
+	// The path of each listed concept serves its page.
+	listedSlugs := make(map[string]int)
+	for _, letter := range letterConcepts {
+		for _, conceptLink := range letter.Concepts {
+			listedSlugs[toLowercaseNoAccents(conceptLink.Slug)]++
+			if conceptLink.Slug != getConceptSlug(conceptLink.Concept) {
+				t.Errorf("slug of %q = %q, want %q", conceptLink.Concept, conceptLink.Slug, getConceptSlug(conceptLink.Concept))
+			}
+			if code := serveTestRequest(mux.ServeHTTP, conceptLink.Path).Code; code != http.StatusOK {
+				t.Errorf("GET %s = %d, want %d", conceptLink.Path, code, http.StatusOK)
+			}
+		}
+	}
+	for _, entry := range AllEntries {
+		slug := toLowercaseNoAccents(getConceptSlug(entry.Concepte))
+		if listedSlugs[slug] != 1 {
+			t.Errorf("concept %q is listed %d times, want once", entry.Concepte, listedSlugs[slug])
+		}
+	}
+}
+
+func TestWarmSearchResultsCacheMatchesRequests(t *testing.T) {
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+		SearchResultsCache.Clear()
+	})
+	BuildDate = "2025-01-01"
+	loadTestData(t)
+	parseTemplates()
+
+	warmSearchResultsCache([]string{"mort"})
+	if got := len(SearchResultsCache.items); got != 1 {
+		t.Fatalf("warming cached %d pages, want 1", got)
+	}
+
+	// Both the search form, which sends the mode, and links without it use the warmed page.
+	for _, target := range []string{"/?mode=" + url.QueryEscape(SearchModeConte) + "&frase=mort", "/?frase=mort"} {
+		response := serveTestRequest(searchHandler, target)
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", target, response.Code, http.StatusOK)
+		}
+		if got := len(SearchResultsCache.items); got != 1 {
+			t.Errorf("GET %s cached %d pages, want the warmed page only", target, got)
+		}
+	}
+}
+
+func TestSearchHandlerExamples(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	// "diners" is only in an example of "fer el mort".
+	body := serveTestRequest(searchHandler, "/?frase=diners").Body.String()
+	if hasEntry(body, "fer el mort") {
+		t.Errorf("search without camp=exemples matches an example")
+	}
+
+	body = serveTestRequest(searchHandler, "/?frase=diners&camp=exemples").Body.String()
+	if !hasEntry(body, "fer el mort") {
+		t.Errorf("search with camp=exemples does not find %q", "fer el mort")
+	}
+	if !strings.Contains(body, "<mark>diners</mark>") {
+		t.Errorf("search with camp=exemples does not highlight the match")
+	}
+	if !strings.Contains(body, `value="exemples" selected`) {
+		t.Errorf("search with camp=exemples does not keep the field selected")
+	}
+}
+
+func TestSearchHandlerTitleHighlights(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	body := serveTestRequest(searchHandler, "/?frase=mort").Body.String()
+	if !strings.Contains(body, "<strong>fer el <mark>mort</mark></strong>") {
+		t.Errorf("search does not highlight the query in the phrase")
+	}
+
+	body = serveTestRequest(searchHandler, "/?frase=morir&mode=Arrel").Body.String()
+	if strings.Contains(body, "<strong><mark>") || strings.Contains(body, "</mark></strong>") {
+		t.Errorf("search with mode=Arrel highlights the phrase")
+	}
+}
+
+func TestSearchHandlerDefinitions(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	// "comprometre's" is only in the definition of "fer el mort".
+	body := serveTestRequest(searchHandler, "/?frase=comprometre%27s").Body.String()
+	if hasEntry(body, "fer el mort") {
+		t.Errorf("search without camp=definicio matches a definition")
+	}
+	body = serveTestRequest(searchHandler, "/?frase=comprometre%27s&camp=exemples").Body.String()
+	if hasEntry(body, "fer el mort") {
+		t.Errorf("search with camp=exemples matches a definition")
+	}
+
+	body = serveTestRequest(searchHandler, "/?frase=comprometre%27s&camp=definicio").Body.String()
+	if !hasEntry(body, "fer el mort") {
+		t.Errorf("search with camp=definicio does not find %q", "fer el mort")
+	}
+	if !strings.Contains(body, "<mark>comprometre's</mark>") {
+		t.Errorf("search with camp=definicio does not highlight the match")
+	}
+	if !strings.Contains(body, `value="definicio" selected`) {
+		t.Errorf("search with camp=definicio does not keep the field selected")
+	}
+
+	// The query is matched as whole words.
+	body = serveTestRequest(searchHandler, "/?frase=compromet&camp=definicio").Body.String()
+	if hasEntry(body, "fer el mort") {
+		t.Errorf("search with camp=definicio matches part of a word")
+	}
+}
+
+func TestExcludeNewIncorporations(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	// "vendre fum" is the only new incorporation in the test data.
+	tests := []struct {
+		target      string
+		wantPresent bool
+	}{
+		{target: "/?mode=Coincident&frase=vendre+fum", wantPresent: true},
+		{target: "/?mode=Coincident&frase=vendre+fum&exclou=novetats", wantPresent: false},
+		{target: "/concepte/enganyar", wantPresent: true},
+		{target: "/concepte/enganyar?exclou=novetats", wantPresent: false},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(mux.ServeHTTP, test.target).Body.String()
+		if hasEntry(body, "vendre fum") != test.wantPresent {
+			t.Errorf("GET %s shows %q: %t, want %t", test.target, "vendre fum", !test.wantPresent, test.wantPresent)
+		}
+	}
+
+	// Other entries of the concept are kept, and the checkbox stays checked.
+	body := serveTestRequest(mux.ServeHTTP, "/concepte/enganyar?exclou=novetats").Body.String()
+	if !hasEntry(body, "fer el mort") {
+		t.Errorf("concept page excluding new incorporations does not show %q", "fer el mort")
+	}
+	if !strings.Contains(body, `value="novetats" checked`) {
+		t.Errorf("concept page does not keep the filter checked")
+	}
+
+	// Pagination links keep the filter.
+	body = serveTestRequest(mux.ServeHTTP, "/?mode=Coincident&frase=fer+el+mort&mida=1&exclou=novetats").Body.String()
+	if !strings.Contains(body, "&amp;exclou=novetats&amp;pagina=2") {
+		t.Errorf("pagination links do not keep the filter")
+	}
+
+	response := serveTestRequest(mux.ServeHTTP, "/api/posicio?mode=Coincident&frase=vendre+fum&entrada=vendre+fum&exclou=novetats")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("GET /api/posicio with exclou=novetats = %d, want %d", response.Code, http.StatusNotFound)
+	}
+}
+
+func TestOnlyWithExamples(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	// "vendre fum" has no examples, and "donar gat per llebre" does.
+	tests := []struct {
+		target      string
+		wantPresent bool
+	}{
+		{target: "/?mode=Coincident&frase=vendre+fum", wantPresent: true},
+		{target: "/?mode=Coincident&frase=vendre+fum&amb=exemples", wantPresent: false},
+		{target: "/concepte/enganyar", wantPresent: true},
+		{target: "/concepte/enganyar?amb=exemples", wantPresent: false},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(mux.ServeHTTP, test.target).Body.String()
+		if hasEntry(body, "vendre fum") != test.wantPresent {
+			t.Errorf("GET %s shows %q: %t, want %t", test.target, "vendre fum", !test.wantPresent, test.wantPresent)
+		}
+	}
+
+	// Entries with examples are kept, and the checkbox stays checked.
+	body := serveTestRequest(mux.ServeHTTP, "/concepte/enganyar?amb=exemples").Body.String()
+	if !hasEntry(body, "donar gat per llebre") {
+		t.Errorf("concept page with only phrases with examples does not show %q", "donar gat per llebre")
+	}
+	if !strings.Contains(body, `value="exemples" checked`) {
+		t.Errorf("concept page does not keep the filter checked")
+	}
+	body = serveTestRequest(mux.ServeHTTP, "/?frase=mort&amb=exemples").Body.String()
+	if !hasEntry(body, "fer el mort") || hasEntry(body, "fer-se el mort") || hasEntry(body, "no fer el mort") {
+		t.Errorf("search with only phrases with examples does not show exactly the phrases with examples")
+	}
+
+	// Pagination links keep the filter.
+	body = serveTestRequest(mux.ServeHTTP, "/?mode=Coincident&frase=fer+el+mort&mida=1&amb=exemples").Body.String()
+	if !strings.Contains(body, "&amp;amb=exemples&amp;pagina=2") {
+		t.Errorf("pagination links do not keep the filter")
+	}
+
+	response := serveTestRequest(mux.ServeHTTP, "/api/posicio?mode=Coincident&frase=vendre+fum&entrada=vendre+fum&amb=exemples")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("GET /api/posicio with amb=exemples = %d, want %d", response.Code, http.StatusNotFound)
+	}
+}
+
+func TestExportOrder(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	// In the test data file, "anar a fer la migdiada" comes after "fer la migdiada".
+	tests := []struct {
+		target    string
+		wantFirst string
+		wantLast  string
+	}{
+		{"/concepte/descansar", "anar a fer la migdiada", "fer la migdiada"},
+		{"/concepte/descansar?ordre=export", "fer la migdiada", "anar a fer la migdiada"},
+	}
+	for _, test := range tests {
+		body := removeHighlights(serveTestRequest(mux.ServeHTTP, test.target).Body.String())
+		first := strings.Index(body, "<strong>"+test.wantFirst+"</strong>")
+		last := strings.Index(body, "<strong>"+test.wantLast+"</strong>")
+		if first == -1 || last == -1 || first > last {
+			t.Errorf("GET %s shows %q at %d and %q at %d, want the first before the second", test.target, test.wantFirst, first, test.wantLast, last)
+		}
+	}
+
+	// Pagination links keep the order.
+	body := serveTestRequest(mux.ServeHTTP, "/?mode=Conte&frase=mort&mida=1&ordre=export").Body.String()
+	if !strings.Contains(body, "&amp;ordre=export&amp;pagina=2") {
+		t.Errorf("pagination links do not keep the order")
+	}
+
+	// The position of "no fer el mort" is 3 in export order, and 6 when sorted.
+	for target, want := range map[string]int{
+		"/api/posicio?mode=Conte&frase=mort&entrada=no+fer+el+mort":              6,
+		"/api/posicio?mode=Conte&frase=mort&entrada=no+fer+el+mort&ordre=export": 3,
+	} {
+		var position SearchPosition
+		err := json.Unmarshal(serveTestRequest(mux.ServeHTTP, target).Body.Bytes(), &position)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		if position.Position != want {
+			t.Errorf("GET %s: position = %d, want %d", target, position.Position, want)
+		}
+	}
+}
+
+func TestCompareConceptsHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/api/compara?a=callar&b=enganyar")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /api/compara = %d, want %d", response.Code, http.StatusOK)
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", contentType, "application/json")
+	}
+	var comparison ConceptComparison
+	err := json.Unmarshal(response.Body.Bytes(), &comparison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(comparison.Shared, []string{"fer el mort"}) {
+		t.Errorf("shared = %q, want %q", comparison.Shared, []string{"fer el mort"})
+	}
+	if len(comparison.OnlyA) != 3 || len(comparison.OnlyB) != 3 {
+		t.Errorf("unique phrases = %q, %q, want 3 each", comparison.OnlyA, comparison.OnlyB)
+	}
+
+	// Concepts spelled differently are shown in their most common spelling.
+	response = serveTestRequest(mux.ServeHTTP, "/api/compara?a=%C3%A0nima&b=anima")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /api/compara = %d, want %d", response.Code, http.StatusOK)
+	}
+	err = json.Unmarshal(response.Body.Bytes(), &comparison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comparison.ConceptA != "ÀNIMA" {
+		t.Errorf("concept a = %q, want %q", comparison.ConceptA, "ÀNIMA")
+	}
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/api/compara", http.StatusBadRequest},
+		{"/api/compara?a=callar", http.StatusBadRequest},
+		{"/api/compara?b=callar", http.StatusBadRequest},
+		{"/api/compara?a=callar&b=inexistent", http.StatusNotFound},
+		{"/api/compara?a=inexistent&b=callar", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if code := serveTestRequest(mux.ServeHTTP, test.target).Code; code != test.want {
+			t.Errorf("GET %s = %d, want %d", test.target, code, test.want)
+		}
+	}
+}
+
+func TestAPISearchHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	getResponse := func(target string) SearchAPIResponse {
+		t.Helper()
+		response := serveTestRequest(mux.ServeHTTP, target)
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", target, response.Code, http.StatusOK)
+		}
+		if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("GET %s: Content-Type = %q, want %q", target, contentType, "application/json")
+		}
+		var searchResponse SearchAPIResponse
+		err := json.Unmarshal(response.Body.Bytes(), &searchResponse)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		return searchResponse
+	}
+
+	// Results are sorted as on the search page.
+	searchResponse := getResponse("/api/cerca?frase=mort&mida=2&pagina=2")
+	if searchResponse.Total != 6 || searchResponse.Page != 2 || searchResponse.TotalPages != 3 {
+		t.Errorf("total, page, pages = %d, %d, %d, want 6, 2, 3", searchResponse.Total, searchResponse.Page, searchResponse.TotalPages)
+	}
+	var titles []string
+	for _, result := range getAllSearchResults("mort", SearchOptions{Mode: SearchModeConte})[2:4] {
+		titles = append(titles, result.Entry.Title)
+	}
+	var gotTitles []string
+	for _, entry := range searchResponse.Entries {
+		gotTitles = append(gotTitles, entry.Title)
+	}
+	if !slices.Equal(gotTitles, titles) {
+		t.Errorf("titles = %q, want %q", gotTitles, titles)
+	}
+
+	// Entries have the fields of the export.
+	searchResponse = getResponse("/api/cerca?mode=Coincident&frase=vendre+fum")
+	if len(searchResponse.Entries) != 1 || searchResponse.Entries[0].Concepte != "ENGANYAR" || !searchResponse.Entries[0].NovaIncorporacio {
+		t.Errorf("entries = %+v, want %q of ENGANYAR", searchResponse.Entries, "vendre fum")
+	}
+	searchResponse = getResponse("/api/cerca?mode=Coincident&frase=vendre+fum&exclou=novetats")
+	if searchResponse.Total != 0 {
+		t.Errorf("total excluding new incorporations = %d, want 0", searchResponse.Total)
+	}
+
+	// Nothing found is an empty array, not null.
+	response := serveTestRequest(mux.ServeHTTP, "/api/cerca?frase=inexistent")
+	if !strings.Contains(response.Body.String(), `"entrades":[]`) {
+		t.Errorf("response without results = %s, want an empty array", response.Body.String())
+	}
+
+	for _, target := range []string{"/api/cerca", "/api/cerca?frase=", "/api/cerca?frase=a"} {
+		if code := serveTestRequest(mux.ServeHTTP, target).Code; code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want %d", target, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPISearchHandlerRenderHTML(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	// The html field is only added when requested.
+	for _, test := range []struct {
+		target   string
+		wantHTML bool
+	}{
+		{target: "/api/cerca?frase=mort", wantHTML: false},
+		{target: "/api/cerca?frase=mort&render=html", wantHTML: true},
+		{target: "/api/cerca?frase=mort&render=text", wantHTML: false},
+	} {
+		body := serveTestRequest(mux.ServeHTTP, test.target).Body.String()
+		if hasHTML := strings.Contains(body, `"html":`); hasHTML != test.wantHTML {
+			t.Errorf("GET %s has html fields = %t, want %t", test.target, hasHTML, test.wantHTML)
+		}
+	}
+	if strings.Contains(serveTestRequest(mux.ServeHTTP, "/export.json").Body.String(), `"html":`) {
+		t.Error("GET /export.json has html fields")
+	}
+
+	// Entries are rendered as on the HTML pages.
+	var conceptResponse ConceptAPIResponse
+	err := json.Unmarshal(serveTestRequest(mux.ServeHTTP, "/api/concepte/callar?render=html").Body.Bytes(), &conceptResponse)
+	if err != nil || len(conceptResponse.Entries) == 0 {
+		t.Fatalf("decoding /api/concepte/callar?render=html: %v", err)
+	}
+	conceptPage := serveTestRequest(mux.ServeHTTP, "/concepte/callar").Body.String()
+	for _, entry := range conceptResponse.Entries {
+		if entry.HTML == "" || !strings.Contains(conceptPage, entry.HTML) {
+			t.Errorf("the html field of %q is not rendered as on the concept page: %s", entry.Title, entry.HTML)
+		}
+	}
+}
+
+func TestSuggestionsHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/api/suggeriments?q=fer+el", []string{"fer el darrer badall", "fer el mort", "fer el mort (a l'aigua)", "fer el mort (davant d'algú)"}},
+		{"/api/suggeriments?q=FER+EL+M", []string{"fer el mort", "fer el mort (a l'aigua)", "fer el mort (davant d'algú)"}},
+		{"/api/suggeriments?q=anima", []string{"ànima de càntir", "ànima en pena"}},
+		{"/api/suggeriments?q=inexistent", []string{}},
+		{"/api/suggeriments?q=f", []string{}},
+		{"/api/suggeriments", []string{}},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(mux.ServeHTTP, test.target)
+		if response.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", test.target, response.Code, http.StatusOK)
+			continue
+		}
+		var got []string
+		err := json.Unmarshal(response.Body.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("GET %s: %v", test.target, err)
+		}
+		if got == nil || !slices.Equal(got, test.want) {
+			t.Errorf("GET %s = %q, want %q", test.target, got, test.want)
+		}
+	}
+}
+
+func TestAPIConceptHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2025-01-01"
+	mux := newServeMux()
+
+	getResponse := func(target string) ConceptAPIResponse {
+		t.Helper()
+		response := serveTestRequest(mux.ServeHTTP, target)
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want %d", target, response.Code, http.StatusOK)
+		}
+		var conceptResponse ConceptAPIResponse
+		err := json.Unmarshal(response.Body.Bytes(), &conceptResponse)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		return conceptResponse
+	}
+
+	// Entries are in the order of the concept page, with the antonym at the end, and
+	// their fields are unmodified.
+	raw := getResponse("/api/concepte/callar")
+	if raw.Concept != "CALLAR" || len(raw.Entries) != 5 {
+		t.Fatalf("concept = %q with %d entries, want %q with 5", raw.Concept, len(raw.Entries), "CALLAR")
+	}
+	if raw.Entries[0].Title != "fer el mort" || raw.Entries[4].Title != "no fer el mort" {
+		t.Errorf("first and last entries = %q, %q, want %q, %q", raw.Entries[0].Title, raw.Entries[4].Title, "fer el mort", "no fer el mort")
+	}
+	if raw.Entries[0].Sinonims != "no dir ni piu, tancar la boca" || raw.Entries[3].MarcatgeDialectal != "fam." {
+		t.Errorf("raw fields = %q, %q, want them as in the data", raw.Entries[0].Sinonims, raw.Entries[3].MarcatgeDialectal)
+	}
+	if !slices.ContainsFunc(raw.Related, func(concept RelatedConcept) bool { return concept.Slug == "descansar" }) {
+		t.Errorf("related concepts = %+v, want descansar", raw.Related)
+	}
+
+	// With render=html, the fields are rendered as on the concept page.
+	rendered := getResponse("/api/concepte/callar?render=html")
+	conceptPage := serveTestRequest(mux.ServeHTTP, "/concepte/callar").Body.String()
+	for i, entry := range rendered.Entries {
+		if entry.Title == raw.Entries[i].Title || !strings.Contains(entry.Title, "<strong>") {
+			t.Errorf("rendered title = %q, want %q in bold", entry.Title, raw.Entries[i].Title)
+		}
+		if entry.Sinonims != "" && !strings.Contains(conceptPage, entry.Sinonims) {
+			t.Errorf("rendered synonyms %q are not as on the concept page", entry.Sinonims)
+		}
+		if entry.Definicio != raw.Entries[i].Definicio || entry.TitleNormalizedWpc != raw.Entries[i].TitleNormalizedWpc {
+			t.Errorf("definition and normalized title of %q are changed", raw.Entries[i].Title)
+		}
+	}
+	if rendered.Entries[0].Sinonims == raw.Entries[0].Sinonims {
+		t.Errorf("rendered synonyms = %q, want them linked", rendered.Entries[0].Sinonims)
+	}
+	if !strings.Contains(rendered.Entries[3].MarcatgeDialectal, "<abbr") {
+		t.Errorf("rendered dialectal marking = %q, want the abbreviation expanded", rendered.Entries[3].MarcatgeDialectal)
+	}
+
+	// The same applies to the search API.
+	response := serveTestRequest(mux.ServeHTTP, "/api/cerca?mode=Coincident&frase=no+dir+ni+piu&render=html")
+	var searchResponse SearchAPIResponse
+	err := json.Unmarshal(response.Body.Bytes(), &searchResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searchResponse.Entries) != 1 || searchResponse.Entries[0].Sinonims != rendered.Entries[2].Sinonims {
+		t.Errorf("entries of the search API = %+v, want the synonyms rendered", searchResponse.Entries)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/concepte/callar", nil)
+	request.Header.Set("If-None-Match", serveTestRequest(mux.ServeHTTP, "/api/concepte/callar").Header().Get("ETag"))
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("GET /api/concepte/callar with the current ETag = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+	if code := serveTestRequest(mux.ServeHTTP, "/api/concepte/inexistent").Code; code != http.StatusNotFound {
+		t.Errorf("GET /api/concepte/inexistent = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAPIConceptHandlerPagination(t *testing.T) {
+	loadTestData(t)
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2025-01-01"
+	mux := newServeMux()
+
+	getResponse := func(target string) ConceptAPIResponse {
+		t.Helper()
+		response := serveTestRequest(mux.ServeHTTP, target)
+		var conceptResponse ConceptAPIResponse
+		err := json.Unmarshal(response.Body.Bytes(), &conceptResponse)
+		if response.Code != http.StatusOK || err != nil {
+			t.Fatalf("GET %s = %d: %v", target, response.Code, err)
+		}
+		return conceptResponse
+	}
+
+	// CALLAR has 5 entries.
+	all := getResponse("/api/concepte/callar")
+	tests := []struct {
+		query          string
+		wantPage       int
+		wantTotalPages int
+		wantEntries    []Entry
+	}{
+		{query: "", wantPage: 1, wantTotalPages: 1, wantEntries: all.Entries},
+		{query: "?mida=2", wantPage: 1, wantTotalPages: 3, wantEntries: all.Entries[:2]},
+		{query: "?mida=2&pagina=2", wantPage: 2, wantTotalPages: 3, wantEntries: all.Entries[2:4]},
+		{query: "?mida=2&pagina=3", wantPage: 3, wantTotalPages: 3, wantEntries: all.Entries[4:]},
+		{query: "?mida=2&pagina=4", wantPage: 4, wantTotalPages: 3, wantEntries: []Entry{}},
+		{query: "?mida=1000", wantPage: 1, wantTotalPages: 1, wantEntries: all.Entries},
+		// Invalid values are ignored.
+		{query: "?mida=x&pagina=2", wantPage: 1, wantTotalPages: 1, wantEntries: all.Entries},
+		{query: "?mida=2&pagina=-1", wantPage: 1, wantTotalPages: 3, wantEntries: all.Entries[:2]},
+	}
+	for _, test := range tests {
+		got := getResponse("/api/concepte/callar" + test.query)
+		if got.Total != 5 || got.Page != test.wantPage || got.TotalPages != test.wantTotalPages {
+			t.Errorf("GET /api/concepte/callar%s: total %d, page %d of %d, want 5, %d of %d",
+				test.query, got.Total, got.Page, got.TotalPages, test.wantPage, test.wantTotalPages)
+		}
+		if !slices.EqualFunc(got.Entries, test.wantEntries, func(a, b Entry) bool { return a.Title == b.Title }) {
+			t.Errorf("GET /api/concepte/callar%s: %d entries, want %d", test.query, len(got.Entries), len(test.wantEntries))
+		}
+		if len(got.Related) != len(all.Related) {
+			t.Errorf("GET /api/concepte/callar%s: %d related concepts, want %d from all the entries", test.query, len(got.Related), len(all.Related))
+		}
+	}
+
+	// Each page has its own ETag.
+	firstETag := serveTestRequest(mux.ServeHTTP, "/api/concepte/callar?mida=2").Header().Get("ETag")
+	secondETag := serveTestRequest(mux.ServeHTTP, "/api/concepte/callar?mida=2&pagina=2").Header().Get("ETag")
+	if firstETag == "" || firstETag == secondETag {
+		t.Errorf("ETags of the first and second pages = %q and %q, want them different", firstETag, secondETag)
+	}
+}
+
+func TestSearchHandlerAccentsExact(t *testing.T) {
+	setTestEntries(t, []Entry{
+		newTestEntry("DESORDRE", "a la babalà"),
+		newTestEntry("DESORDRE", "a la bàbala"),
+	})
+	parseTemplates()
+	mux := newServeMux()
+
+	tests := []struct {
+		target    string
+		wantFirst string
+		wantLast  string
+	}{
+		{"/?mode=Conte&frase=b%C3%A0bala", "a la babalà", "a la bàbala"},
+		{"/?mode=Conte&frase=b%C3%A0bala&accents=exacte", "a la bàbala", "a la babalà"},
+	}
+	for _, test := range tests {
+		body := removeHighlights(serveTestRequest(mux.ServeHTTP, test.target).Body.String())
+		first := strings.Index(body, "<strong>"+test.wantFirst+"</strong>")
+		last := strings.Index(body, "<strong>"+test.wantLast+"</strong>")
+		if first == -1 || last == -1 || first > last {
+			t.Errorf("GET %s shows %q at %d and %q at %d, want the first before the second", test.target, test.wantFirst, first, test.wantLast, last)
+		}
+	}
+
+	body := serveTestRequest(mux.ServeHTTP, "/?mode=Conte&frase=b%C3%A0bala&mida=1&accents=exacte").Body.String()
+	if !strings.Contains(body, `value="exacte" checked`) {
+		t.Errorf("search page does not keep the option checked")
+	}
+	if !strings.Contains(body, "&amp;accents=exacte&amp;pagina=2") {
+		t.Errorf("pagination links do not keep the option")
+	}
+}
+
+func TestNoIndex(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	tests := []struct {
+		target      string
+		wantNoIndex bool
+	}{
+		{"/", false},
+		{"/?mode=Conte", false},
+		{"/?frase=", false},
+		{"/?frase=mort", true},
+		{"/?mode=Coincident&frase=fer+el+mort&pagina=2", true},
+		{"/?frase=inexistent", true},
+		{"/?frase=a", true},
+		{"/concepte/callar", false},
+		{"/concepte/callar?frase=mort", false},
+		{"/lletra/C", false},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(mux.ServeHTTP, test.target).Body.String()
+		if got := strings.Contains(body, `<meta name="robots" content="noindex, follow">`); got != test.wantNoIndex {
+			t.Errorf("GET %s has noindex: %t, want %t", test.target, got, test.wantNoIndex)
+		}
+	}
+}
+
+func TestSearchHandlerDidYouMean(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/?frase=fer+el+mprt", want: `<a href="/?mode=Cont%C3%A9&amp;frase=fer&#43;el&#43;mort">«fer el mort»</a>`},
+		{target: "/?frase=descansr", want: `<a href="` + getConceptPath("DESCANSAR") + `">«descansar»</a>`},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(searchHandler, test.target)
+		if !strings.Contains(response.Body.String(), test.want) {
+			t.Errorf("GET %s does not suggest %s", test.target, test.want)
+		}
+	}
+}
+
+func TestSitemapHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2026-01-01"
+
+	response := serveTestRequest(sitemapHandler, "/sitemap.xml")
+	if response.Header().Get("Content-Type") != "application/xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", response.Header().Get("Content-Type"))
+	}
+	var sitemap struct {
+		URLs []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	err := xml.Unmarshal(response.Body.Bytes(), &sitemap)
+	if err != nil {
+		t.Fatalf("sitemap.xml is not valid XML: %v\n%s", err, response.Body)
+	}
+
+	locations := make([]string, 0, len(sitemap.URLs))
+	for _, sitemapURL := range sitemap.URLs {
+		locations = append(locations, sitemapURL.Loc)
+		if sitemapURL.LastMod != BuildDate {
+			t.Errorf("lastmod of %s = %q, want %q", sitemapURL.Loc, sitemapURL.LastMod, BuildDate)
+		}
+	}
+	// The test data has phrases of 6 categories.
+	if want := 1 + len(ConceptsByFirstLetter) + 6 + len(ConceptsBySlug); len(locations) != want {
+		t.Errorf("got %d URLs, want %d", len(locations), want)
+	}
+	for _, want := range []string{BaseCanonicalURL + "/", BaseCanonicalURL + "/lletra/D", BaseCanonicalURL + "/categoria/sv",
+		BaseCanonicalURL + getConceptPath("DESCANSAR")} {
+		if !slices.Contains(locations, want) {
+			t.Errorf("sitemap does not list %s", want)
+		}
+	}
+
+	// The first page is the whole sitemap, and there are no more.
+	response = serveTestRequest(sitemapHandler, "/sitemap.xml?pagina=1")
+	if response.Code != http.StatusOK || strings.Count(response.Body.String(), "<url>") != len(locations) {
+		t.Errorf("GET /sitemap.xml?pagina=1 = %d, with %d URLs", response.Code, strings.Count(response.Body.String(), "<url>"))
+	}
+	for _, target := range []string{"/sitemap.xml?pagina=2", "/sitemap.xml?pagina=0", "/sitemap.xml?pagina=a"} {
+		if response := serveTestRequest(sitemapHandler, target); response.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", target, response.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestDataVersionHandler(t *testing.T) {
+	loadTestData(t)
+
+	response := serveTestRequest(dataVersionHandler, "/api/versio")
+	var versionResponse DataVersionResponse
+	err := json.Unmarshal(response.Body.Bytes(), &versionResponse)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, response.Body)
+	}
+	if versionResponse.Version != DataVersion || versionResponse.EntryCount != len(AllEntries) {
+		t.Errorf("GET /api/versio = %+v, want version %q and %d entries", versionResponse, DataVersion, len(AllEntries))
+	}
+}
+
+func TestExportJSONHandlerETag(t *testing.T) {
+	loadTestData(t)
+
+	etag := serveTestRequest(exportJSONHandler, "/export.json").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("the export has no ETag")
+	}
+	if otherETag := serveTestRequest(exportJSONHandler, "/export.json?mida=7").Header().Get("ETag"); otherETag == etag {
+		t.Errorf("ETag of a page of the export = %s, want it different from the whole export", otherETag)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/export.json", nil)
+	request.Header.Set("If-None-Match", etag)
+	recorder := httptest.NewRecorder()
+	exportJSONHandler(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("GET /export.json with the current ETag = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+
+	// The ETag changes with the data.
+	setTestEntries(t, []Entry{newTestEntry("DESCANSAR", "fer el mort")})
+	if newETag := serveTestRequest(exportJSONHandler, "/export.json").Header().Get("ETag"); newETag == etag {
+		t.Errorf("ETag = %s after changing the data, want a different ETag", newETag)
+	}
+}
+
+func TestRobotsHandler(t *testing.T) {
+	previousEnvironment := Environment
+	t.Cleanup(func() {
+		Environment = previousEnvironment
+	})
+
+	tests := []struct {
+		environment string
+		host        string
+		wantBlocked bool
+	}{
+		{environment: EnvironmentProduction, host: "dsff.uab.cat", wantBlocked: false},
+		{environment: EnvironmentProduction, host: "testimonis.example.com", wantBlocked: false},
+		{environment: EnvironmentProduction, host: "localhost:8080", wantBlocked: true},
+		{environment: EnvironmentProduction, host: "Staging.dsff.uab.cat", wantBlocked: true},
+		{environment: EnvironmentProduction, host: "dev.dsff.uab.cat:443", wantBlocked: true},
+		{environment: "staging", host: "dsff.uab.cat", wantBlocked: true},
+	}
+	for _, test := range tests {
+		Environment = test.environment
+		request := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+		request.Host = test.host
+		recorder := httptest.NewRecorder()
+		robotsHandler(recorder, request)
+
+		body := recorder.Body.String()
+		if isBlocked := strings.Contains(body, "Disallow: /\n"); isBlocked != test.wantBlocked {
+			t.Errorf("robots.txt on %s in %s blocks crawling = %t, want %t:\n%s", test.host, test.environment, isBlocked, test.wantBlocked, body)
+		}
+		if hasSitemap := strings.Contains(body, "Sitemap: "+BaseCanonicalURL+"/sitemap.xml\n"); hasSitemap == test.wantBlocked {
+			t.Errorf("robots.txt on %s in %s points to the sitemap = %t, want %t", test.host, test.environment, hasSitemap, !test.wantBlocked)
+		}
+		if !test.wantBlocked {
+			for _, path := range HealthCheckPaths {
+				if !strings.Contains(body, "Disallow: "+path+"\n") {
+					t.Errorf("robots.txt on %s does not disallow %s", test.host, path)
+				}
+			}
+		}
+	}
+}
+
+func TestProbeHandlers(t *testing.T) {
+	mux := newServeMux()
+	getProbe := func(target string) (int, ProbeResponse) {
+		t.Helper()
+		response := serveTestRequest(mux.ServeHTTP, target)
+		var probeResponse ProbeResponse
+		err := json.Unmarshal(response.Body.Bytes(), &probeResponse)
+		if err != nil {
+			t.Fatalf("GET %s: invalid JSON: %v\n%s", target, err, response.Body)
+		}
+		return response.Code, probeResponse
+	}
+
+	loadEmptyTestData(t)
+	if code, probe := getProbe("/healthz"); code != http.StatusOK || probe.Status != ProbeStatusOK {
+		t.Errorf("GET /healthz without data = %d %+v, want %d and %q", code, probe, http.StatusOK, ProbeStatusOK)
+	}
+	if code, probe := getProbe("/readyz"); code != http.StatusServiceUnavailable || probe.Status != ProbeStatusNotReady {
+		t.Errorf("GET /readyz without data = %d %+v, want %d and %q", code, probe, http.StatusServiceUnavailable, ProbeStatusNotReady)
+	}
+
+	loadTestData(t)
+	for _, target := range []string{"/healthz", "/readyz"} {
+		if code, probe := getProbe(target); code != http.StatusOK || probe.Status != ProbeStatusOK {
+			t.Errorf("GET %s with data = %d %+v, want %d and %q", target, code, probe, http.StatusOK, ProbeStatusOK)
+		}
+	}
+}
+
+func TestConceptHandlerNormalizesSlug(t *testing.T) {
+	setTestEntries(t, []Entry{newTestEntry("CAP GROS", "tenir el cap gros")})
+	parseTemplates()
+	previousTrailingSlash := ConceptURLTrailingSlash
+	t.Cleanup(func() {
+		ConceptURLTrailingSlash = previousTrailingSlash
+	})
+	ConceptURLTrailingSlash = false
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/concepte/cap_gros")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /concepte/cap_gros = %d, want %d", response.Code, http.StatusOK)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "trailing spaces", target: "/concepte/cap%20gros%20%20", want: "/concepte/cap_gros"},
+		{name: "plus encoding", target: "/concepte/cap+gros", want: "/concepte/cap_gros"},
+		{name: "uppercase", target: "/concepte/Cap_Gros", want: "/concepte/cap_gros"},
+		{name: "stray underscores", target: "/concepte/_cap__gros", want: "/concepte/cap_gros"},
+		{name: "query string", target: "/concepte/CAP+GROS?destaca=cap", want: "/concepte/cap_gros?destaca=cap"},
+		{name: "trailing slash", target: "/concepte/cap+gros/", want: "/concepte/cap_gros"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := serveTestRequest(mux.ServeHTTP, test.target)
+			location := response.Header().Get("Location")
+			if response.Code != http.StatusMovedPermanently || location != test.want {
+				t.Errorf("GET %s = %d to %q, want %d to %q", test.target, response.Code, location,
+					http.StatusMovedPermanently, test.want)
+			}
+		})
+	}
+}
+
+func TestCategoryHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/categoria/sv")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /categoria/sv = %d, want %d", response.Code, http.StatusOK)
+	}
+	body := response.Body.String()
+	for _, want := range []string{"<h1>Categoria: sintagma verbal</h1>", "20 frases", `<link rel="canonical" href="` + BaseCanonicalURL + `/categoria/sv">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /categoria/sv does not contain %q", want)
+		}
+	}
+	if !hasEntry(body, "fer el mort") || hasEntry(body, "cap per avall") {
+		t.Error("GET /categoria/sv does not list only the verbal phrases")
+	}
+	if strings.Contains(body, `id="paginacio"`) {
+		t.Error("GET /categoria/sv is paginated, with fewer phrases than a page")
+	}
+
+	// Unknown categories, and categories without phrases, are not found.
+	for _, target := range []string{"/categoria/xx", "/categoria/SV", "/categoria/sa", "/categoria/sv?pagina=2", "/categoria/sv?pagina=0"} {
+		if response := serveTestRequest(mux.ServeHTTP, target); response.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", target, response.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestCategoryHandlerPagination(t *testing.T) {
+	var entries []Entry
+	for i := range CategoryPageSize + 1 {
+		entries = append(entries, newTestEntry(fmt.Sprintf("CONCEPTE%03d", i), fmt.Sprintf("frase %03d", i)))
+	}
+	setTestEntries(t, entries)
+	parseTemplates()
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/categoria/sv")
+	body := response.Body.String()
+	if response.Code != http.StatusOK || !hasEntry(body, "frase 000") || hasEntry(body, fmt.Sprintf("frase %03d", CategoryPageSize)) {
+		t.Errorf("GET /categoria/sv = %d, want the first %d phrases", response.Code, CategoryPageSize)
+	}
+	if !strings.Contains(body, `href="/categoria/sv?pagina=2"`) {
+		t.Error("GET /categoria/sv does not link to the second page")
+	}
+
+	response = serveTestRequest(mux.ServeHTTP, "/categoria/sv?pagina=2")
+	body = response.Body.String()
+	if response.Code != http.StatusOK || !hasEntry(body, fmt.Sprintf("frase %03d", CategoryPageSize)) || hasEntry(body, "frase 000") {
+		t.Errorf("GET /categoria/sv?pagina=2 = %d, want the last phrase", response.Code)
+	}
+	for _, want := range []string{`href="/categoria/sv"`, `<link rel="canonical" href="` + BaseCanonicalURL + `/categoria/sv?pagina=2">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /categoria/sv?pagina=2 does not contain %q", want)
+		}
+	}
+}
+
+func TestLetterTextHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2025-01-01"
+	mux := newServeMux()
+
+	response := serveTestRequest(mux.ServeHTTP, "/lletra/C/text")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET /lletra/C/text = %d, want %d", response.Code, http.StatusOK)
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want plain text", contentType)
+	}
+	if disposition := response.Header().Get("Content-Disposition"); disposition != `attachment; filename="dsff-lletra-C.txt"` {
+		t.Errorf("Content-Disposition = %q, want an attachment named dsff-lletra-C.txt", disposition)
+	}
+
+	// Every concept of the letter is included, in order, with all its phrases.
+	body := response.Body.String()
+	concepts := ConceptsByFirstLetter["C"]
+	if len(concepts) < 2 {
+		t.Fatalf("the test data has %d concepts starting with C, want several", len(concepts))
+	}
+	previousPosition := -1
+	for _, concept := range concepts {
+		title := strings.ToUpper(getConceptTitle(concept))
+		position := strings.Index(body, "\n"+title+"\n")
+		if position < 0 {
+			t.Errorf("GET /lletra/C/text does not have the concept %q", title)
+			continue
+		}
+		if position < previousPosition {
+			t.Errorf("concept %q is not in the order of the letter page", title)
+		}
+		previousPosition = position
+		for _, entry := range getEntriesByConceptSlug(getConceptSlug(concept)) {
+			if !strings.Contains(body, entry.Title) {
+				t.Errorf("GET /lletra/C/text does not have the phrase %q of %q", entry.Title, concept)
+			}
+		}
+	}
+	if strings.Contains(body, "DESCANSAR") {
+		t.Error("GET /lletra/C/text has concepts of other letters")
+	}
+
+	// The letter page links to the download.
+	if letterPage := serveTestRequest(mux.ServeHTTP, "/lletra/C").Body.String(); !strings.Contains(letterPage, `href="/lletra/C/text"`) {
+		t.Error("GET /lletra/C does not link to the download")
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/lletra/C/text", nil)
+	request.Header.Set("If-None-Match", response.Header().Get("ETag"))
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("GET /lletra/C/text with the current ETag = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+
+	// Invalid letters, and letters without concepts, are not found.
+	for _, target := range []string{"/lletra/c/text", "/lletra/CA/text", "/lletra/1/text", "/lletra/Z/text"} {
+		if code := serveTestRequest(mux.ServeHTTP, target).Code; code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", target, code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestSearchFormKeepsPageSize(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+
+	tests := []struct {
+		target string
+		want   string // The hidden input of the page size, or "" if there is none.
+	}{
+		{target: "/?frase=mort&mida=5", want: `<input type="hidden" name="mida" value="5">`},
+		{target: "/?frase=mort&mida=1000", want: `<input type="hidden" name="mida" value="100">`},
+		{target: "/?frase=mort"},
+		{target: "/?frase=mort&mida=x"},
+		{target: "/"},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(searchHandler, test.target).Body.String()
+		hasInput := strings.Contains(body, `name="mida"`)
+		if hasInput != (test.want != "") || !strings.Contains(body, test.want) {
+			t.Errorf("GET %s: has a hidden page size = %t, want %q", test.target, hasInput, test.want)
+		}
+	}
+}
+
+func TestServeNotFound(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	const browserAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	tests := []struct {
+		target          string
+		accept          string
+		wantContentType string
+	}{
+		// Browsers get the HTML page, even for paths that look like file names.
+		{target: "/no-existeix", accept: browserAccept, wantContentType: "text/html; charset=utf-8"},
+		{target: "/no-existeix.html", accept: browserAccept, wantContentType: "text/html; charset=utf-8"},
+		{target: "/concepte/a.b", accept: browserAccept, wantContentType: "text/html; charset=utf-8"},
+		{target: "/no-existeix.css", accept: browserAccept, wantContentType: "text/html; charset=utf-8"},
+		{target: "/no-existeix", accept: "", wantContentType: "text/html; charset=utf-8"},
+		{target: "/no-existeix", accept: "application/json, text/html", wantContentType: "text/html; charset=utf-8"},
+
+		// API paths, and clients that only accept JSON, get a JSON error.
+		{target: "/api/desconeguda", accept: browserAccept, wantContentType: "application/json"},
+		{target: "/api/desconeguda", accept: "", wantContentType: "application/json"},
+		{target: "/api/concepte/inexistent", accept: "*/*", wantContentType: "application/json"},
+		{target: "/no-existeix", accept: "application/json", wantContentType: "application/json"},
+		{target: "/concepte/inexistent", accept: "application/json", wantContentType: "application/json"},
+		{target: "/no-existeix", accept: "application/json, text/html;q=0", wantContentType: "application/json"},
+
+		// Requests for static files get a bare 404.
+		{target: "/no-existeix.css", accept: "text/css,*/*;q=0.1", wantContentType: "text/plain; charset=utf-8"},
+		{target: "/no-existeix.png", accept: "image/avif,image/webp,*/*", wantContentType: "text/plain; charset=utf-8"},
+		{target: "/no-existeix.js", accept: "*/*", wantContentType: "text/plain; charset=utf-8"},
+		{target: "/no-existeix.js", accept: "", wantContentType: "text/plain; charset=utf-8"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.accept != "" {
+			request.Header.Set("Accept", test.accept)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		if response.Code != http.StatusNotFound {
+			t.Errorf("GET %s with Accept %q = %d, want %d", test.target, test.accept, response.Code, http.StatusNotFound)
+		}
+		if got := response.Header().Get("Content-Type"); got != test.wantContentType {
+			t.Errorf("GET %s with Accept %q has Content-Type %q, want %q", test.target, test.accept, got, test.wantContentType)
+		}
+		if test.wantContentType == "application/json" {
+			var errorResponse ErrorResponse
+			err := json.Unmarshal(response.Body.Bytes(), &errorResponse)
+			if err != nil || errorResponse.Error == "" {
+				t.Errorf("GET %s with Accept %q = %q, want a JSON error", test.target, test.accept, response.Body)
+			}
+		}
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	loadTestData(t)
+	mux := newServeMux()
+
+	// Errors of the API handlers are JSON, like their responses.
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{target: "/api/cerca", wantStatus: http.StatusBadRequest},
+		{target: "/api/cerca?frase=f", wantStatus: http.StatusBadRequest},
+		{target: "/api/concepte/inexistent", wantStatus: http.StatusNotFound},
+		{target: "/api/posicio?frase=fer+el+mort", wantStatus: http.StatusBadRequest},
+		{target: "/api/posicio?frase=f&entrada=fer+el+mort", wantStatus: http.StatusBadRequest},
+		{target: "/api/posicio?frase=fer+el+mort&entrada=estirar+la+pota", wantStatus: http.StatusNotFound},
+		{target: "/api/compara?a=callar", wantStatus: http.StatusBadRequest},
+		{target: "/api/compara?a=callar&b=inexistent", wantStatus: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(mux.ServeHTTP, test.target)
+		if response.Code != test.wantStatus {
+			t.Errorf("GET %s = %d, want %d", test.target, response.Code, test.wantStatus)
+		}
+		if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("GET %s has Content-Type %q, want application/json", test.target, contentType)
+		}
+		var errorResponse ErrorResponse
+		err := json.Unmarshal(response.Body.Bytes(), &errorResponse)
+		if err != nil || errorResponse.Error == "" {
+			t.Errorf("GET %s = %q, want a JSON error", test.target, response.Body)
+		}
+	}
+}
+
+func TestCategoryFacets(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	// "anima" matches two phrases of "sn", and one of each of "sadv" and "sp".
+	pageData := getSearchPageData(httptest.NewRequest(http.MethodGet, "/?frase=anima", nil))
+	wantNames := []string{"", "sintagma nominal", "sintagma adverbial", "sintagma preposicional"}
+	var names []string
+	for _, facet := range pageData.CategoryFacets {
+		names = append(names, facet.Name)
+	}
+	if !slices.Equal(names, wantNames) {
+		t.Fatalf("facets of %q = %q, want %q", "anima", names, wantNames)
+	}
+
+	// The count of each facet is the number of results of its link, which keeps the
+	// other facets with their counts.
+	for _, facet := range pageData.CategoryFacets {
+		filteredPageData := getSearchPageData(httptest.NewRequest(http.MethodGet, facet.URL, nil))
+		if filteredPageData.TotalResults != facet.Count {
+			t.Errorf("GET %s has %d results, want the %d of its facet", facet.URL, filteredPageData.TotalResults, facet.Count)
+		}
+		if !reflect.DeepEqual(getFacetCounts(filteredPageData.CategoryFacets), getFacetCounts(pageData.CategoryFacets)) {
+			t.Errorf("GET %s has facets %+v, want the same counts as %+v", facet.URL, filteredPageData.CategoryFacets, pageData.CategoryFacets)
+		}
+		for _, filteredFacet := range filteredPageData.CategoryFacets {
+			if filteredFacet.IsActive != (filteredFacet.Name == facet.Name) {
+				t.Errorf("GET %s has facet %+v active = %t", facet.URL, filteredFacet, filteredFacet.IsActive)
+			}
+		}
+	}
+
+	// The API honours the filter too.
+	response := serveTestRequest(mux.ServeHTTP, "/api/cerca?frase=anima&categoria=sn")
+	var apiResponse SearchAPIResponse
+	err := json.Unmarshal(response.Body.Bytes(), &apiResponse)
+	if err != nil || apiResponse.Total != 2 {
+		t.Errorf("GET /api/cerca?frase=anima&categoria=sn = %q, want 2 results", response.Body)
+	}
+
+	// Concept pages link to the categories of their phrases, and filter by them.
+	tests := []struct {
+		target     string
+		wantCount  int
+		wantFacets bool
+	}{
+		{target: "/concepte/comptar", wantCount: 3, wantFacets: true},
+		{target: "/concepte/comptar?categoria=sp", wantCount: 2, wantFacets: true},
+		{target: "/concepte/comptar?categoria=o", wantCount: 1, wantFacets: true},
+		{target: "/concepte/callar", wantCount: 5, wantFacets: false},
+	}
+	for _, test := range tests {
+		body := serveTestRequest(mux.ServeHTTP, test.target).Body.String()
+		if count := strings.Count(body, `<article class="entry frase`); count != test.wantCount {
+			t.Errorf("GET %s has %d phrases, want %d", test.target, count, test.wantCount)
+		}
+		if hasFacets := strings.Contains(body, "Categories:"); hasFacets != test.wantFacets {
+			t.Errorf("GET %s has category links = %t, want %t", test.target, hasFacets, test.wantFacets)
+		}
+	}
+	body := serveTestRequest(mux.ServeHTTP, "/concepte/comptar").Body.String()
+	if !strings.Contains(body, `<a href="/concepte/comptar?categoria=sp" rel="nofollow">sintagma preposicional</a> (2)`) {
+		t.Errorf("GET /concepte/comptar does not link to its sintagma preposicional phrases")
+	}
+}
+
+// getFacetCounts returns the count of each facet, by name.
+func getFacetCounts(facets []CategoryFacet) map[string]int {
+	counts := map[string]int{}
+	for _, facet := range facets {
+		counts[facet.Name] = facet.Count
+	}
+	return counts
+}
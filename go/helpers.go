@@ -1,56 +1,301 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	texttemplate "text/template"
+
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/text/collate"
 	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
 )
 
 // precompressedFileHandler serves pre-compressed .br or .gz files when the client accepts those encodings.
 // This is more efficient than runtime compression, especially for static files.
+//
+// If the version of the file is known (see StaticAssetVersions), it is used as its
+// ETag, and requests for the current version (see assetURL) are cached for a year.
 func precompressedFileHandler(originalPath, contentType string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", contentType)
 		w.Header().Set("Vary", "Accept-Encoding")
-		acceptEncoding := r.Header.Get("Accept-Encoding")
+
+		version := StaticAssetVersions[r.URL.Path]
+		if version != "" && r.URL.Query().Get("v") == version {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		// Each encoding is a different representation, so it needs a different ETag.
+		setVersionETag := func(encoding string) {
+			if version != "" {
+				w.Header().Set("ETag", `"`+version+encoding+`"`)
+			}
+		}
 
 		// Prefer Brotli if supported
-		if strings.Contains(acceptEncoding, "br") {
+		if acceptsEncoding(r, "br") {
 			brotliPath := originalPath + ".br"
 			_, err := os.Stat(brotliPath)
 			if err == nil {
 				w.Header().Set("Content-Encoding", "br")
+				setVersionETag("-br")
 				http.ServeFile(w, r, brotliPath)
 				return
 			}
 		}
 
 		// Fall back to gzip if supported
-		if strings.Contains(acceptEncoding, "gzip") {
+		if acceptsEncoding(r, "gzip") {
 			gzipPath := originalPath + ".gz"
 			_, err := os.Stat(gzipPath)
 			if err == nil {
 				w.Header().Set("Content-Encoding", "gzip")
+				setVersionETag("-gzip")
 				http.ServeFile(w, r, gzipPath)
 				return
 			}
 		}
 
 		// Fall back to serving the original uncompressed file
+		setVersionETag("")
 		http.ServeFile(w, r, originalPath)
 	}
 }
 
+// getStaticAssetVersions returns the version of each static asset, a short hash of the
+// contents of its file, by URL path. Files that cannot be read, e.g. when the server
+// is run from another directory, are left out with a warning, so that they are linked
+// without a version.
+func getStaticAssetVersions(files map[string]string) map[string]string {
+	versions := make(map[string]string, len(files))
+	for urlPath, filePath := range files {
+		contents, err := os.ReadFile(filePath)
+		if err != nil {
+			slog.Warn("Failed to read static asset, it will not be cached", "file", filePath, "error", err)
+			continue
+		}
+		hash := sha256.Sum256(contents)
+		versions[urlPath] = hex.EncodeToString(hash[:])[:10]
+	}
+	return versions
+}
+
+// checkPrecompressedFiles logs a warning for each static asset without its .br or .gz
+// pre-compressed variant, which means that the build of the assets is broken. The
+// assets are still served, uncompressed, see precompressedFileHandler.
+func checkPrecompressedFiles(files map[string]string) {
+	for _, filePath := range slices.Sorted(maps.Values(files)) {
+		for _, extension := range []string{".br", ".gz"} {
+			_, err := os.Stat(filePath + extension)
+			if err != nil {
+				slog.Warn("Pre-compressed static asset not found, run npm run build:assets", "file", filePath+extension)
+			}
+		}
+	}
+}
+
+// assetURL returns the URL of a static asset with its version, e.g.
+// "/main.min.css?v=0123456789", so that clients fetch it again when it changes. The
+// URL is returned unchanged if the version is unknown.
+func assetURL(urlPath string) string {
+	version := StaticAssetVersions[urlPath]
+	if version == "" {
+		return urlPath
+	}
+	return urlPath + "?v=" + version
+}
+
+// acceptsEncoding returns whether the Accept-Encoding header of a request lists a
+// content encoding, e.g. "gzip", without refusing it with "q=0".
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	return acceptsHeaderValue(r, "Accept-Encoding", encoding)
+}
+
+// acceptsHeaderValue returns whether a content negotiation header of a request, such
+// as Accept-Encoding, explicitly lists a value, without refusing it with "q=0".
+// Values are compared case-insensitively, and wildcards are not taken into account.
+func acceptsHeaderValue(r *http.Request, header, value string) bool {
+	for _, headerValue := range r.Header.Values(header) {
+		for item := range strings.SplitSeq(headerValue, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), value) {
+				continue
+			}
+			quality, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(params), "q="), 64)
+			return err != nil || quality > 0
+		}
+	}
+	return false
+}
+
+// acceptsMediaType returns whether the Accept header of a request explicitly lists a
+// media type, e.g. "application/json", without refusing it with "q=0". Wildcards such
+// as "*/*" are not taken into account.
+func acceptsMediaType(r *http.Request, mediaType string) bool {
+	return acceptsHeaderValue(r, "Accept", mediaType)
+}
+
+// isAPIRequest returns whether a request is for the JSON API: its path is under /api/,
+// or it accepts JSON but not HTML, e.g. from fetch calls.
+func isAPIRequest(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+	return acceptsMediaType(r, "application/json") && !acceptsMediaType(r, "text/html")
+}
+
+// isAssetRequest returns whether a request is for a static file, such as a stylesheet
+// or an image: its path has a file extension, and it does not accept HTML. Browsers
+// accept HTML when navigating, so pages whose path looks like a file name, e.g. of
+// concepts with dots, are not affected.
+func isAssetRequest(r *http.Request) bool {
+	extension := path.Ext(r.URL.Path)
+	return extension != "" && extension != ".html" && !acceptsMediaType(r, "text/html")
+}
+
+// getCacheKey derives a cache key from the given parts, the BuildDate, and the
+// DataVersion. Every caching feature must use this function, so that a new deploy,
+// or reloading the data, invalidates all cached HTML at once.
+//
+// Postconditions:
+//   - Returns an empty string if BuildDate is not set (e.g. development
+//     builds), meaning that nothing should be cached
+//   - Returns the same key for the same parts, BuildDate, and DataVersion
+func getCacheKey(parts ...string) string {
+	if BuildDate == "" {
+		return ""
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(BuildDate))
+	hash.Write([]byte{0})
+	hash.Write([]byte(DataVersion))
+	for _, part := range parts {
+		// Separate parts, so that ("ab", "c") and ("a", "bc") differ.
+		hash.Write([]byte{0})
+		hash.Write([]byte(part))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:20]
+}
+
+// getETag returns a strong ETag for the page requested, or an empty string if
+// the page should not be cached.
+func getETag(r *http.Request) string {
+	cacheKey := getCacheKey(r.URL.Path, r.URL.RawQuery)
+	if cacheKey == "" {
+		return ""
+	}
+	return `"` + cacheKey + `"`
+}
+
+// getDataETag returns a strong ETag for a response that depends only on the loaded
+// data and the query of the request, e.g. the export. Unlike getETag, it does not
+// change on deploys, and it is also set in development builds.
+func getDataETag(r *http.Request) string {
+	hash := sha256.New()
+	hash.Write([]byte(DataVersion))
+	hash.Write([]byte{0})
+	hash.Write([]byte(r.URL.RawQuery))
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:20] + `"`
+}
+
+// checkNotModified sets the ETag header for cacheable pages and, if the client
+// already has the current version, responds with 304 Not Modified.
+// Returns true if the response has been written and the handler should stop.
+func checkNotModified(w http.ResponseWriter, r *http.Request) bool {
+	return checkETagNotModified(w, r, getETag(r))
+}
+
+// checkETagNotModified works as checkNotModified, with the given ETag. Nothing is
+// done if the ETag is empty.
+func checkETagNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	for candidate := range strings.SplitSeq(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// newLogger returns a structured logger that writes to w, configured with the
+// LOG_LEVEL (debug, info, warn, or error; defaults to info) and LOG_FORMAT (text or
+// json; defaults to text) env variables.
+func newLogger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	if err != nil {
+		level = slog.LevelInfo
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		return slog.New(slog.NewJSONHandler(w, handlerOptions))
+	}
+	return slog.New(slog.NewTextHandler(w, handlerOptions))
+}
+
+// loadDataFromFileOrSample loads the dictionary data from the file set in the DATA_FILE
+// env variable. If it is not set, data.json.gz is loaded instead and, if it does not
+// exist, the embedded SampleData is used, so that the binary can run standalone.
+// The sample is never used when DATA_FILE is set, to avoid silently serving it in
+// production.
+func loadDataFromFileOrSample() error {
+	dataFile := os.Getenv("DATA_FILE")
+	if dataFile != "" {
+		return loadDataFromFile(dataFile)
+	}
+
+	err := loadDataFromFile(DefaultDataFile)
+	if !errors.Is(err, ErrDataMissing) {
+		return err
+	}
+
+	slog.Warn("Data file not found and DATA_FILE is not set, using the embedded sample data", "file", DefaultDataFile)
+	return loadData(bytes.NewReader(SampleData), "embedded sample data")
+}
+
 // getServerAddress returns the server address from the PORT env variable.
 func getServerAddress() string {
 	port := os.Getenv("PORT")
@@ -60,16 +305,370 @@ func getServerAddress() string {
 	return ":" + port
 }
 
+// getEnvOrDefault returns the value of an env variable, or defaultValue if it is not set.
+func getEnvOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// escapeXML escapes a string for use in XML text and attribute values.
+func escapeXML(text string) string {
+	var escaped strings.Builder
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// getConceptURLTrailingSlash returns the trailing slash policy for concept URLs from
+// the CONCEPT_URL_TRAILING_SLASH env variable. Defaults to no trailing slash.
+func getConceptURLTrailingSlash() bool {
+	trailingSlash, err := strconv.ParseBool(os.Getenv("CONCEPT_URL_TRAILING_SLASH"))
+	return err == nil && trailingSlash
+}
+
+// isProductionRequest returns whether a request is served by a production deployment.
+// It is not if Environment is not EnvironmentProduction, or if the request host looks
+// like a development or staging one, e.g. "localhost:8080" or "staging.dsff.uab.cat",
+// in case the env variable is not set.
+func isProductionRequest(r *http.Request) bool {
+	if Environment != EnvironmentProduction {
+		return false
+	}
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	firstLabel, _, _ := strings.Cut(strings.ToLower(host), ".")
+	return !slices.Contains([]string{"localhost", "staging", "dev", "test"}, firstLabel)
+}
+
+// getValidateData returns whether the data is validated when loaded from the
+// VALIDATE_DATA env variable. Defaults to false, as validating takes some time.
+func getValidateData() bool {
+	validate, err := strconv.ParseBool(os.Getenv("VALIDATE_DATA"))
+	return err == nil && validate
+}
+
+// logDataProblems logs the problems found in the loaded data by getDataProblems,
+// one line per problem, followed by a summary.
+func logDataProblems() {
+	problems := getDataProblems()
+	fatalCount := 0
+	for _, problem := range problems {
+		if problem.IsFatal {
+			fatalCount++
+		}
+		slog.Warn("Data problem", "index", problem.Index, "title", problem.Title,
+			"problem", problem.Message, "fatal", problem.IsFatal)
+	}
+	slog.Info("Validated data", "problems", len(problems), "fatal", fatalCount)
+}
+
+// getMaintenanceMode returns whether maintenance mode is on from the MAINTENANCE env variable.
+func getMaintenanceMode() bool {
+	maintenance, err := strconv.ParseBool(os.Getenv("MAINTENANCE"))
+	return err == nil && maintenance
+}
+
+// reloadDataOnSignal reloads the data when the process receives SIGHUP, e.g. after
+// the data file is updated, so that the server does not need to be restarted.
+// It is meant to run in the background.
+func reloadDataOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadData()
+	}
+}
+
+// reloadData loads the data file again, from DATA_FILE or DefaultDataFile, and
+// replaces the current data with it. If the file is missing, corrupt, or empty, the
+// error is logged and the current data is kept.
+//
+// Additionally:
+//   - Reloads are serialized with ReloadLock, so it is safe to call from several
+//     goroutines: a reload that starts while another is running waits for it
+//   - The new data is fully built before it is swapped in with setData
+//
+// Preconditions:
+//   - DataLock must not be held by the caller, e.g. in handlers, see releaseDataLock
+func reloadData() {
+	ReloadLock.Lock()
+	defer ReloadLock.Unlock()
+
+	start := time.Now()
+	dataFile := getEnvOrDefault("DATA_FILE", DefaultDataFile)
+	data, err := parseDataFile(dataFile)
+	if err != nil {
+		slog.Error("Failed to reload data, keeping the current data", "file", dataFile, "error", err)
+		return
+	}
+
+	// The data is only replaced while holding ReloadLock, so it can be read here without DataLock.
+	previousEntryCount := len(AllEntries)
+	setData(data)
+	slog.Info("Reloaded data", "file", dataFile, "previous_entries", previousEntryCount,
+		"entries", len(data.Entries), "version", data.Version, "duration", time.Since(start))
+	if ValidateData {
+		logDataProblems()
+	}
+
+	go warmSearchResultsCache(PopularQueries)
+}
+
+// getBaseCanonicalURL returns the base URL of canonical URLs from the
+// CANONICAL_BASE_URL env variable, falling back to DefaultBaseCanonicalURL.
+//
+// Additionally:
+//   - Trailing slashes are removed with a warning
+//   - URLs without an http or https scheme and a host are ignored with a warning
+func getBaseCanonicalURL() string {
+	baseURL := os.Getenv("CANONICAL_BASE_URL")
+	if baseURL == "" {
+		return DefaultBaseCanonicalURL
+	}
+
+	if strings.HasSuffix(baseURL, "/") {
+		slog.Warn("Removing the trailing slash of the canonical base URL", "url", baseURL)
+		baseURL = strings.TrimRight(baseURL, "/")
+	}
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		slog.Warn("Ignoring invalid canonical base URL", "url", baseURL, "default", DefaultBaseCanonicalURL)
+		return DefaultBaseCanonicalURL
+	}
+	return baseURL
+}
+
+// getMinQueryLength returns the minimum search query length from the
+// MIN_QUERY_LENGTH env variable, falling back to DefaultMinQueryLength.
+func getMinQueryLength() int {
+	minLength, err := strconv.Atoi(os.Getenv("MIN_QUERY_LENGTH"))
+	if err != nil || minLength < 1 {
+		return DefaultMinQueryLength
+	}
+	return minLength
+}
+
+// getRegexpCacheSize returns the number of compiled regexps to keep in
+// WholeWordsRegexpCache from the REGEXP_CACHE_SIZE env variable, falling back to
+// DefaultRegexpCacheSize. A size of 0 disables the cache.
+func getRegexpCacheSize() int {
+	size, err := strconv.Atoi(os.Getenv("REGEXP_CACHE_SIZE"))
+	if err != nil || size < 0 {
+		return DefaultRegexpCacheSize
+	}
+	return size
+}
+
+// getSlowRequestTime returns the duration above which requests are logged as slow from
+// the SLOW_REQUEST_TIME env variable, e.g. "500ms", falling back to
+// DefaultSlowRequestTime. Invalid and negative durations are ignored with a warning.
+func getSlowRequestTime() time.Duration {
+	value := os.Getenv("SLOW_REQUEST_TIME")
+	if value == "" {
+		return DefaultSlowRequestTime
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		slog.Warn("Ignoring invalid slow request time", "value", value)
+		return DefaultSlowRequestTime
+	}
+	return duration
+}
+
+// getPageSizesByMode returns the default page size of each search mode from the
+// SEARCH_PAGE_SIZES env variable, e.g. "Coincident=50,Conté=20". Unknown modes and
+// page sizes that are not between 1 and MaxSearchPageSize are ignored with a warning.
+func getPageSizesByMode() map[string]int {
+	pageSizes := make(map[string]int)
+	config := os.Getenv("SEARCH_PAGE_SIZES")
+	if config == "" {
+		return pageSizes
+	}
+
+	for setting := range strings.SplitSeq(config, ",") {
+		mode, value, _ := strings.Cut(setting, "=")
+		mode = strings.TrimSpace(mode)
+		pageSize, err := strconv.Atoi(strings.TrimSpace(value))
+		if !slices.Contains(SearchModes, mode) || err != nil || pageSize < 1 || pageSize > MaxSearchPageSize {
+			slog.Warn("Ignoring invalid page size setting", "setting", setting)
+			continue
+		}
+		pageSizes[mode] = pageSize
+	}
+	return pageSizes
+}
+
+// getDefaultPageSize returns the default page size of a search mode. An empty mode is
+// the default mode, SearchModeConte.
+func getDefaultPageSize(searchMode string) int {
+	if searchMode == "" {
+		searchMode = SearchModeConte
+	}
+	pageSize, exists := PageSizesByMode[searchMode]
+	if !exists {
+		return DefaultPageSize
+	}
+	return pageSize
+}
+
+// isQueryTooShort checks if a normalized query is too short to be searched.
+// Exact matches are exempt, as they never produce large result sets.
+func isQueryTooShort(normalizedQuery, searchMode string) bool {
+	if searchMode == SearchModeCoincident {
+		return false
+	}
+	return utf8.RuneCountInString(normalizedQuery) < MinQueryLength
+}
+
+// getCookieSecret returns the key used to sign cookies from the COOKIE_SECRET env variable.
+// If it is not set, a random key is generated, so signed cookies are only valid until the
+// server is restarted.
+func getCookieSecret() []byte {
+	secret := os.Getenv("COOKIE_SECRET")
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	randomSecret := make([]byte, 32)
+	_, _ = rand.Read(randomSecret)
+	return randomSecret
+}
+
+// signCookieValue encodes a cookie value and appends its HMAC signature, so that
+// tampered cookies can be detected by verifyCookieValue.
+func signCookieValue(value string) string {
+	mac := hmac.New(sha256.New, CookieSecret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString([]byte(value)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCookieValue decodes a cookie value created by signCookieValue.
+// Returns false if the value is malformed or its signature is not valid.
+func verifyCookieValue(signedValue string) (string, bool) {
+	encodedValue, encodedSignature, found := strings.Cut(signedValue, ".")
+	if !found {
+		return "", false
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(encodedValue)
+	if err != nil {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", false
+	}
+
+	mac := hmac.New(sha256.New, CookieSecret)
+	mac.Write(value)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", false
+	}
+
+	return string(value), true
+}
+
+// getRecentConceptSlugs returns the slugs of the concepts recently viewed by the client,
+// most recent first, from the signed cookie set by setRecentConceptsCookie.
+//
+// Postconditions:
+//   - Returns nil if the cookie is missing, malformed, or tampered with
+//   - Slugs of concepts that do not exist are left out
+//   - Returns at most MaxRecentConcepts slugs
+func getRecentConceptSlugs(r *http.Request) []string {
+	cookie, err := r.Cookie(RecentConceptsCookieName)
+	if err != nil {
+		return nil
+	}
+	value, valid := verifyCookieValue(cookie.Value)
+	if !valid || value == "" {
+		return nil
+	}
+
+	var slugs []string
+	for slug := range strings.SplitSeq(value, "\n") {
+		_, exists := ConceptsBySlug[slug]
+		if exists && !slices.Contains(slugs, slug) && len(slugs) < MaxRecentConcepts {
+			slugs = append(slugs, slug)
+		}
+	}
+	return slugs
+}
+
+// setRecentConceptsCookie adds a concept to the signed cookie of recently viewed concepts.
+// The concept is moved to the first position, and the list is capped at MaxRecentConcepts.
+func setRecentConceptsCookie(w http.ResponseWriter, r *http.Request, concept string) {
+	slug := getConceptSlug(concept)
+	slugs := []string{slug}
+	for _, recentSlug := range getRecentConceptSlugs(r) {
+		if recentSlug != slug && len(slugs) < MaxRecentConcepts {
+			slugs = append(slugs, recentSlug)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     RecentConceptsCookieName,
+		Value:    signCookieValue(strings.Join(slugs, "\n")),
+		Path:     "/",
+		MaxAge:   30 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getRecentConcepts returns the concepts recently viewed by the client, most recent first.
+func getRecentConcepts(r *http.Request) []string {
+	var concepts []string
+	for _, slug := range getRecentConceptSlugs(r) {
+		concepts = append(concepts, ConceptsBySlug[slug])
+	}
+	return concepts
+}
+
+// Catalan singular and plural forms of the words used with counts in the templates.
+// Adjectives are listed in both genders, so they can agree with their noun.
+var PluralForms = map[string]string{
+	"resultat": "resultats",
+	"pàgina":   "pàgines",
+	"frase":    "frases",
+	"concepte": "conceptes",
+	"trobat":   "trobats",
+	"trobada":  "trobades",
+}
+
+// pluralForm returns the form of a word that agrees with a count. In Catalan, only
+// a count of 1 takes the singular form (e.g. "0 resultats", "1 resultat").
+// Words not listed in PluralForms are returned unchanged.
+func pluralForm(count int, word string) string {
+	plural, exists := PluralForms[word]
+	if count == 1 || !exists {
+		return word
+	}
+	return plural
+}
+
+// pluralize returns a count followed by the form of a word that agrees with it,
+// e.g. "2 resultats".
+func pluralize(count int, word string) string {
+	return strconv.Itoa(count) + " " + pluralForm(count, word)
+}
+
 // getAllAbbreviations returns a map of all abbreviations and their corresponding full text.
 // This map is used to expand abbreviations found in the dictionary data.
 // Note: Some abbreviations might be substrings of longer words, which could lead to
-// false positives. However, this is currently only used in fields where this is not an issue.
+// false positives in replaceAbbreviations. See replaceAbbreviationsWholeWords.
 func getAllAbbreviations() map[string]string {
 	// This could cause false positives in replaceAbbreviations() if the
 	// abbreviation is a substring of a longer word. For example, sentences
 	// ending with words ending with "ant", "fam", "met", or the word "pop".
-	// But should not be a problem at the moment because it is only used in
-	// MarcatgeDialectal field.
+	// MarcatgeDialectal uses replaceAbbreviationsWholeWords() to avoid them.
 	return map[string]string{
 		"ant.":          "antonímia",
 		"aprox.":        "aproximadament",
@@ -135,6 +734,23 @@ func getObservationSources() map[string]string {
 	}
 }
 
+// getCategoryNames returns a map of grammatical category keys, as in the export, and
+// their full names. See getCategory.
+func getCategoryNames() map[string]string {
+	return map[string]string{
+		"o":      "oració",
+		"sa":     "sintagma adjectival",
+		"sadv":   "sintagma adverbial",
+		"sconj":  "sintagma conjuntiu",
+		"scoord": "sintagma coordinat",
+		"sd":     "sintagma determinant",
+		"sn":     "sintagma nominal",
+		"sp":     "sintagma preposicional",
+		"sq":     "sintagma quantificador",
+		"sv":     "sintagma verbal",
+	}
+}
+
 // getCategory returns the HTML representation of a grammatical category.
 // It takes a category key (e.g., "sv") and returns an HTML string with an
 // <abbr> tag that provides the full category name on hover.
@@ -155,21 +771,9 @@ func getCategory(categoryKey string) string {
 		"sq":     "SQ",
 		"sv":     "SV",
 	}
-	categoriesAbbr := map[string]string{
-		"o":      "oració",
-		"sa":     "sintagma adjectival",
-		"sadv":   "sintagma adverbial",
-		"sconj":  "sintagma conjuntiu",
-		"scoord": "sintagma coordinat",
-		"sd":     "sintagma determinant",
-		"sn":     "sintagma nominal",
-		"sp":     "sintagma preposicional",
-		"sq":     "sintagma quantificador",
-		"sv":     "sintagma verbal",
-	}
 
 	category := categories[categoryKey]
-	categoryTitle := categoriesAbbr[categoryKey]
+	categoryTitle := getCategoryNames()[categoryKey]
 
 	if category == "" || categoryTitle == "" {
 		return categoryKey
@@ -178,83 +782,503 @@ func getCategory(categoryKey string) string {
 	return fmt.Sprintf("<em><abbr title=\"%s\">%s</abbr></em>", categoryTitle, category)
 }
 
-// loadDataFromFile loads and processes the dictionary data from a gzipped JSON file.
-// It populates the global variables AllEntries, PhrasesMap, and ConceptsByFirstLetter,
-// which are used throughout the application. This function is called once at startup.
+// loadDataFromFile loads and processes the dictionary data from a JSON file, which is
+// usually gzipped (see parseData).
+// It populates the global variables AllEntries, DataVersion, PhrasesMap, ConceptsByFirstLetter,
+// ConceptsBySlug, ConceptSlugsByPhrase, EntryIndicesByCategory, EntryIndicesByWord,
+// PhrasesByNormalized, PhraseSuggestions, and ConceptSuggestions, which are used
+// throughout the application.
+// This function is called at startup, and when the data is reloaded.
+//
+// Postconditions:
+//   - Returns an error wrapping ErrDataMissing if the file does not exist, naming its
+//     absolute path, so that a wrong working directory or DATA_FILE is easy to spot
+//   - Returns an error wrapping ErrDataCorrupt if the file is not valid JSON,
+//     leaving the global variables untouched
+//   - Returns an error wrapping ErrDataEmpty if the file contains no entries, after
+//     populating the global variables
 func loadDataFromFile(filePath string) error {
+	data, err := parseDataFile(filePath)
+	if err != nil && !errors.Is(err, ErrDataEmpty) {
+		return err
+	}
+
+	setData(data)
+	return err
+}
+
+// parseDataFile parses and processes the dictionary data from a JSON file,
+// without replacing the current data. It returns the same errors as loadDataFromFile.
+func parseDataFile(filePath string) (DictionaryData, error) {
 	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		absolutePath, absErr := filepath.Abs(filePath)
+		if absErr != nil {
+			absolutePath = filePath
+		}
+		return DictionaryData{}, fmt.Errorf("%w: %s", ErrDataMissing, absolutePath)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open data file %s: %w", filePath, err)
+		return DictionaryData{}, fmt.Errorf("failed to open data file %s: %w", filePath, err)
 	}
 	defer file.Close()
 
-	gzipReader, err := gzip.NewReader(file)
+	return parseData(file, filePath)
+}
+
+// decodeEntries decodes a JSON array of entries one entry at a time. Decoding the
+// whole array at once would keep all of its JSON in memory until it is decoded, on top
+// of the decoded entries, which is most of the memory used at startup.
+//
+// Postconditions:
+//   - Returns an empty slice, not nil, if the array is empty
+//   - Returns an error if the JSON is not an array of entries
+func decodeEntries(reader io.Reader) ([]Entry, error) {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return nil, err
+	}
+	if token != json.Delim('[') {
+		return nil, fmt.Errorf("expected an array of entries, found %v", token)
+	}
+
+	entries := []Entry{}
+	for decoder.More() {
+		var entry Entry
+		err = decoder.Decode(&entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", len(entries), err)
+		}
+		entries = append(entries, entry)
 	}
-	defer gzipReader.Close()
 
-	err = json.NewDecoder(gzipReader).Decode(&AllEntries)
+	// Consume the closing bracket, so that the data after the array is hashed by the
+	// caller, and a truncated array is an error.
+	_, err = decoder.Token()
 	if err != nil {
-		return fmt.Errorf("failed to decode JSON: %w", err)
+		return nil, err
 	}
+	return entries, nil
+}
 
-	PhrasesMap = make(map[string]bool, len(AllEntries))
-	ConceptsByFirstLetter = make(map[string][]string)
+// isGzipped returns whether a reader starts with the gzip magic bytes, without
+// consuming them.
+func isGzipped(reader *bufio.Reader) bool {
+	magic, err := reader.Peek(2)
+	return err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+}
 
-	// Populate data structures for efficient lookups.
-	for _, entry := range AllEntries {
-		PhrasesMap[removeParenthesesContent(entry.Title)] = true
+// loadData loads and processes the dictionary data from a reader of JSON, usually gzipped.
+// The name is only used in error messages. See loadDataFromFile.
+func loadData(reader io.Reader, name string) error {
+	data, err := parseData(reader, name)
+	if err != nil && !errors.Is(err, ErrDataEmpty) {
+		return err
+	}
+
+	setData(data)
+	return err
+}
 
-		// Group concepts by their first letter for alphabetical browsing.
-		firstRune := []rune(entry.Concepte)[0]
-		key := strings.ToUpper(toLowercaseNoAccents(string(firstRune)))
+// setData replaces the global variables derived from the data file with the given
+// data, all at once, and clears the cached search results, which are no longer valid.
+// Requests in flight keep using the previous data until they finish, see DataLock.
+func setData(data DictionaryData) {
+	DataLock.Lock()
+	defer DataLock.Unlock()
+
+	AllEntries = data.Entries
+	DataVersion = data.Version
+	PhrasesMap = data.PhrasesMap
+	ConceptsByFirstLetter = data.ConceptsByFirstLetter
+	ConceptsBySlug = data.ConceptsBySlug
+	ConceptSlugsByPhrase = data.ConceptSlugsByPhrase
+	EntryIndicesByCategory = data.EntryIndicesByCategory
+	EntryIndicesByWord = data.EntryIndicesByWord
+	PhrasesByNormalized = data.PhrasesByNormalized
+	PhraseSuggestions = data.PhraseSuggestions
+	ConceptSuggestions = data.ConceptSuggestions
+	SearchResultsCache.Clear()
+}
 
-		// Add the concept to the list for its corresponding letter, avoiding duplicates.
-		if !slices.Contains(ConceptsByFirstLetter[key], entry.Concepte) {
-			ConceptsByFirstLetter[key] = append(ConceptsByFirstLetter[key], entry.Concepte)
+// parseData parses and processes the dictionary data from a reader of JSON, into fresh
+// copies of the global variables derived from it. The JSON is decompressed first if it
+// is gzipped, which is detected from its first bytes, so that plain JSON files can be
+// used during development. The name is only used in error messages. It returns the same
+// errors as loadDataFromFile, and the data is complete if the error wraps ErrDataEmpty.
+func parseData(reader io.Reader, name string) (DictionaryData, error) {
+	bufferedReader := bufio.NewReader(reader)
+	var jsonReader io.Reader = bufferedReader
+	if isGzipped(bufferedReader) {
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return DictionaryData{}, fmt.Errorf("%w: failed to create gzip reader: %w", ErrDataCorrupt, err)
 		}
+		defer gzipReader.Close()
+		jsonReader = gzipReader
 	}
 
-	// Sort the concepts within each letter group alphabetically.
-	collator := collate.New(language.Catalan)
-	for _, conceptList := range ConceptsByFirstLetter {
-		slices.SortFunc(conceptList, collator.CompareString)
+	// Hash the uncompressed contents while decoding them, so that the version does
+	// not depend on how the file was compressed.
+	hash := sha256.New()
+	entries, err := decodeEntries(io.TeeReader(jsonReader, hash))
+	if err != nil {
+		return DictionaryData{}, fmt.Errorf("%w: failed to decode JSON: %w", ErrDataCorrupt, err)
+	}
+	// Hash the rest of the contents too, e.g. a trailing newline, which also checks
+	// the gzip checksum.
+	_, err = io.Copy(hash, jsonReader)
+	if err != nil {
+		return DictionaryData{}, fmt.Errorf("%w: failed to read data: %w", ErrDataCorrupt, err)
 	}
 
-	return nil
-}
+	data := DictionaryData{
+		Entries:                entries,
+		Version:                hex.EncodeToString(hash.Sum(nil))[:20],
+		PhrasesMap:             make(map[string]bool, len(entries)),
+		ConceptsByFirstLetter:  make(map[string][]string),
+		ConceptsBySlug:         make(map[string]string),
+		ConceptSlugsByPhrase:   make(map[string][]string),
+		EntryIndicesByCategory: make(map[string][]int),
+		EntryIndicesByWord:     make(map[string][]int),
+	}
 
-// getCanonicalURL returns the canonical URL for a given request.
-// This is used to generate <link rel="canonical"> tags, which helps prevent
-// search engines from indexing duplicate content from development or staging environments.
-func getCanonicalURL(r *http.Request) string {
-	canonical := BaseCanonicalURL + r.URL.EscapedPath()
+	// Count how many entries use each spelling of a concept, to pick the
+	// representative spelling of concepts with inconsistent casing or accents.
+	conceptCounts := make(map[string]int)
+	for _, entry := range data.Entries {
+		conceptCounts[entry.Concepte]++
+	}
 
-	// For search results (on the root path), include the mode and frase query parameters.
-	if r.URL.Path == "/" || r.URL.Path == "" {
-		params := url.Values{}
-		mode := r.URL.Query().Get("mode")
-		if mode != "" {
-			params.Set("mode", mode)
+	// Concepts to list by letter, by their slug without accents. Concepts that differ
+	// only in casing or accents are listed once, with their most common spelling.
+	listedConcepts := make(map[string]string)
+
+	// Populate data structures for efficient lookups.
+	for i, entry := range data.Entries {
+		data.PhrasesMap[removeParenthesesContent(entry.Title)] = true
+		data.EntryIndicesByCategory[entry.Categoria] = append(data.EntryIndicesByCategory[entry.Categoria], i)
+		for _, word := range getPhraseWords(entry.TitleNormalizedWpc, entry.TitleNormalizedWp) {
+			data.EntryIndicesByWord[word] = append(data.EntryIndicesByWord[word], i)
+		}
+
+		// Use the most common spelling of the concept. On ties, keep the first one.
+		slug := getConceptSlug(entry.Concepte)
+		representative, exists := data.ConceptsBySlug[slug]
+		if !exists || conceptCounts[entry.Concepte] > conceptCounts[representative] {
+			data.ConceptsBySlug[slug] = entry.Concepte
 		}
-		frase := r.URL.Query().Get("frase")
-		if frase != "" {
-			params.Set("frase", frase)
+
+		if !slices.Contains(data.ConceptSlugsByPhrase[entry.TitleNormalizedWpc], slug) {
+			data.ConceptSlugsByPhrase[entry.TitleNormalizedWpc] = append(data.ConceptSlugsByPhrase[entry.TitleNormalizedWpc], slug)
 		}
 
-		if len(params) > 0 {
-			canonical += "?" + params.Encode()
+		listedKey := toLowercaseNoAccents(slug)
+		listed, exists := listedConcepts[listedKey]
+		if !exists || conceptCounts[entry.Concepte] > conceptCounts[listed] {
+			listedConcepts[listedKey] = entry.Concepte
 		}
 	}
 
-	return canonical
-}
+	// Group concepts by their first letter for alphabetical browsing.
+	// Concepts without a letter from A to Z are not listed, see getConceptInitial.
+	for _, concept := range listedConcepts {
+		key, hasInitial := getConceptInitial(concept)
+		if hasInitial {
+			data.ConceptsByFirstLetter[key] = append(data.ConceptsByFirstLetter[key], concept)
+		}
+	}
 
-// createAbbrReplacer creates a strings.Replacer to replace abbreviations with <abbr> tags.
-func createAbbrReplacer(abbrMap map[string]string) *strings.Replacer {
-	var replacements []string
-	for key, value := range abbrMap {
+	// Stem phrases, and normalize synonyms, related phrases, examples, and definitions
+	// for searching.
+	// This needs the map of phrases to be complete, to split the lists of phrases
+	// correctly.
+	for i, entry := range data.Entries {
+		data.Entries[i].TitleStemmed = stemPhrase(entry.TitleNormalizedWpc)
+		data.Entries[i].TitleAccented = NormalizedPhrase{
+			Wpc: normalizeForSearchKeepingAccents(removeParenthesesContent(entry.Title)),
+			Wp:  normalizeForSearchKeepingAccents(entry.Title),
+		}
+		for _, field := range []string{entry.Sinonims, entry.AltresRelacions} {
+			for _, phrase := range splitPhrases(field, data.PhrasesMap) {
+				data.Entries[i].RelatedPhrasesNormalized = append(data.Entries[i].RelatedPhrasesNormalized, normalizePhrase(phrase))
+			}
+		}
+		data.Entries[i].ExemplesNormalized = normalizeForSearch(stripHTML(entry.Exemples))
+		data.Entries[i].DefinicioNormalized = normalizeForSearch(stripHTML(entry.Definicio))
+	}
+
+	// Sort the concepts within each letter group alphabetically.
+	collator := collate.New(language.Catalan)
+	for _, conceptList := range data.ConceptsByFirstLetter {
+		slices.SortFunc(conceptList, collator.CompareString)
+	}
+
+	// Sort the entries of each category by concept and phrase, for browsing.
+	for _, indices := range data.EntryIndicesByCategory {
+		slices.SortStableFunc(indices, func(a, b int) int {
+			return cmp.Or(
+				collator.CompareString(data.Entries[a].Concepte, data.Entries[b].Concepte),
+				collator.CompareString(data.Entries[a].TitleNormalizedWpc, data.Entries[b].TitleNormalizedWpc),
+			)
+		})
+	}
+
+	// Sort the phrases by their normalized form, for suggestions.
+	data.PhraseSuggestions = make([]PhraseSuggestion, 0, len(entries))
+	for _, entry := range data.Entries {
+		data.PhraseSuggestions = append(data.PhraseSuggestions, PhraseSuggestion{Normalized: entry.TitleNormalizedWpc, Title: entry.Title})
+	}
+	slices.SortFunc(data.PhraseSuggestions, func(a, b PhraseSuggestion) int {
+		return cmp.Or(strings.Compare(a.Normalized, b.Normalized), strings.Compare(a.Title, b.Title))
+	})
+	data.PhraseSuggestions = slices.Compact(data.PhraseSuggestions)
+
+	// Rank the phrases with the Catalan collation rules, so that suggestions are not
+	// collated on every keystroke. Ties are broken by bytes, so that the rank is stable.
+	byCollation := slices.Clone(data.PhraseSuggestions)
+	slices.SortFunc(byCollation, func(a, b PhraseSuggestion) int {
+		return cmp.Or(collator.CompareString(a.Title, b.Title), strings.Compare(a.Title, b.Title))
+	})
+	rankByTitle := make(map[string]int, len(byCollation))
+	for _, suggestion := range byCollation {
+		if _, exists := rankByTitle[suggestion.Title]; !exists {
+			rankByTitle[suggestion.Title] = len(rankByTitle)
+		}
+	}
+	for i, suggestion := range data.PhraseSuggestions {
+		data.PhraseSuggestions[i].CollationRank = rankByTitle[suggestion.Title]
+	}
+
+	// Sort the concepts by their normalized title, for suggestions. Of the concepts with
+	// the same normalized title, only the first is kept, so that suggestions are stable.
+	data.ConceptSuggestions = make([]ConceptSuggestion, 0, len(data.ConceptsBySlug))
+	for _, concept := range data.ConceptsBySlug {
+		data.ConceptSuggestions = append(data.ConceptSuggestions, ConceptSuggestion{Normalized: normalizeForSearch(getConceptTitle(concept)), Concept: concept})
+	}
+	slices.SortFunc(data.ConceptSuggestions, func(a, b ConceptSuggestion) int {
+		return cmp.Or(strings.Compare(a.Normalized, b.Normalized), strings.Compare(a.Concept, b.Concept))
+	})
+	data.ConceptSuggestions = slices.CompactFunc(data.ConceptSuggestions, func(a, b ConceptSuggestion) bool {
+		return a.Normalized == b.Normalized
+	})
+
+	// Sort the normalized phrases, in both forms, for searches by their start.
+	data.PhrasesByNormalized = make([]IndexedPhrase, 0, len(entries))
+	for i, entry := range data.Entries {
+		data.PhrasesByNormalized = append(data.PhrasesByNormalized, IndexedPhrase{Normalized: entry.TitleNormalizedWpc, Index: i})
+		if entry.TitleNormalizedWp != entry.TitleNormalizedWpc {
+			data.PhrasesByNormalized = append(data.PhrasesByNormalized, IndexedPhrase{Normalized: entry.TitleNormalizedWp, Index: i})
+		}
+	}
+	slices.SortFunc(data.PhrasesByNormalized, func(a, b IndexedPhrase) int {
+		return cmp.Or(strings.Compare(a.Normalized, b.Normalized), a.Index-b.Index)
+	})
+
+	if len(data.Entries) == 0 {
+		return data, fmt.Errorf("%w: %s", ErrDataEmpty, name)
+	}
+
+	return data, nil
+}
+
+// getDataProblems checks the loaded entries for problems, in export order. Fatal
+// problems break the site, e.g. entries that cannot be linked to. The rest are
+// inconsistencies that should be fixed in the CMS.
+//
+// Postconditions:
+//   - Returns an empty slice if no problems are found
+func getDataProblems() []DataProblem {
+	var problems []DataProblem
+	addProblem := func(index int, entry Entry, isFatal bool, format string, args ...any) {
+		problems = append(problems, DataProblem{
+			Index:   index,
+			Title:   entry.Title,
+			Message: fmt.Sprintf(format, args...),
+			IsFatal: isFatal,
+		})
+	}
+
+	seenEntries := make(map[[3]string]int)
+	for i, entry := range AllEntries {
+		if strings.TrimSpace(entry.Title) == "" {
+			addProblem(i, entry, true, "empty title")
+		}
+		if strings.TrimSpace(entry.Concepte) == "" {
+			addProblem(i, entry, true, "empty concept")
+		} else if _, hasInitial := getConceptInitial(entry.Concepte); !hasInitial {
+			addProblem(i, entry, false, "concept %q does not start with a letter, it is not listed in letter pages", entry.Concepte)
+		}
+		if entry.TitleNormalizedWpc == "" || entry.TitleNormalizedWp == "" {
+			addProblem(i, entry, false, "missing normalized title")
+		}
+		// Queries are lowercased and stripped of accents before matching the
+		// normalized titles, so a title that is not normalized the same way is never found.
+		if entry.TitleNormalizedWpc != toLowercaseNoAccents(entry.TitleNormalizedWpc) {
+			addProblem(i, entry, false, "title_normalized_wpc %q has uppercase or accented letters, it cannot be searched", entry.TitleNormalizedWpc)
+		}
+		if entry.TitleNormalizedWp != toLowercaseNoAccents(entry.TitleNormalizedWp) {
+			addProblem(i, entry, false, "title_normalized_wp %q has uppercase or accented letters, it cannot be searched", entry.TitleNormalizedWp)
+		}
+		if strings.TrimSpace(entry.Definicio) == "" {
+			addProblem(i, entry, false, "empty definition")
+		}
+		if getCategory(entry.Categoria) == entry.Categoria {
+			addProblem(i, entry, false, "unknown category %q", entry.Categoria)
+		}
+
+		key := [3]string{entry.Concepte, entry.AccepcioConcepte, entry.Title}
+		if firstIndex, exists := seenEntries[key]; exists {
+			addProblem(i, entry, false, "duplicate of entry %d", firstIndex)
+		} else {
+			seenEntries[key] = i
+		}
+	}
+
+	return problems
+}
+
+// getConceptInitial returns the letter, from A to Z, under which a concept is listed
+// in the letter pages. Leading characters that are not letters, such as quotes or
+// punctuation, are skipped, and accents and the cedilla are removed, so that e.g.
+// "«ÀNIMA»" is listed under "A" and "ÇA" under "C".
+//
+// Postconditions:
+//   - Returns false if the concept has no letters, e.g. it is empty or starts with a
+//     digit, or if its first letter is not a Latin letter. Such concepts are not
+//     listed in any letter page, but can still be searched and linked to
+func getConceptInitial(concept string) (string, bool) {
+	for _, r := range concept {
+		if unicode.IsDigit(r) {
+			return "", false
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		initial := strings.ToUpper(strings.ReplaceAll(toLowercaseNoAccents(string(r)), "ç", "c"))
+		if len(initial) != 1 || initial[0] < 'A' || initial[0] > 'Z' {
+			return "", false
+		}
+		return initial, true
+	}
+	return "", false
+}
+
+// isDataLoaded checks if the dictionary has any entries to serve.
+func isDataLoaded() bool {
+	return len(AllEntries) > 0
+}
+
+// loadRetiredConceptSlugs loads the slugs of the concepts permanently removed from the
+// dictionary from a text file, with one slug per line. Empty lines and lines starting
+// with "#" are ignored. Returns an empty set if filePath is empty.
+func loadRetiredConceptSlugs(filePath string) (map[string]bool, error) {
+	slugs := make(map[string]bool)
+	if filePath == "" {
+		return slugs, nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retired concepts file %s: %w", filePath, err)
+	}
+
+	for line := range strings.Lines(string(content)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		slugs[getConceptSlug(line)] = true
+	}
+	return slugs, nil
+}
+
+// loadPopularQueries loads the searches to warm SearchResultsCache with from a text
+// file, with one query per line, most popular first. Empty lines and lines starting
+// with "#" are ignored, as are queries that are too short to be run, so MinQueryLength
+// must be set first. Returns an empty list if filePath is empty.
+func loadPopularQueries(filePath string) ([]string, error) {
+	var queries []string
+	if filePath == "" {
+		return queries, nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read popular queries file %s: %w", filePath, err)
+	}
+
+	for line := range strings.Lines(string(content)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		normalizedQuery := normalizeForSearch(line)
+		if isQueryTooShort(normalizedQuery, SearchModeConte) || slices.Contains(queries, normalizedQuery) {
+			continue
+		}
+		queries = append(queries, normalizedQuery)
+	}
+	return queries, nil
+}
+
+// warmSearchResultsCache renders the first page of results of each of the given
+// normalized queries, in the default search mode, so that the first users after the
+// data is loaded do not pay for rendering them. It is meant to run in the background.
+//
+// Additionally:
+//   - Does nothing if caching is disabled, i.e. BuildDate is not set, see getCacheKey
+//   - Queries beyond the capacity of SearchResultsCache are ignored, as they would
+//     evict the most popular ones
+func warmSearchResultsCache(normalizedQueries []string) {
+	// Check BuildDate rather than call getCacheKey, which reads DataVersion, as the
+	// data may be reloaded meanwhile.
+	if len(normalizedQueries) == 0 || BuildDate == "" {
+		return
+	}
+
+	start := time.Now()
+	normalizedQueries = normalizedQueries[:min(len(normalizedQueries), SearchResultsCacheSize)]
+	// Render the least popular first, so that the most popular are the most recently used.
+	for _, normalizedQuery := range slices.Backward(normalizedQueries) {
+		// Lock for each query only, so that reloading the data does not wait for all.
+		DataLock.RLock()
+		getSearchResultsPage(normalizedQuery, SearchOptions{Mode: SearchModeConte}, false, 1, getDefaultPageSize(SearchModeConte))
+		DataLock.RUnlock()
+	}
+	slog.Info("Warmed search results cache", "queries", len(normalizedQueries), "duration", time.Since(start))
+}
+
+// getCanonicalURL returns the canonical URL for a given request.
+// This is used to generate <link rel="canonical"> tags, which helps prevent
+// search engines from indexing duplicate content from development or staging environments.
+func getCanonicalURL(r *http.Request) string {
+	canonical := BaseCanonicalURL + r.URL.EscapedPath()
+
+	// For search results (on the root path), include the mode, frase, and camp query parameters.
+	// Unknown modes are left out, so that bogus values do not create duplicate URLs.
+	frase := r.URL.Query().Get("frase")
+	if (r.URL.Path == "/" || r.URL.Path == "") && frase != "" {
+		mode := r.URL.Query().Get("mode")
+		if !slices.Contains(SearchModes, mode) {
+			mode = ""
+		}
+		filters := url.Values{}
+		searchField := r.URL.Query().Get("camp")
+		if searchField == SearchFieldSinonims || searchField == SearchFieldExemples || searchField == SearchFieldDefinicio {
+			filters.Set("camp", searchField)
+		}
+		canonical = BaseCanonicalURL + searchURL(frase, mode, 0, filters)
+	}
+
+	return canonical
+}
+
+// createAbbrReplacer creates a strings.Replacer to replace abbreviations with <abbr> tags.
+func createAbbrReplacer(abbrMap map[string]string) *strings.Replacer {
+	var replacements []string
+	for key, value := range abbrMap {
 		replacements = append(replacements, key, fmt.Sprintf("<abbr title=\"%s\">%s</abbr>", value, key))
 	}
 	return strings.NewReplacer(replacements...)
@@ -284,6 +1308,53 @@ func replaceAbbreviations(text string) string {
 	return createAbbrReplacer(getAllAbbreviations()).Replace(text)
 }
 
+// replaceAbbreviationsWholeWords replaces abbreviations as replaceAbbreviations does,
+// but only where they are whole words, i.e. not preceded by a letter, nor followed by
+// one if they end with a letter. For example, "met." is replaced in "Mall. (met.)",
+// but not in "Palamet.". Longer abbreviations are preferred, e.g. "Camp de Tarr." over
+// "Tarr.".
+func replaceAbbreviationsWholeWords(text string) string {
+	isWordRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsMark(r)
+	}
+
+	abbreviations := getAllAbbreviations()
+	keys := slices.SortedFunc(maps.Keys(abbreviations), func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+
+	var output strings.Builder
+	previousRune := ' '
+	for i := 0; i < len(text); {
+		matchedKey := ""
+		if !isWordRune(previousRune) {
+			for _, key := range keys {
+				if !strings.HasPrefix(text[i:], key) {
+					continue
+				}
+				lastRune, _ := utf8.DecodeLastRuneInString(key)
+				nextRune, _ := utf8.DecodeRuneInString(text[i+len(key):])
+				if !isWordRune(lastRune) || !isWordRune(nextRune) {
+					matchedKey = key
+					break
+				}
+			}
+		}
+
+		if matchedKey != "" {
+			fmt.Fprintf(&output, "<abbr title=\"%s\">%s</abbr>", abbreviations[matchedKey], matchedKey)
+			i += len(matchedKey)
+			previousRune, _ = utf8.DecodeLastRuneInString(matchedKey)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		output.WriteRune(r)
+		i += size
+		previousRune = r
+	}
+	return output.String()
+}
+
 // replaceSourceAbbreviationsParentheses replaces source abbreviations that are enclosed in parentheses.
 // For example, it transforms "(DIEC1)" into "(<abbr title=\"...\">DIEC1</abbr>)".
 func replaceSourceAbbreviationsParentheses(text string) string {
@@ -299,10 +1370,19 @@ func replaceObservationsSourceAbbreviations(text string) string {
 // getSources formats a comma-separated string of source abbreviations into an HTML string.
 // Each source is wrapped in an <abbr> tag with its full name as the title.
 // The entire string is enclosed in parentheses.
+//
+// Additionally:
+//   - Parentheses enclosing the whole string are optional, e.g. "(A-M, R-M)" and
+//     "A-M, R-M" give the same output
+//   - A source may be followed by an annotation in parentheses, which is kept as
+//     text, e.g. "(A-M (via B))" gives "(<abbr ...>A-M</abbr> (via B))"
+//   - Commas inside annotations do not separate sources, see smartSplit
+//
+// Postconditions:
+//   - The output has no unmatched parentheses, as they are removed
 func getSources(sources string) string {
-	// Remove parentheses
-	cleanedSources := strings.ReplaceAll(sources, "(", "")
-	cleanedSources = strings.ReplaceAll(cleanedSources, ")", "")
+	cleanedSources := removeUnmatchedParentheses(sources)
+	cleanedSources = removeEnclosingParentheses(strings.TrimSpace(cleanedSources))
 	cleanedSources = strings.TrimSpace(cleanedSources)
 
 	if cleanedSources == "" {
@@ -311,19 +1391,25 @@ func getSources(sources string) string {
 
 	allSources := getAllSources()
 
-	sourcesList := strings.Split(cleanedSources, ",")
+	sourcesList := smartSplit(cleanedSources, ",")
 	var formattedSources []string
 
 	for _, source := range sourcesList {
-		source = strings.TrimSpace(source)
+		// Look up the source without its annotation, if any.
+		annotation := ""
+		if index := strings.Index(source, "("); index > 0 {
+			annotation = " " + source[index:]
+			source = strings.TrimSpace(source[:index])
+		}
+
 		fullForm, exists := allSources[source]
 		if exists {
 			formattedSources = append(formattedSources,
-				fmt.Sprintf("<abbr title=\"%s\">%s</abbr>", fullForm, source),
+				fmt.Sprintf("<abbr title=\"%s\">%s</abbr>%s", fullForm, source, annotation),
 			)
 		} else {
 			// Not found in the map, just keep the raw text
-			formattedSources = append(formattedSources, source)
+			formattedSources = append(formattedSources, source+annotation)
 		}
 	}
 
@@ -331,24 +1417,86 @@ func getSources(sources string) string {
 	return fmt.Sprintf("(%s)", joinedSources)
 }
 
+// removeEnclosingParentheses removes the parentheses that enclose a whole string, if
+// any, e.g. "((A, B))" becomes "A, B", but "(A) (B)" is returned unchanged.
+//
+// Preconditions:
+//   - The parentheses of text must be matched, see removeUnmatchedParentheses
+func removeEnclosingParentheses(text string) string {
+	for strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		// Check that the first parenthesis is closed by the last one.
+		depth := 0
+		for i, char := range text {
+			if char == '(' {
+				depth++
+			} else if char == ')' {
+				depth--
+			}
+			if depth == 0 && i < len(text)-1 {
+				return text
+			}
+		}
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+	return text
+}
+
+// removeUnmatchedParentheses removes the parentheses of a string that are not opened
+// or not closed, e.g. "(A-M (via B)" becomes "A-M (via B)". Matched parentheses are kept.
+func removeUnmatchedParentheses(text string) string {
+	runes := []rune(text)
+	isUnmatched := make([]bool, len(runes))
+	var openIndexes []int
+	for i, char := range runes {
+		if char == '(' {
+			openIndexes = append(openIndexes, i)
+		} else if char == ')' {
+			if len(openIndexes) == 0 {
+				isUnmatched[i] = true
+			} else {
+				openIndexes = openIndexes[:len(openIndexes)-1]
+			}
+		}
+	}
+	for _, i := range openIndexes {
+		isUnmatched[i] = true
+	}
+
+	var output strings.Builder
+	for i, char := range runes {
+		if !isUnmatched[i] {
+			output.WriteRune(char)
+		}
+	}
+	return output.String()
+}
+
 // getPhrase formats a single phrase for display, rendering it in bold.
 func getPhrase(phrase string) string {
 	return renderBoldPhrases(phrase, true)
 }
 
-// getNewIncorporationPhrase formats a new phrase, adding a marker and rendering it in bold.
-func getNewIncorporationPhrase(phrase string) string {
-	return "■ " + getPhrase(phrase)
+// getNewIncorporationPhrase adds the marker of new phrases to a phrase rendered with
+// getPhrase.
+func getNewIncorporationPhrase(phraseHTML string) string {
+	return "■ " + phraseHTML
 }
 
 // phraseExists checks if a given phrase exists in the dictionary.
 // It uses the PhrasesMap for efficient lookup.
 func phraseExists(phrase string) bool {
-	return PhrasesMap[removeParenthesesContent(phrase)]
+	return phraseExistsIn(PhrasesMap, phrase)
+}
+
+// phraseExistsIn checks if a given phrase exists in a map of phrases, such as
+// PhrasesMap, or the one of data that is being loaded.
+func phraseExistsIn(phrasesMap map[string]bool, phrase string) bool {
+	return phrasesMap[removeParenthesesContent(phrase)]
 }
 
 // smartSplit splits a string by a separator, but ignores separators that are inside parentheses.
 // This is useful for splitting lists of phrases where some phrases may contain commas.
+// The separator may be longer than one character.
 //
 // Postconditions:
 //   - Returns empty slice if input is empty
@@ -362,16 +1510,22 @@ func smartSplit(input, separator string) []string {
 	processedBuilder.Grow(len(input))
 	var parenthesesDepth int
 
-	for _, char := range input {
+	for i := 0; i < len(input); {
+		// Separators may be longer than one character, e.g. " / ".
+		if parenthesesDepth > 0 && strings.HasPrefix(input[i:], separator) {
+			processedBuilder.WriteString(placeholderUnusedChar)
+			i += len(separator)
+			continue
+		}
+
+		char, size := utf8.DecodeRuneInString(input[i:])
 		if char == '(' {
 			parenthesesDepth++
 		} else if char == ')' {
 			parenthesesDepth--
-		} else if string(char) == separator && parenthesesDepth > 0 {
-			processedBuilder.WriteString(placeholderUnusedChar)
-			continue
 		}
 		processedBuilder.WriteRune(char)
+		i += size
 	}
 
 	parts := strings.Split(processedBuilder.String(), separator)
@@ -391,9 +1545,203 @@ var PhrasesWhitelist = []string{
 	"córrer la Seca, la Meca i la vall d'Andorra (v.f.)",
 }
 
+// Separators of lists of phrases used occasionally by editors, instead of "," or ";".
+var AlternatePhraseListSeparators = []string{"/", "·"}
+
+// getPhraseListSeparator returns the separator used in a list of phrases, such as the
+// Sinonims field. Returns an empty string if the input is a single phrase that should
+// not be split. The phrases of the dictionary are given by phrasesMap, usually PhrasesMap.
+func getPhraseListSeparator(input string, phrasesMap map[string]bool) string {
+	if phraseExistsIn(phrasesMap, input) || slices.Contains(PhrasesWhitelist, input) {
+		// If the provided input exists as a phrase, don't try to split it.
+		return ""
+	}
+
+	if strings.Contains(input, ";") {
+		// ";" is used as a separator in the CMS when at least 1 phrase
+		// contains commas.
+		return ";"
+	}
+
+	// Editors occasionally use other separators. Only consider them if every
+	// occurrence is surrounded by spaces, so that phrases containing them are not
+	// broken apart.
+	for _, separator := range AlternatePhraseListSeparators {
+		spacedSeparatorCount := strings.Count(input, " "+separator+" ")
+		if spacedSeparatorCount > 0 && spacedSeparatorCount == strings.Count(input, separator) {
+			return separator
+		}
+	}
+
+	// By default, assume input can be multiple phrases separated by a comma
+	return ","
+}
+
+// splitPhrases splits a list of phrases, such as the Sinonims field, into single phrases.
+// It uses the same rules as renderBoldPhrases. The phrases of the dictionary are given
+// by phrasesMap, usually PhrasesMap.
+func splitPhrases(input string, phrasesMap map[string]bool) []string {
+	if input == "" {
+		return nil
+	}
+
+	separator := getPhraseListSeparator(input, phrasesMap)
+	if separator == "" {
+		return []string{input}
+	}
+	return splitPhraseList(input, separator, phrasesMap)
+}
+
+// splitPhraseList splits a list of phrases by a separator, like smartSplit. When the
+// separator is a comma, consecutive parts that together form an existing phrase are
+// joined back, so that phrases with commas are not broken apart, e.g. in
+// "fer-ho tot, fer-ho bé, ficar-se en tot" if "fer-ho tot, fer-ho bé" exists in phrasesMap.
+func splitPhraseList(input, separator string, phrasesMap map[string]bool) []string {
+	parts := smartSplit(input, separator)
+	if separator != "," {
+		return parts
+	}
+
+	var phrases []string
+	for i := 0; i < len(parts); i++ {
+		// Join the longest run of parts that forms an existing phrase, if any.
+		end := i
+		for j := len(parts) - 1; j > i; j-- {
+			if phraseExistsIn(phrasesMap, strings.Join(parts[i:j+1], separator+" ")) {
+				end = j
+				break
+			}
+		}
+		phrases = append(phrases, strings.Join(parts[i:end+1], separator+" "))
+		i = end
+	}
+	return phrases
+}
+
+// getPhraseSearchPath returns the path of a search for a phrase, as linked from other entries.
+func getPhraseSearchPath(phrase string) string {
+	return searchURL(removeParenthesesContent(phrase), SearchModeConte, 0, nil)
+}
+
+// getSearchFilters returns the query parameters of a search other than its mode, query,
+// and page, for keeping them in links with searchURL, e.g. in the pagination. The page
+// size is only kept if it was given explicitly.
+func getSearchFilters(options SearchOptions, groupByConcept bool, explicitPageSize int) url.Values {
+	filters := url.Values{}
+	if options.Field != "" {
+		filters.Set("camp", options.Field)
+	}
+	if explicitPageSize > 0 {
+		filters.Set("mida", strconv.Itoa(explicitPageSize))
+	}
+	if groupByConcept {
+		filters.Set("agrupa", GroupByConcept)
+	}
+	if options.ExcludeNew {
+		filters.Set("exclou", ExcludeNovetats)
+	}
+	if options.OnlyWithExamples {
+		filters.Set("amb", WithExemples)
+	}
+	if options.Category != "" {
+		filters.Set("categoria", options.Category)
+	}
+	if options.ExportOrder {
+		filters.Set("ordre", OrderExport)
+	}
+	if options.AccentedQuery != "" {
+		filters.Set("accents", AccentsExact)
+	}
+	return filters
+}
+
+// SearchURLFilters lists the query parameters of a search, other than mode, frase, and
+// pagina, in the order in which searchURL writes them.
+var SearchURLFilters = []string{"camp", "mida", "agrupa", "exclou", "amb", "categoria", "ordre", "accents"}
+
+// searchURL returns the path of a search, e.g. "/?mode=Cont%C3%A9&frase=fer+por". All
+// search links must be built with this function, also in templates, so that their
+// parameters are escaped and ordered consistently.
+//
+// Additionally:
+//   - The mode is left out if empty, and the page if it is 1 or less
+//   - Filters with empty values are left out. Filters not in SearchURLFilters are
+//     written after the known ones, sorted by name
+func searchURL(query, mode string, page int, filters url.Values) string {
+	var params []string
+	if mode != "" {
+		params = append(params, "mode="+url.QueryEscape(mode))
+	}
+	params = append(params, "frase="+url.QueryEscape(query))
+
+	filterNames := slices.Sorted(maps.Keys(filters))
+	slices.SortStableFunc(filterNames, func(a, b string) int {
+		// Unknown filters have index -1, so put them last.
+		indexA, indexB := slices.Index(SearchURLFilters, a), slices.Index(SearchURLFilters, b)
+		if indexA == -1 || indexB == -1 {
+			return indexB - indexA
+		}
+		return indexA - indexB
+	})
+	for _, name := range filterNames {
+		value := filters.Get(name)
+		if value != "" {
+			params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+
+	if page > 1 {
+		params = append(params, "pagina="+strconv.Itoa(page))
+	}
+	return "/?" + strings.Join(params, "&")
+}
+
+// getWhitelistCandidates returns the lists of phrases, such as the Sinonims field, that
+// contain an existing phrase with a comma or semicolon that splitPhrases (and therefore
+// renderBoldPhrases) breaks apart. They are candidates to be added to PhrasesWhitelist,
+// or to be fixed in the CMS by using ";" as the separator.
+//
+// Postconditions:
+//   - Each candidate list is returned once, in export order
+func getWhitelistCandidates() []WhitelistCandidate {
+	// Collect the phrases that can be broken apart when splitting.
+	var phrasesWithSeparators []string
+	for phrase := range PhrasesMap {
+		if strings.ContainsAny(phrase, ",;") {
+			phrasesWithSeparators = append(phrasesWithSeparators, phrase)
+		}
+	}
+	slices.Sort(phrasesWithSeparators)
+
+	var candidates []WhitelistCandidate
+	seen := make(map[string]bool)
+	for _, entry := range AllEntries {
+		for _, list := range []string{entry.Sinonims, entry.AltresRelacions, entry.VariantsDialectals} {
+			if list == "" || seen[list] {
+				continue
+			}
+
+			var splitList []string
+			for _, phrase := range splitPhrases(list, PhrasesMap) {
+				splitList = append(splitList, removeParenthesesContent(phrase))
+			}
+			listWithoutParentheses := removeParenthesesContent(list)
+			for _, phrase := range phrasesWithSeparators {
+				if strings.Contains(listWithoutParentheses, phrase) && !slices.Contains(splitList, phrase) {
+					candidates = append(candidates, WhitelistCandidate{Phrase: phrase, List: list})
+					seen[list] = true
+					break
+				}
+			}
+		}
+	}
+	return candidates
+}
+
 // renderBoldPhrases renders one or more phrases in bold.
 // If createLink is true, it also wraps each phrase in an anchor tag that links to a search for that phrase.
 // It handles single phrases, as well as lists of phrases separated by commas or semicolons.
+// Existing phrases that contain commas are kept whole, see splitPhraseList.
 func renderBoldPhrases(input string, createLink bool) string {
 	const placeholderUnusedChar = "|"
 
@@ -401,31 +1749,22 @@ func renderBoldPhrases(input string, createLink bool) string {
 		return ""
 	}
 
-	// By default, assume input can be multiple phrases separated by a comma
-	separator := ","
-	var isSinglePhrase bool
-
-	if phraseExists(input) || slices.Contains(PhrasesWhitelist, input) {
-		// If the provided input exists as a phrase, don't try to split it.
+	separator := getPhraseListSeparator(input, PhrasesMap)
+	isSinglePhrase := separator == ""
+	if isSinglePhrase {
 		// Use a placeholder that won't be in the input, so the sentence is not
 		// split but still gets processed correctly.
-		isSinglePhrase = true
 		separator = placeholderUnusedChar
-	} else if strings.Contains(input, ";") {
-		// ";" is used as a separator in the CMS when at least 1 phrase
-		// contains commas.
-		separator = ";"
 	}
 
-	phraseList := smartSplit(input, separator)
+	phraseList := splitPhraseList(input, separator, PhrasesMap)
 	for i, phrase := range phraseList {
 		isFormalVariant := strings.Contains(phrase, " (v.f.)")
 		shouldCreateLink := createLink && !isFormalVariant && phraseExists(phrase)
 
 		phraseHTML := fmt.Sprintf("<strong>%s</strong>", phrase)
 		if shouldCreateLink {
-			searchPath := "/?mode=Conté&frase=" + url.QueryEscape(removeParenthesesContent(phrase))
-			phraseHTML = fmt.Sprintf("<a href=\"%s\" rel=\"nofollow\">%s</a>", searchPath, phraseHTML)
+			phraseHTML = fmt.Sprintf("<a href=\"%s\" rel=\"nofollow\">%s</a>", html.EscapeString(getPhraseSearchPath(phrase)), phraseHTML)
 		}
 
 		// Make parentheses non-bold. This should not leave
@@ -445,17 +1784,54 @@ func renderBoldPhrases(input string, createLink bool) string {
 		phraseList[i] = phraseHTML
 	}
 
+	if slices.Contains(AlternatePhraseListSeparators, separator) {
+		return strings.Join(phraseList, " "+separator+" ")
+	}
 	return strings.Join(phraseList, separator+" ")
 }
 
+// getLetterCounts returns the initial letters of the concepts, sorted alphabetically,
+// along with their number of concepts.
+func getLetterCounts() []LetterCount {
+	letterCounts := make([]LetterCount, 0, len(ConceptsByFirstLetter))
+	for letter, concepts := range ConceptsByFirstLetter {
+		letterCounts = append(letterCounts, LetterCount{Letter: letter, ConceptCount: len(concepts)})
+	}
+	slices.SortFunc(letterCounts, func(a, b LetterCount) int {
+		return strings.Compare(a.Letter, b.Letter)
+	})
+	return letterCounts
+}
+
+// getConceptsByLetter returns, for each initial letter, sorted alphabetically, the
+// concepts starting with it along with their slugs and paths, in the same order as
+// on the letter pages. This allows a static site generator to pre-render all pages.
+func getConceptsByLetter() []LetterConcepts {
+	letterCounts := getLetterCounts()
+	letterConcepts := make([]LetterConcepts, 0, len(letterCounts))
+	for _, letterCount := range letterCounts {
+		concepts := ConceptsByFirstLetter[letterCount.Letter]
+		conceptLinks := make([]ConceptLink, 0, len(concepts))
+		for _, concept := range concepts {
+			conceptLinks = append(conceptLinks, ConceptLink{
+				Concept: concept,
+				Slug:    getConceptSlug(concept),
+				Path:    getConceptPath(concept),
+			})
+		}
+		letterConcepts = append(letterConcepts, LetterConcepts{Letter: letterCount.Letter, Concepts: conceptLinks})
+	}
+	return letterConcepts
+}
+
 // renderConceptsByLetter renders a list of concepts as an HTML unordered list.
 // Each concept is a link to its corresponding concept page. This is used on the letter pages.
 func renderConceptsByLetter(concepts []string) string {
 	var html strings.Builder
 	html.WriteString(`<ul class="list-unstyled">`)
 	for _, concept := range concepts {
-		fmt.Fprintf(&html, `<li class="mb-3"><a class="concepte" href="/concepte/%s">%s</a></li>`,
-			getConceptSlug(concept),
+		fmt.Fprintf(&html, `<li class="mb-3"><a class="concepte" href="%s">%s</a></li>`,
+			getConceptPath(concept),
 			getConceptTitleHTML(concept),
 		)
 	}
@@ -493,10 +1869,39 @@ func isNumberedItem(word string) bool {
 	return err == nil
 }
 
+// sortConceptEntries sorts the entries of a concept page by accepció, antònim, and phrase.
+func sortConceptEntries(entries []Entry) {
+	collator := collate.New(language.Catalan)
+	slices.SortFunc(entries, func(a, b Entry) int {
+		// 1) Compare by the numbered meaning from the concept.
+		comparison := collator.CompareString(a.AccepcioConcepte, b.AccepcioConcepte)
+		if comparison != 0 {
+			return comparison
+		}
+
+		// 2) Put antonyms at the end.
+		if a.AntonimConcepte != b.AntonimConcepte {
+			if a.AntonimConcepte {
+				return 1
+			}
+			return -1
+		}
+
+		// 3) Compare by phrase without parentheses content.
+		return collator.CompareString(a.TitleNormalizedWpc, b.TitleNormalizedWpc)
+	})
+}
+
 // renderEntriesForConceptPage renders entries for a concept page, grouping them by "accepció".
-func renderEntriesForConceptPage(entries []Entry) string {
+// Each entry has an anchor derived from its phrase slug. The entry whose phrase slug
+// matches highlightedPhraseSlug, if any, is rendered with a highlighted style.
+func renderEntriesForConceptPage(entries []Entry, highlightedPhraseSlug string) string {
 	var htmlOutput strings.Builder
 	var lastAccepcio string
+	// The slug of the first entry rendered for each TitleNormalizedWpc, and the HTML of
+	// the first entry rendered for each phrase slug, which owns its anchor.
+	firstPhraseSlugs := make(map[string]string)
+	renderedEntries := make(map[string]string)
 
 	for _, entry := range entries {
 		if entry.AccepcioConcepte != "" && entry.AccepcioConcepte != lastAccepcio {
@@ -506,23 +1911,92 @@ func renderEntriesForConceptPage(entries []Entry) string {
 			htmlOutput.WriteString(getAccepcio(entry.AccepcioConcepte))
 			lastAccepcio = entry.AccepcioConcepte
 		}
-		htmlOutput.WriteString(`<article class="entry frase">`)
-		htmlOutput.WriteString(renderSingleEntry(entry))
-		htmlOutput.WriteString(`</article>`)
+
+		phraseSlug := getPhraseSlug(entry)
+		isHighlighted := highlightedPhraseSlug != "" && phraseSlug == highlightedPhraseSlug
+		firstPhraseSlug, isRepeated := firstPhraseSlugs[entry.TitleNormalizedWpc]
+		if !isRepeated {
+			firstPhraseSlugs[entry.TitleNormalizedWpc] = phraseSlug
+			renderedEntries[phraseSlug] = renderSingleEntry(entry)
+			htmlOutput.WriteString(renderPhraseArticle(entry, isHighlighted))
+			continue
+		}
+
+		entryHTML := renderSingleEntry(entry)
+		previousHTML, hasAnchor := renderedEntries[phraseSlug]
+		if hasAnchor && entryHTML == previousHTML {
+			htmlOutput.WriteString(renderCollapsedPhrase(entry, phraseSlug))
+			continue
+		}
+		if hasAnchor {
+			// The anchor is already used, so that ids stay unique.
+			phraseSlug = ""
+			isHighlighted = false
+		} else {
+			renderedEntries[phraseSlug] = entryHTML
+		}
+		htmlOutput.WriteString(renderRepeatedPhrase(entryHTML, phraseSlug, firstPhraseSlug, isHighlighted))
 	}
 
 	return htmlOutput.String()
 }
 
-// renderEntriesForSearch renders entries for a search results page, including the concept title for each.
-func renderEntriesForSearch(entries []Entry) string {
-	var htmlOutput strings.Builder
+// renderCollapsedPhrase renders a phrase whose identical entry has already been
+// rendered under a previous accepcio of the same concept, as a link to it.
+func renderCollapsedPhrase(entry Entry, phraseSlug string) string {
+	return fmt.Sprintf(`<article class="entry frase repetida"><p>%s: vegeu-la en una accepció anterior (<a href="#%s">més amunt</a>).</p></article>`,
+		getPhrase(entry.Title),
+		url.PathEscape(phraseSlug),
+	)
+}
+
+// renderRepeatedPhrase renders an entry whose phrase has already been rendered, with a
+// different entry, under a previous accepcio of the same concept. The entry is rendered
+// with a note pointing back to the first occurrence, at firstPhraseSlug. It has an
+// anchor only if phraseSlug is not empty.
+func renderRepeatedPhrase(entryHTML, phraseSlug, firstPhraseSlug string, isHighlighted bool) string {
+	articleClass := "entry frase repetida"
+	if isHighlighted {
+		articleClass += " destacada"
+	}
+	var anchor string
+	if phraseSlug != "" {
+		anchor = fmt.Sprintf(` id="%s"`, phraseSlug)
+	}
+	return fmt.Sprintf(`<article class="%s"%s>%s<p class="nota">Aquesta frase també apareix en una accepció anterior (<a href="#%s">més amunt</a>).</p></article>`,
+		articleClass,
+		anchor,
+		entryHTML,
+		url.PathEscape(firstPhraseSlug),
+	)
+}
+
+// renderPhraseArticle renders a single entry of a concept, with an anchor derived
+// from its phrase slug, as shown on concept pages and in grouped search results.
+func renderPhraseArticle(entry Entry, isHighlighted bool) string {
+	articleClass := "entry frase"
+	if isHighlighted {
+		articleClass += " destacada"
+	}
+	return fmt.Sprintf(`<article class="%s" id="%s">%s</article>`,
+		articleClass,
+		getPhraseSlug(entry),
+		renderSingleEntry(entry),
+	)
+}
+
+// renderEntriesForSearch renders entries for a search results page, including the concept title for each.
+func renderEntriesForSearch(entries []Entry) string {
+	var htmlOutput strings.Builder
 
 	for _, entry := range entries {
 		htmlOutput.WriteString(`<article class="entry frase">`)
-		fmt.Fprintf(&htmlOutput, `<h2 class="concepte"><a href="/concepte/%s">%s</a></h2>`,
-			getConceptSlug(entry.Concepte),
-			getConceptTitleHTML(entry.Concepte),
+		phraseSlug := getPhraseSlug(entry)
+		fmt.Fprintf(&htmlOutput, `<h2 class="concepte"><a href="%s?destaca=%s#%s">%s</a></h2>`,
+			getConceptPath(entry.Concepte),
+			url.QueryEscape(phraseSlug),
+			url.PathEscape(phraseSlug),
+			getConceptTitleHTML(getRepresentativeConcept(entry.Concepte)),
 		)
 		htmlOutput.WriteString(renderSingleEntry(entry))
 		htmlOutput.WriteString(`</article>`)
@@ -531,71 +2005,346 @@ func renderEntriesForSearch(entries []Entry) string {
 	return htmlOutput.String()
 }
 
+// renderEntryPlainText renders an entry as plain text, for the command line. The
+// fields are shown as in the export, without HTML tags and without abbreviations
+// expanded.
+func renderEntryPlainText(entry Entry) string {
+	var text strings.Builder
+
+	concept := entry.Concepte
+	if entry.AccepcioConcepte != "" {
+		concept += " (" + entry.AccepcioConcepte + ")"
+	}
+	if entry.AntonimConcepte {
+		concept += " [ANT]"
+	}
+	fmt.Fprintf(&text, "%s [%s] — %s\n", entry.Title, entry.Categoria, concept)
+	fmt.Fprintf(&text, "  %s\n", stripHTML(entry.Definicio))
+	for _, field := range []struct{ label, value string }{
+		{"Exemples", entry.Exemples},
+		{"Sinònims", entry.Sinonims},
+		{"Altres relacions", entry.AltresRelacions},
+		{"Variants dialectals", entry.VariantsDialectals},
+		{"Observacions", entry.Observacions},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&text, "  %s: %s\n", field.label, stripHTML(field.value))
+		}
+	}
+
+	return text.String()
+}
+
+// renderLetterPlainText renders all the concepts of a letter and their entries as plain
+// text, for printing or studying offline. Concepts are in the order of the letter page,
+// and their entries in the order of the concept page. See renderEntryPlainText.
+func renderLetterPlainText(letter string) string {
+	concepts := ConceptsByFirstLetter[letter]
+	entriesBySlug := make(map[string][]Entry, len(concepts))
+	for _, concept := range concepts {
+		entriesBySlug[getConceptSlug(concept)] = nil
+	}
+	for _, entry := range AllEntries {
+		slug := getConceptSlug(entry.Concepte)
+		if _, listed := entriesBySlug[slug]; listed {
+			entriesBySlug[slug] = append(entriesBySlug[slug], entry)
+		}
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Diccionari de Sinònims de Frases Fetes — Lletra %s\n%s\n", letter, BaseCanonicalURL+"/lletra/"+letter)
+	for _, concept := range concepts {
+		entries := entriesBySlug[getConceptSlug(concept)]
+		sortConceptEntries(entries)
+
+		title := strings.ToUpper(getConceptTitle(concept))
+		fmt.Fprintf(&text, "\n%s\n%s\n\n", title, strings.Repeat("=", utf8.RuneCountInString(title)))
+		for _, entry := range entries {
+			text.WriteString(renderEntryPlainText(entry))
+			text.WriteString("\n")
+		}
+	}
+
+	return text.String()
+}
+
+// stripHTML removes the HTML tags of a field of the export, and unescapes its entities.
+func stripHTML(fieldHTML string) string {
+	return html.UnescapeString(htmlTagRegexp.ReplaceAllString(fieldHTML, ""))
+}
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// renderEntriesGroupedByConcept renders entries for a search results page, grouped by
+// concept, in the order in which each concept first appears. As on the concept page,
+// the concept title is shown once, followed by its phrases. Entries are grouped by
+// concept slug, so that spellings of a concept that differ in casing are shown together
+// under its representative spelling.
+func renderEntriesGroupedByConcept(entries []Entry) string {
+	var conceptSlugs []string
+	entriesByConceptSlug := make(map[string][]Entry)
+	for _, entry := range entries {
+		conceptSlug := getConceptSlug(entry.Concepte)
+		if _, exists := entriesByConceptSlug[conceptSlug]; !exists {
+			conceptSlugs = append(conceptSlugs, conceptSlug)
+		}
+		entriesByConceptSlug[conceptSlug] = append(entriesByConceptSlug[conceptSlug], entry)
+	}
+
+	var htmlOutput strings.Builder
+	for _, conceptSlug := range conceptSlugs {
+		conceptEntries := entriesByConceptSlug[conceptSlug]
+		concept := getRepresentativeConcept(conceptEntries[0].Concepte)
+		htmlOutput.WriteString(`<section class="entry concepte">`)
+		fmt.Fprintf(&htmlOutput, `<h2 class="concepte"><a href="%s">%s</a></h2>`,
+			getConceptPath(concept),
+			getConceptTitleHTML(concept),
+		)
+		for _, entry := range conceptEntries {
+			htmlOutput.WriteString(renderPhraseArticle(entry, false))
+		}
+		htmlOutput.WriteString(`</section>`)
+	}
+
+	return htmlOutput.String()
+}
+
 // renderSingleEntry renders the HTML for a single dictionary entry.
 func renderSingleEntry(entry Entry) string {
 	var htmlOutput strings.Builder
+	rendered := renderEntryFields(entry)
 
 	if entry.AntonimConcepte {
 		htmlOutput.WriteString(`<div><abbr title="valor antònim del concepte">ANT</abbr></div>`)
 	}
 
-	var phraseHTML string
-	if entry.NovaIncorporacio {
-		phraseHTML = getNewIncorporationPhrase(entry.Title)
-	} else {
-		phraseHTML = getPhrase(entry.Title)
-	}
-
 	fmt.Fprintf(&htmlOutput, `<p>%s %s, %s %s</p>`,
-		phraseHTML,
-		getCategory(entry.Categoria),
-		entry.Definicio,
-		getSources(entry.FontDefinicio),
+		rendered.Title,
+		rendered.Categoria,
+		rendered.Definicio,
+		rendered.FontDefinicio,
 	)
 
-	if entry.Exemples != "" {
-		fmt.Fprintf(&htmlOutput, "<p>%s %s</p>",
-			replaceAbbreviationsParentheses(entry.Exemples),
-			getSources(entry.FontExemples),
-		)
+	if len(splitExamples(entry.Exemples)) > 1 {
+		htmlOutput.WriteString(rendered.Exemples)
+		if entry.FontExemples != "" {
+			fmt.Fprintf(&htmlOutput, "<p>%s</p>", rendered.FontExemples)
+		}
+	} else if entry.Exemples != "" {
+		fmt.Fprintf(&htmlOutput, "<p>%s %s</p>", rendered.Exemples, rendered.FontExemples)
 	}
 	if entry.Sinonims != "" {
-		fmt.Fprintf(&htmlOutput, `<p><span class="simbol">→</span>%s</p>`,
-			replaceAbbreviationsParentheses(renderBoldPhrases(entry.Sinonims, true)),
-		)
+		fmt.Fprintf(&htmlOutput, `<p><span class="simbol">→</span>%s</p>`, rendered.Sinonims)
 	}
 	if entry.AltresRelacions != "" {
-		fmt.Fprintf(&htmlOutput, `<p><span class="simbol">▷</span>%s</p>`,
-			replaceAbbreviationsParentheses(renderBoldPhrases(entry.AltresRelacions, true)),
-		)
+		fmt.Fprintf(&htmlOutput, `<p><span class="simbol">▷</span>%s</p>`, rendered.AltresRelacions)
 	}
 	if entry.VariantsDialectals != "" {
-		fmt.Fprintf(&htmlOutput, `<p><span class="simbol simbol-punt">•</span>%s</p>`,
-			replaceAbbreviations(renderBoldPhrases(entry.VariantsDialectals, false)),
+		fmt.Fprintf(&htmlOutput, `<p><span class="simbol simbol-punt">•</span>%s</p>`, rendered.VariantsDialectals)
+	}
+	if entry.MarcatgeDialectal != "" {
+		fmt.Fprintf(&htmlOutput, `<p>[%s]</p>`, rendered.MarcatgeDialectal)
+	}
+	if entry.Observacions != "" {
+		fmt.Fprintf(&htmlOutput, `<p>[%s]</p>`, rendered.Observacions)
+	}
+	if entry.Changed > 0 {
+		changed := time.Unix(entry.Changed, 0).UTC()
+		fmt.Fprintf(&htmlOutput, `<p class="actualitzacio small text-muted">Darrera actualització: <time datetime="%s">%s</time></p>`,
+			changed.Format(time.DateOnly),
+			formatDateCatalan(changed),
 		)
 	}
+
+	return htmlOutput.String()
+}
+
+// renderEntryFields returns a copy of an entry with each of its displayed fields
+// rendered as HTML, as in renderSingleEntry: phrases in bold and linked, and
+// abbreviations and sources expanded. The phrase is taken from TitleHTML if set.
+// Definicio is HTML already, and the concept and the normalized fields are left
+// unchanged.
+func renderEntryFields(entry Entry) Entry {
+	rendered := entry
+
+	rendered.Title = entry.TitleHTML
+	if rendered.Title == "" {
+		rendered.Title = getPhrase(entry.Title)
+	}
+	if entry.NovaIncorporacio {
+		rendered.Title = getNewIncorporationPhrase(rendered.Title)
+	}
+	rendered.Categoria = getCategory(entry.Categoria)
+	rendered.FontDefinicio = getSources(entry.FontDefinicio)
+	rendered.Exemples = renderExamples(entry.Exemples)
+	rendered.FontExemples = getSources(entry.FontExemples)
+	if entry.Sinonims != "" {
+		rendered.Sinonims = replaceAbbreviationsParentheses(renderBoldPhrases(entry.Sinonims, true))
+	}
+	if entry.AltresRelacions != "" {
+		rendered.AltresRelacions = replaceAbbreviationsParentheses(renderBoldPhrases(entry.AltresRelacions, true))
+	}
+	if entry.VariantsDialectals != "" {
+		rendered.VariantsDialectals = replaceAbbreviations(renderBoldPhrases(entry.VariantsDialectals, false))
+	}
 	if entry.MarcatgeDialectal != "" {
-		fmt.Fprintf(&htmlOutput, `<p>[%s]</p>`, replaceSourceAbbreviationsParentheses(replaceAbbreviations(entry.MarcatgeDialectal)))
+		rendered.MarcatgeDialectal = replaceSourceAbbreviationsParentheses(replaceAbbreviationsWholeWords(entry.MarcatgeDialectal))
 	}
 	if entry.Observacions != "" {
-		fmt.Fprintf(&htmlOutput, `<p>[%s]</p>`, replaceObservationsSourceAbbreviations(entry.Observacions))
+		rendered.Observacions = replaceObservationsSourceAbbreviations(entry.Observacions)
+	}
+
+	return rendered
+}
+
+// renderExamples renders the examples of an entry, with abbreviations expanded. Several
+// examples are rendered as a list, see splitExamples.
+func renderExamples(examples string) string {
+	exampleList := splitExamples(examples)
+	if len(exampleList) < 2 {
+		return replaceAbbreviationsParentheses(examples)
 	}
 
+	var htmlOutput strings.Builder
+	htmlOutput.WriteString(`<ul class="list-unstyled exemples">`)
+	for _, example := range exampleList {
+		fmt.Fprintf(&htmlOutput, "<li>%s</li>", replaceAbbreviationsParentheses(example))
+	}
+	htmlOutput.WriteString(`</ul>`)
 	return htmlOutput.String()
 }
 
+// splitExamples splits the examples of an entry on ExamplesSeparator, ignoring
+// separators inside parentheses, see smartSplit.
+// To avoid splitting an example that contains the separator, the examples are only
+// split if each part is a complete example on its own, i.e. starts in italics and
+// closes all its <em> tags.
+//
+// Postconditions:
+//   - Returns a single example, the whole field, if it cannot be split safely
+//   - Returns an empty slice if the field is empty
+func splitExamples(examples string) []string {
+	if examples == "" {
+		return []string{}
+	}
+	if ExamplesSeparator == "" || !strings.Contains(examples, ExamplesSeparator) {
+		return []string{examples}
+	}
+
+	parts := smartSplit(examples, ExamplesSeparator)
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "<em>") || strings.Count(part, "<em>") != strings.Count(part, "</em>") {
+			return []string{examples}
+		}
+	}
+	return parts
+}
+
+// getAPIEntries returns the entries for a response of the JSON API. They are returned
+// as in the export, unless the render query parameter is set to RenderHTML, in which
+// case their fields are rendered as in the HTML pages (see renderEntryFields), and the
+// whole entry is rendered in the html field, so that front-ends can show it as is.
+//
+// Postconditions:
+//   - Returns an empty slice rather than nil, so that it is encoded as a JSON array
+//   - The html field is left out unless the entries are rendered as HTML
+func getAPIEntries(r *http.Request, entries []Entry) []Entry {
+	apiEntries := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if r.URL.Query().Get("render") == RenderHTML {
+			entryHTML := renderSingleEntry(entry)
+			entry = renderEntryFields(entry)
+			entry.HTML = entryHTML
+		}
+		apiEntries = append(apiEntries, entry)
+	}
+	return apiEntries
+}
+
+// formatDateCatalan formats a date in Catalan, e.g. "7 d'abril de 2025".
+func formatDateCatalan(date time.Time) string {
+	months := []string{
+		"gener", "febrer", "març", "abril", "maig", "juny",
+		"juliol", "agost", "setembre", "octubre", "novembre", "desembre",
+	}
+	month := months[date.Month()-1]
+
+	// Use the apostrophe before months starting with a vowel.
+	preposition := "de "
+	if strings.ContainsRune("aeiou", rune(month[0])) {
+		preposition = "d'"
+	}
+
+	return fmt.Sprintf("%d %s%s de %d", date.Day(), preposition, month, date.Year())
+}
+
+// getHomographs returns the other senses of a concept that is a homograph, i.e. the
+// concepts with the same name but a different trailing number (e.g. "POR1" and "POR2"),
+// sorted using the Catalan locale.
+//
+// Postconditions:
+//   - Returns nil for concepts without a trailing number
+//   - The concept itself is not included
+func getHomographs(concept string) []string {
+	baseConcept := strings.TrimRight(concept, "0123456789")
+	if baseConcept == concept {
+		return nil
+	}
+
+	var homographs []string
+	for _, otherConcept := range ConceptsBySlug {
+		if otherConcept != concept && otherConcept != baseConcept && strings.TrimRight(otherConcept, "0123456789") == baseConcept {
+			homographs = append(homographs, otherConcept)
+		}
+	}
+	slices.SortFunc(homographs, collate.New(language.Catalan, collate.Numeric).CompareString)
+	return homographs
+}
+
+// renderHomographs renders links to the other senses of a homograph concept, for
+// navigating between them on the concept page.
+func renderHomographs(homographs []string) string {
+	var links []string
+	for _, homograph := range homographs {
+		links = append(links, fmt.Sprintf(`<a class="concepte" href="%s">%s</a>`,
+			getConceptPath(homograph),
+			getConceptTitleHTML(homograph),
+		))
+	}
+	return strings.Join(links, " · ")
+}
+
 // getConceptTitleHTML formats a concept title for HTML display by converting numbers to superscripts.
-// For example, "Concepte1" becomes "Concepte<sup>1</sup>".
+// For example, "Concepte1" becomes "Concepte<sup>1</sup>", and "Concepte12" becomes
+// "Concepte<sup>12</sup>". The concept is HTML-escaped and its whitespace collapsed, so
+// that long concept names with punctuation are displayed safely. The numbers are found
+// before escaping, so that the digits of character references such as "&#39;" are kept.
 func getConceptTitleHTML(concept string) string {
-	return regexp.MustCompile(`(\d)`).ReplaceAllString(concept, "<sup>$1</sup>")
+	concept = strings.Join(strings.Fields(concept), " ")
+	var title strings.Builder
+	end := 0
+	for _, match := range conceptNumberRegexp.FindAllStringSubmatchIndex(concept, -1) {
+		title.WriteString(html.EscapeString(concept[end:match[0]]))
+		title.WriteString("<sup>" + concept[match[2]:match[3]] + "</sup>")
+		end = match[1]
+	}
+	title.WriteString(html.EscapeString(concept[end:]))
+	return title.String()
 }
 
 // getConceptTitle formats a concept title for display in page titles.
 // It converts the title to lowercase and adds a space before any numbers.
+// For example, "Concepte12" and "Concepte 12" both become "concepte 12".
 func getConceptTitle(concept string) string {
-	return strings.ToLower(regexp.MustCompile(`(\d)`).ReplaceAllString(concept, " $1"))
+	concept = strings.Join(strings.Fields(concept), " ")
+	concept = conceptNumberRegexp.ReplaceAllString(concept, " $1")
+	return strings.ToLower(strings.TrimSpace(concept))
 }
 
+// conceptNumberRegexp matches the numbers that tell homograph concepts apart, with
+// the spaces before them, if any.
+var conceptNumberRegexp = regexp.MustCompile(`\s*(\d+)`)
+
 // getConceptSlug creates a URL-friendly slug from a concept title.
 // It converts the title to lowercase and replaces spaces with underscores.
 func getConceptSlug(concept string) string {
@@ -604,6 +2353,159 @@ func getConceptSlug(concept string) string {
 	return slug
 }
 
+// getSitemapURLs returns the absolute URLs of the pages to list in the sitemap: the
+// homepage, the letters that have concepts, the first page of the categories that have
+// phrases, and the concepts, each in a stable order. Search pages are not listed, as
+// they are not indexed.
+func getSitemapURLs() []string {
+	urls := []string{BaseCanonicalURL + "/"}
+	for _, letter := range slices.Sorted(maps.Keys(ConceptsByFirstLetter)) {
+		if len(ConceptsByFirstLetter[letter]) > 0 {
+			urls = append(urls, BaseCanonicalURL+"/lletra/"+letter)
+		}
+	}
+	for _, categoryKey := range slices.Sorted(maps.Keys(getCategoryNames())) {
+		if len(EntryIndicesByCategory[categoryKey]) > 0 {
+			urls = append(urls, BaseCanonicalURL+getCategoryPath(categoryKey, 1))
+		}
+	}
+	for _, conceptSlug := range slices.Sorted(maps.Keys(ConceptsBySlug)) {
+		urls = append(urls, BaseCanonicalURL+getConceptPathFromSlug(conceptSlug))
+	}
+	return urls
+}
+
+// getSitemapIndexURLs returns the absolute URLs of the pages of a sitemap of urlCount
+// URLs, split in pages of at most maxURLs, for a sitemap index. See sitemapHandler.
+func getSitemapIndexURLs(urlCount, maxURLs int) []string {
+	var sitemaps []string
+	for page := 1; page <= (urlCount+maxURLs-1)/maxURLs; page++ {
+		sitemaps = append(sitemaps, fmt.Sprintf("%s/sitemap.xml?pagina=%d", BaseCanonicalURL, page))
+	}
+	return sitemaps
+}
+
+// normalizeConceptSlug normalizes a concept slug from a URL, which may have been typed
+// or mangled by the client, e.g. "Cap+Gros " or "cap%20gros". It is lowercased, and
+// spaces, "+" signs, and underscores are trimmed and collapsed into single underscores,
+// as in getConceptSlug. The slug must already be percent-decoded, as by r.PathValue.
+func normalizeConceptSlug(conceptSlug string) string {
+	return getConceptSlug(strings.NewReplacer("+", " ", "_", " ").Replace(conceptSlug))
+}
+
+// getCategoryPath returns the path of a page of phrases of a grammatical category.
+// The first page has no pagina parameter, so that it has a single URL.
+func getCategoryPath(categoryKey string, page int) string {
+	path := "/categoria/" + url.PathEscape(categoryKey)
+	if page > 1 {
+		path += "?pagina=" + strconv.Itoa(page)
+	}
+	return path
+}
+
+// getCategoryCounts returns the number of entries of each category that match a
+// normalized search query, ignoring the category filter of the options, so that the
+// other categories can be offered too. See getCategoryFacets.
+func getCategoryCounts(normalizedQuery string, options SearchOptions) map[string]int {
+	options.Category = ""
+	return countEntriesByCategory(filterEntries(getSearchCandidates(normalizedQuery, options), normalizedQuery, options))
+}
+
+// countEntriesByCategory returns the number of entries of each category, by its key.
+func countEntriesByCategory(entries []Entry) map[string]int {
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		counts[entry.Categoria]++
+	}
+	return counts
+}
+
+// getCategoryFacets returns the links to filter a list of phrases by category, given
+// the number of phrases of each category, the key of the category currently filtered
+// by, and a function that returns the link for a category key, or for an empty key to
+// remove the filter. Category names are taken from getCategoryNames.
+//
+// Postconditions:
+//   - The first link removes the filter, with the count of all the phrases
+//   - Categories are sorted by count, largest first, and then by name
+//   - Returns nil if the phrases are of a single category and no filter is applied,
+//     as filtering would change nothing
+func getCategoryFacets(counts map[string]int, activeCategory string, getURL func(categoryKey string) string) []CategoryFacet {
+	if len(counts) < 2 && activeCategory == "" {
+		return nil
+	}
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	facets := []CategoryFacet{{Count: total, URL: getURL(""), IsActive: activeCategory == ""}}
+
+	categoryNames := getCategoryNames()
+	var categoryFacets []CategoryFacet
+	for categoryKey, count := range counts {
+		name := categoryNames[categoryKey]
+		if name == "" {
+			name = categoryKey
+		}
+		categoryFacets = append(categoryFacets, CategoryFacet{
+			Name:     name,
+			Count:    count,
+			URL:      getURL(categoryKey),
+			IsActive: categoryKey == activeCategory,
+		})
+	}
+	collator := collate.New(language.Catalan)
+	slices.SortFunc(categoryFacets, func(a, b CategoryFacet) int {
+		return cmp.Or(b.Count-a.Count, collator.CompareString(a.Name, b.Name))
+	})
+	return append(facets, categoryFacets...)
+}
+
+// getConceptPath returns the path of a concept page. All internal links to concept
+// pages must use this function, so that they follow the trailing slash policy set by
+// ConceptURLTrailingSlash.
+func getConceptPath(concept string) string {
+	return getConceptPathFromSlug(getConceptSlug(concept))
+}
+
+// getConceptPathFromSlug returns the path of a concept page from its slug, following
+// the trailing slash policy set by ConceptURLTrailingSlash.
+func getConceptPathFromSlug(conceptSlug string) string {
+	// Escape the slug, as concepts may contain characters such as "?" or "/".
+	path := "/concepte/" + url.PathEscape(conceptSlug)
+	if ConceptURLTrailingSlash {
+		path += "/"
+	}
+	return path
+}
+
+// redirectToCanonicalConceptPath redirects requests for a concept page that do not
+// follow the trailing slash policy, or whose slug is not normalized, to the canonical
+// path, keeping the query string. See normalizeConceptSlug.
+// Returns true if the response has been written and the handler should stop.
+func redirectToCanonicalConceptPath(w http.ResponseWriter, r *http.Request) bool {
+	conceptSlug := normalizeConceptSlug(r.PathValue("concept"))
+	if strings.HasSuffix(r.URL.Path, "/") == ConceptURLTrailingSlash && conceptSlug == r.PathValue("concept") {
+		return false
+	}
+
+	redirectURL := getConceptPathFromSlug(conceptSlug)
+	if r.URL.RawQuery != "" {
+		redirectURL += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
+	return true
+}
+
+// getPhraseSlug creates a URL-friendly slug from the phrase of an entry, used as
+// its anchor on the concept page. It is based on the normalized phrase without
+// parentheses, so it is lowercase, without accents, and spaces are replaced with
+// underscores.
+func getPhraseSlug(entry Entry) string {
+	return strings.Join(strings.Fields(entry.TitleNormalizedWp), "_")
+}
+
 // removeParenthesesContent removes content inside parentheses and brackets from a string.
 // This is used to normalize phrases for searching and comparison.
 func removeParenthesesContent(input string) string {
@@ -627,20 +2529,30 @@ func removeParenthesesContent(input string) string {
 
 // toLowercaseNoAccents converts a string to lowercase and removes common Catalan accents.
 // This is used for case-insensitive and accent-insensitive string comparisons.
+//
+// The input is normalized to NFC first, so that accents typed or pasted as combining
+// characters (e.g. "a" followed by U+0300) are removed as their precomposed forms.
 func toLowercaseNoAccents(input string) string {
 	removeAccentsReplacer := strings.NewReplacer(
 		"à", "a", "è", "e", "é", "e", "í", "i", "ï", "i",
 		"ò", "o", "ó", "o", "ú", "u", "ü", "u",
 	)
-	return removeAccentsReplacer.Replace(strings.ToLower(input))
+	return removeAccentsReplacer.Replace(strings.ToLower(norm.NFC.String(input)))
 }
 
 // normalizeForSearch prepares a string for use as a search query.
 // It removes parentheses, normalizes some characters (e.g., "’" to "'"),
 // converts to lowercase, and removes accents.
 func normalizeForSearch(input string) string {
-	// TODO: ideally, we would also normalize Unicode here and in the database
-	// export (NFC). But this has not been necessary so far.
+	return toLowercaseNoAccents(normalizeForSearchKeepingAccents(input))
+}
+
+// normalizeForSearchKeepingAccents normalizes a string as normalizeForSearch does,
+// except that accents are kept. It is used to rank the results that match the accents
+// of the query, see SearchOptions.AccentedQuery.
+func normalizeForSearchKeepingAccents(input string) string {
+	// Queries may be typed with combining accents, so they are normalized to NFC
+	// below. The database export is assumed to be in NFC already.
 	normalizeSearchReplacer := strings.NewReplacer(
 		// Perform some UTF-8 normalizations
 		"’", "'",
@@ -654,54 +2566,406 @@ func normalizeForSearch(input string) string {
 	// Convert multiple spaces to single space
 	query = strings.Join(strings.Fields(query), " ")
 
-	// Trim, lowercase, and remove accents to match PHP export
+	// Trim and lowercase to match PHP export
 	query = strings.Trim(query, "-, ")
-	query = toLowercaseNoAccents(query)
+	query = strings.ToLower(norm.NFC.String(query))
 
 	return query
 }
 
-// getEntries retrieves a paginated list of dictionary entries that match a search query.
+// getSearchResultsPage runs a search and renders a page of its results.
+// If nothing is found, the search is retried with hyphens and spaces swapped, as
+// compound words are not always written consistently.
+//
+// Rendered pages are kept in SearchResultsCache, as popular queries repeat often, along
+// with the suggestion for searches without results, which is slow to find.
+// The cache key includes everything that affects the results, and is derived with
+// getCacheKey, so nothing is cached in development builds.
+func getSearchResultsPage(normalizedQuery string, options SearchOptions, groupByConcept bool, page, pageSize int) SearchResultsPage {
+	cacheKey := getCacheKey("search", normalizedQuery, options.Mode, options.Field,
+		strconv.FormatBool(options.ExcludeNew), strconv.FormatBool(options.OnlyWithExamples), options.Category,
+		strconv.FormatBool(options.ExportOrder), options.AccentedQuery,
+		strconv.FormatBool(groupByConcept), strconv.Itoa(page), strconv.Itoa(pageSize))
+	if cacheKey != "" {
+		cachedPage, found := SearchResultsCache.Get(cacheKey)
+		if found {
+			return cachedPage
+		}
+	}
+
+	var resultsPage SearchResultsPage
+	entries, total := getEntries(normalizedQuery, options, page, pageSize)
+	if total == 0 {
+		fallbackQuery := getHyphenFallbackQuery(normalizedQuery)
+		if fallbackQuery != "" {
+			entries, total = getEntries(fallbackQuery, options, page, pageSize)
+			if total > 0 {
+				resultsPage.FallbackQuery = fallbackQuery
+			}
+		}
+	}
+
+	resultsPage.Total = total
+	if total == 0 {
+		resultsPage.DidYouMean, resultsPage.DidYouMeanConcept = getDidYouMean(normalizedQuery)
+	}
+	highlightedQuery := normalizedQuery
+	if resultsPage.FallbackQuery != "" {
+		highlightedQuery = resultsPage.FallbackQuery
+	}
+	resultsPage.CategoryCounts = getCategoryCounts(highlightedQuery, options)
+	titleRegex := newTitleHighlightRegexp(highlightedQuery, options.Mode)
+	if titleRegex != nil {
+		for i := range entries {
+			entries[i].TitleHTML = highlightPhraseMatches(getPhrase(entries[i].Title), titleRegex)
+		}
+	}
+	if options.Field == SearchFieldExemples || options.Field == SearchFieldDefinicio {
+		for i := range entries {
+			if options.Field == SearchFieldExemples {
+				entries[i].Exemples = highlightMatches(entries[i].Exemples, highlightedQuery)
+			} else {
+				entries[i].Definicio = highlightMatches(entries[i].Definicio, highlightedQuery)
+			}
+		}
+	}
+	if groupByConcept {
+		resultsPage.PhrasesHTML = renderEntriesGroupedByConcept(entries)
+	} else {
+		resultsPage.PhrasesHTML = renderEntriesForSearch(entries)
+	}
+
+	if cacheKey != "" {
+		SearchResultsCache.Set(cacheKey, resultsPage)
+	}
+	return resultsPage
+}
+
+// highlightMatches wraps the words of a field of the export that match a normalized
+// query, as in newEntryMatcher, with <mark> tags. HTML tags of the field are kept,
+// and matches that span several tags are not highlighted.
+func highlightMatches(fieldHTML, normalizedQuery string) string {
+	regex := newWholeWordsRegexp(normalizedQuery)
+
+	var output strings.Builder
+	lastEnd := 0
+	for _, tagLocation := range htmlTagRegexp.FindAllStringIndex(fieldHTML, -1) {
+		output.WriteString(highlightTextMatches(fieldHTML[lastEnd:tagLocation[0]], regex))
+		output.WriteString(fieldHTML[tagLocation[0]:tagLocation[1]])
+		lastEnd = tagLocation[1]
+	}
+	output.WriteString(highlightTextMatches(fieldHTML[lastEnd:], regex))
+	return output.String()
+}
+
+// newTitleHighlightRegexp returns a regular expression that matches the part of a
+// normalized phrase that a search matches, as in newPhraseMatcher, with the boundaries
+// of the match captured as groups 1 and 2, as in newWholeWordsRegexp.
+//
+// Postconditions:
+//   - Returns nil in SearchModeArrel and SearchModeAproximat, as their matches are not
+//     substrings of the phrase
+func newTitleHighlightRegexp(normalizedQuery, searchMode string) *regexp.Regexp {
+	quotedQuery := regexp.QuoteMeta(normalizedQuery)
+	switch searchMode {
+	case SearchModeArrel, SearchModeAproximat:
+		return nil
+	case SearchModeComencaPer:
+		return regexp.MustCompile(`^()` + quotedQuery + `()`)
+	case SearchModeAcabaEn:
+		return regexp.MustCompile(`()` + quotedQuery + `()$`)
+	case SearchModeCoincident:
+		return regexp.MustCompile(`^()` + quotedQuery + `()$`)
+	default: // "Conté"
+		return newWholeWordsRegexp(normalizedQuery)
+	}
+}
+
+// highlightPhraseMatches wraps the parts of a phrase rendered with getPhrase that match
+// a regexp of newTitleHighlightRegexp with <mark> tags. The text of the phrase is
+// normalized one character at a time, without its parentheses content, as in
+// TitleNormalizedWpc, so that matches can be mapped back to the original text. Tags
+// are kept, and a match that spans several tags is highlighted in several parts, so
+// that <mark> tags are always properly nested.
+//
+// Postconditions:
+//   - Parentheses content is never highlighted
+//   - Returns the phrase unchanged if a character does not normalize to exactly one
+func highlightPhraseMatches(phraseHTML string, regex *regexp.Regexp) string {
+	type runePosition struct{ segment, index int }
+
+	// Split the phrase into text segments, and tags in between.
+	var segments [][]rune
+	var tags []string
+	lastEnd := 0
+	for _, tagLocation := range htmlTagRegexp.FindAllStringIndex(phraseHTML, -1) {
+		segments = append(segments, []rune(phraseHTML[lastEnd:tagLocation[0]]))
+		tags = append(tags, phraseHTML[tagLocation[0]:tagLocation[1]])
+		lastEnd = tagLocation[1]
+	}
+	segments = append(segments, []rune(phraseHTML[lastEnd:]))
+
+	// Normalize the text outside parentheses, collapsing spaces.
+	var normalizedRunes []rune
+	var positions []runePosition
+	parenthesesDepth := 0
+	for segmentIndex, segment := range segments {
+		for i, r := range segment {
+			switch {
+			case r == '(':
+				parenthesesDepth++
+				continue
+			case r == ')':
+				parenthesesDepth = max(parenthesesDepth-1, 0)
+				continue
+			case parenthesesDepth > 0:
+				continue
+			case unicode.IsSpace(r):
+				if len(normalizedRunes) > 0 && normalizedRunes[len(normalizedRunes)-1] != ' ' {
+					normalizedRunes = append(normalizedRunes, ' ')
+					positions = append(positions, runePosition{segmentIndex, i})
+				}
+				continue
+			}
+			normalizedRune := []rune(normalizeForSearch(string(r)))
+			if len(normalizedRune) != 1 {
+				if r != '-' && r != ',' {
+					return phraseHTML
+				}
+				// Trimmed by normalizeForSearch only at the ends of the phrase.
+				normalizedRune = []rune{r}
+			}
+			normalizedRunes = append(normalizedRunes, normalizedRune[0])
+			positions = append(positions, runePosition{segmentIndex, i})
+		}
+	}
+	if len(normalizedRunes) > 0 && normalizedRunes[len(normalizedRunes)-1] == ' ' {
+		normalizedRunes = normalizedRunes[:len(normalizedRunes)-1]
+	}
+
+	// Find the characters to highlight.
+	highlighted := make([][]bool, len(segments))
+	for i, segment := range segments {
+		highlighted[i] = make([]bool, len(segment))
+	}
+	normalizedText := string(normalizedRunes)
+	for _, match := range regex.FindAllStringSubmatchIndex(normalizedText, -1) {
+		start := utf8.RuneCountInString(normalizedText[:match[3]])
+		end := utf8.RuneCountInString(normalizedText[:match[4]])
+		for _, position := range positions[start:end] {
+			highlighted[position.segment][position.index] = true
+		}
+	}
+
+	var output strings.Builder
+	for segmentIndex, segment := range segments {
+		isMarked := false
+		for i, r := range segment {
+			if highlighted[segmentIndex][i] != isMarked {
+				isMarked = !isMarked
+				if isMarked {
+					output.WriteString("<mark>")
+				} else {
+					output.WriteString("</mark>")
+				}
+			}
+			output.WriteRune(r)
+		}
+		if isMarked {
+			output.WriteString("</mark>")
+		}
+		if segmentIndex < len(tags) {
+			output.WriteString(tags[segmentIndex])
+		}
+	}
+	return output.String()
+}
+
+// highlightTextMatches wraps the parts of a text without HTML tags that match a
+// regexp of newWholeWordsRegexp with <mark> tags. The text is normalized one
+// character at a time, so that matches can be mapped back to the original text.
+//
+// Postconditions:
+//   - Returns the text unchanged if a character does not normalize to exactly one
+func highlightTextMatches(text string, regex *regexp.Regexp) string {
+	runes := []rune(text)
+	normalizedRunes := make([]rune, len(runes))
+	for i, r := range runes {
+		normalizedRune := []rune(normalizeForSearch(string(r)))
+		if len(normalizedRune) != 1 {
+			if unicode.IsSpace(r) || r == '(' || r == ')' || r == '-' || r == ',' {
+				// Removed by normalizeForSearch, but never part of a normalized word.
+				normalizedRunes[i] = r
+				continue
+			}
+			return text
+		}
+		normalizedRunes[i] = normalizedRune[0]
+	}
+
+	normalizedText := string(normalizedRunes)
+	var output strings.Builder
+	lastEnd := 0
+	for _, match := range regex.FindAllStringSubmatchIndex(normalizedText, -1) {
+		// The match includes the surrounding boundaries, captured as groups 1 and 2.
+		start := utf8.RuneCountInString(normalizedText[:match[3]])
+		end := utf8.RuneCountInString(normalizedText[:match[4]])
+		output.WriteString(string(runes[lastEnd:start]))
+		output.WriteString("<mark>" + string(runes[start:end]) + "</mark>")
+		lastEnd = end
+	}
+	output.WriteString(string(runes[lastEnd:]))
+	return output.String()
+}
+
+// getSuggestions returns up to MaxSuggestions phrases without parentheses content
+// starting with a normalized prefix, as in SearchModeComencaPer, sorted with the Catalan
+// collation rules. The phrases that start with the prefix are found by binary search
+// in PhraseSuggestions, and only the first MaxSuggestions by their precomputed
+// CollationRank are kept, so this is fast enough to run on every keystroke.
+//
+// Postconditions:
+//   - Each phrase is returned once
+//   - Returns an empty slice if the prefix is empty, too short (see isQueryTooShort),
+//     or nothing matches
+func getSuggestions(normalizedPrefix string) []string {
+	suggestions := []string{}
+	if normalizedPrefix == "" || isQueryTooShort(normalizedPrefix, SearchModeComencaPer) {
+		return suggestions
+	}
+
+	start, _ := slices.BinarySearchFunc(PhraseSuggestions, normalizedPrefix, func(suggestion PhraseSuggestion, prefix string) int {
+		return strings.Compare(suggestion.Normalized, prefix)
+	})
+	// The first phrases by collation so far, in order, with each phrase once.
+	first := make([]PhraseSuggestion, 0, MaxSuggestions+1)
+	for _, suggestion := range PhraseSuggestions[start:] {
+		if !strings.HasPrefix(suggestion.Normalized, normalizedPrefix) {
+			break
+		}
+		position, found := slices.BinarySearchFunc(first, suggestion.CollationRank, func(other PhraseSuggestion, rank int) int {
+			return cmp.Compare(other.CollationRank, rank)
+		})
+		if found || position >= MaxSuggestions {
+			continue
+		}
+		first = slices.Insert(first, position, suggestion)
+		first = first[:min(len(first), MaxSuggestions)]
+	}
+
+	for _, suggestion := range first {
+		suggestions = append(suggestions, suggestion.Title)
+	}
+	return suggestions
+}
+
+// getSearchOptions returns the search options given by the mode, camp, exclou, amb,
+// categoria, ordre, and accents query parameters of a request. The accented query is
+// taken from the frase query parameter.
+func getSearchOptions(r *http.Request) SearchOptions {
+	options := SearchOptions{
+		Mode:             r.URL.Query().Get("mode"),
+		Field:            r.URL.Query().Get("camp"),
+		ExcludeNew:       r.URL.Query().Get("exclou") == ExcludeNovetats,
+		OnlyWithExamples: r.URL.Query().Get("amb") == WithExemples,
+		Category:         r.URL.Query().Get("categoria"),
+		ExportOrder:      r.URL.Query().Get("ordre") == OrderExport,
+	}
+	if r.URL.Query().Get("accents") == AccentsExact {
+		options.AccentedQuery = normalizeForSearchKeepingAccents(r.URL.Query().Get("frase"))
+	}
+	return options
+}
+
+// getHyphenFallbackQuery returns the query to retry a search without results with.
+// Hyphens are replaced with spaces or, if the query has no hyphens, spaces are
+// replaced with hyphens. E.g. "fer-se" becomes "fer se", and "fer se" becomes "fer-se".
+// Returns an empty string if the query has neither hyphens nor spaces.
+func getHyphenFallbackQuery(normalizedQuery string) string {
+	if strings.Contains(normalizedQuery, "-") {
+		return strings.Join(strings.Fields(strings.ReplaceAll(normalizedQuery, "-", " ")), " ")
+	}
+	if strings.Contains(normalizedQuery, " ") {
+		return strings.ReplaceAll(normalizedQuery, " ", "-")
+	}
+	return ""
+}
+
+// getAllSearchResults retrieves all dictionary entries that match a search query, along
+// with the normalized form that matched and the position of each.
 // It supports different search modes (contains, starts with, ends with, exact match)
-// and sorts the results alphabetically.
+// and fields, and sorts the results alphabetically.
 //
 // Preconditions:
 //   - normalizedQuery must be non-empty
-//   - page must be >= 1
-//   - pageSize must be >= 1
 //
 // Postconditions:
-//   - Returns entries slice with length <= pageSize
-//   - Returns total count of matching entries
-//   - Results are sorted according to search mode and Catalan collation rules
+//   - Results are sorted according to search mode and Catalan collation rules, unless
+//     options.ExportOrder is set, in which case they keep the order of AllEntries
+//   - If options.AccentedQuery is set, results matching its accents come first,
+//     keeping their order otherwise
 //   - For default search mode, exact matches appear first
-func getEntries(normalizedQuery, searchMode string, page, pageSize int) ([]Entry, int) {
-	regex := regexp.MustCompile(fmt.Sprintf(`(^|[^\p{L}\p{M}])%s([^\p{L}\p{M}]|$)`, regexp.QuoteMeta(normalizedQuery)))
-
-	var results []Entry
-	for _, entry := range AllEntries {
-		var match bool
-		switch searchMode {
-		// Search in normalized phrases (both without parentheses content and
-		// without parentheses).
-		case SearchModeComencaPer:
-			match = strings.HasPrefix(entry.TitleNormalizedWpc, normalizedQuery) || strings.HasPrefix(entry.TitleNormalizedWp, normalizedQuery)
-		case SearchModeAcabaEn:
-			match = strings.HasSuffix(entry.TitleNormalizedWpc, normalizedQuery) || strings.HasSuffix(entry.TitleNormalizedWp, normalizedQuery)
-		case SearchModeCoincident:
-			match = entry.TitleNormalizedWpc == normalizedQuery || entry.TitleNormalizedWp == normalizedQuery
-		default: // "Conté"
-			match = regex.MatchString(entry.TitleNormalizedWpc) || (entry.TitleNormalizedWpc != entry.TitleNormalizedWp && regex.MatchString(entry.TitleNormalizedWp))
+//   - For SearchModeAproximat, the closest phrases appear first
+//   - Sorting is stable, so entries with the same phrase keep their export order
+//   - Positions are 1-based
+func getAllSearchResults(normalizedQuery string, options SearchOptions) []SearchResult {
+	results := filterSearchResults(getSearchCandidates(normalizedQuery, options), normalizedQuery, options)
+	if options.Mode == SearchModeAproximat {
+		for i, result := range results {
+			results[i].Distance = min(
+				getEditDistance(result.Entry.TitleNormalizedWpc, normalizedQuery),
+				getEditDistance(result.Entry.TitleNormalizedWp, normalizedQuery),
+			)
 		}
+	}
+	if !options.ExportOrder {
+		sortSearchResults(results, normalizedQuery, options.Mode)
+	}
+	if options.AccentedQuery != "" {
+		rankAccentedMatchesFirst(results, options)
+	}
+
+	for i := range results {
+		results[i].Position = i + 1
+	}
 
-		if match {
-			results = append(results, entry)
+	return results
+}
+
+// rankAccentedMatchesFirst moves the search results whose phrase matches the accented
+// query of the options, with the same accents, before the rest. The order is kept
+// otherwise. Stems have no accents, so nothing is moved in SearchModeArrel.
+func rankAccentedMatchesFirst(results []SearchResult, options SearchOptions) {
+	if options.Mode == SearchModeArrel {
+		return
+	}
+
+	matchesAccents := newPhraseMatcher(options.AccentedQuery, options.Mode)
+	var accentedMatches, otherResults []SearchResult
+	for _, result := range results {
+		if matchesAccents(result.Entry.TitleAccented) != MatchedFormNone {
+			accentedMatches = append(accentedMatches, result)
+		} else {
+			otherResults = append(otherResults, result)
 		}
 	}
+	copy(results, accentedMatches)
+	copy(results[len(accentedMatches):], otherResults)
+}
 
-	// Sort results by phrase
+// sortSearchResults sorts search results by phrase, using the Catalan collation rules.
+// For the default search mode, exact matches appear first, and for SearchModeAproximat,
+// the results with the smallest Distance. Sorting is stable.
+func sortSearchResults(results []SearchResult, normalizedQuery, searchMode string) {
 	collator := collate.New(language.Catalan)
-	slices.SortFunc(results, func(a, b Entry) int {
+	slices.SortStableFunc(results, func(resultA, resultB SearchResult) int {
+		a, b := resultA.Entry, resultB.Entry
+
+		// For approximate search mode, show the closest phrases at the top
+		if searchMode == SearchModeAproximat && resultA.Distance != resultB.Distance {
+			return resultA.Distance - resultB.Distance
+		}
+
 		// For default search mode, show exact matches at the top
 		if searchMode == "" || searchMode == SearchModeConte {
 			// Check if either entry is an exact match
@@ -727,41 +2991,725 @@ func getEntries(normalizedQuery, searchMode string, page, pageSize int) ([]Entry
 		// Sort alphabetically (without parentheses content)
 		return collator.CompareString(a.TitleNormalizedWpc, b.TitleNormalizedWpc)
 	})
+}
 
-	resultsCount := len(results)
-	if resultsCount == 0 {
-		return nil, resultsCount
+// getSearchResults retrieves a paginated list of dictionary entries that match a search query.
+// See getAllSearchResults.
+//
+// Preconditions:
+//   - normalizedQuery must be non-empty
+//   - page must be >= 1
+//   - pageSize must be >= 1
+//
+// Postconditions:
+//   - Returns results slice with length <= pageSize
+//   - Returns total count of matching entries
+func getSearchResults(normalizedQuery string, options SearchOptions, page, pageSize int) ([]SearchResult, int) {
+	results := getAllSearchResults(normalizedQuery, options)
+	return paginate(results, page, pageSize), len(results)
+}
+
+// findSearchResult finds the first result whose phrase matches the given one.
+// Returns false if the phrase is not among the results.
+func findSearchResult(results []SearchResult, phrase string) (SearchResult, bool) {
+	normalizedPhrase := normalizePhrase(phrase)
+	for _, result := range results {
+		if result.Entry.TitleNormalizedWp == normalizedPhrase.Wp {
+			return result, true
+		}
 	}
+	return SearchResult{}, false
+}
 
-	// Slice for pagination
+// getEntries retrieves a paginated list of dictionary entries that match a search query.
+// See getSearchResults.
+func getEntries(normalizedQuery string, options SearchOptions, page, pageSize int) ([]Entry, int) {
+	results, total := getSearchResults(normalizedQuery, options, page, pageSize)
+	return getResultEntries(results), total
+}
+
+// paginate returns the items in the given page, where pages are numbered from 1.
+//
+// Preconditions:
+//   - page must be >= 1
+//   - pageSize must be >= 1
+//
+// Postconditions:
+//   - Returns a slice with length <= pageSize
+//   - Returns nil if the page is out of range
+func paginate[T any](items []T, page, pageSize int) []T {
 	start := (page - 1) * pageSize
-	if start >= resultsCount {
-		// Page is out of range
-		return nil, resultsCount
+	if start >= len(items) {
+		return nil
+	}
+
+	end := min(start+pageSize, len(items))
+	return items[start:end]
+}
+
+// parsePositiveInt parses a positive integer, typically from a query parameter.
+// Returns 0 if the value is empty, invalid, or not positive.
+func parsePositiveInt(value string) int {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		return 0
+	}
+	return number
+}
+
+// EntryCSVColumns lists the columns of the CSV export, named as the fields of Entry in
+// the JSON export, in the same order. See getEntryCSVRecord.
+var EntryCSVColumns = []string{
+	"title", "title_normalized_wp", "title_normalized_wpc", "concepte", "antonim_concepte",
+	"accepcio_concepte", "nova_incorporacio", "categoria", "definicio", "font_definicio",
+	"exemples", "font_exemples", "sinonims", "altres_relacions", "variants_dialectals",
+	"marcatge_dialectal", "observacions", "changed",
+}
+
+// getEntryCSVRecord returns the fields of an entry as a row of the CSV export, in the
+// order of EntryCSVColumns. Fields are as in the export, with booleans written as
+// "true" or "false", and the changed timestamp left empty if unknown.
+func getEntryCSVRecord(entry Entry) []string {
+	changed := ""
+	if entry.Changed != 0 {
+		changed = strconv.FormatInt(entry.Changed, 10)
+	}
+	return []string{
+		entry.Title, entry.TitleNormalizedWp, entry.TitleNormalizedWpc, entry.Concepte, strconv.FormatBool(entry.AntonimConcepte),
+		entry.AccepcioConcepte, strconv.FormatBool(entry.NovaIncorporacio), entry.Categoria, entry.Definicio, entry.FontDefinicio,
+		entry.Exemples, entry.FontExemples, entry.Sinonims, entry.AltresRelacions, entry.VariantsDialectals,
+		entry.MarcatgeDialectal, entry.Observacions, changed,
+	}
+}
+
+// getExportEntries returns the entries to export for a request.
+// By default, all entries are returned. If the mida (page size) query parameter
+// is present, only the requested page is returned, using the pagina query parameter
+// (defaults to 1). Page sizes are capped at MaxExportPageSize.
+func getExportEntries(r *http.Request) []Entry {
+	pageSize := parsePositiveInt(r.URL.Query().Get("mida"))
+	if pageSize == 0 {
+		return AllEntries
 	}
 
-	end := min(start+pageSize, resultsCount)
+	page := max(parsePositiveInt(r.URL.Query().Get("pagina")), 1)
+	return paginate(AllEntries, page, min(pageSize, MaxExportPageSize))
+}
+
+// newPhraseMatcher returns a function that checks if a normalized phrase matches a
+// normalized search query, according to the search mode. Phrases are matched both
+// without parentheses content (wpc) and without parentheses (wp), or by their stems
+// in SearchModeArrel. In SearchModeAproximat, phrases match if they are within
+// getMaxEditDistance of the query, to tolerate typos. The function returns the form
+// that matched, or MatchedFormNone.
+func newPhraseMatcher(normalizedQuery, searchMode string) func(NormalizedPhrase) MatchedForm {
+	if searchMode == SearchModeArrel {
+		regex := newWholeWordsRegexp(stemPhrase(normalizedQuery))
+		return func(phrase NormalizedPhrase) MatchedForm {
+			if regex.MatchString(phrase.Stemmed) {
+				return MatchedFormStemmed
+			}
+			return MatchedFormNone
+		}
+	}
+
+	var matches func(phrase string) bool
+	switch searchMode {
+	case SearchModeComencaPer:
+		matches = func(phrase string) bool {
+			return strings.HasPrefix(phrase, normalizedQuery)
+		}
+	case SearchModeAcabaEn:
+		matches = func(phrase string) bool {
+			return strings.HasSuffix(phrase, normalizedQuery)
+		}
+	case SearchModeCoincident:
+		matches = func(phrase string) bool {
+			return phrase == normalizedQuery
+		}
+	case SearchModeAproximat:
+		maxDistance := getMaxEditDistance(normalizedQuery)
+		matches = func(phrase string) bool {
+			return getEditDistance(phrase, normalizedQuery) <= maxDistance
+		}
+	default: // "Conté"
+		matches = newWholeWordsRegexp(normalizedQuery).MatchString
+	}
 
-	return results[start:end], resultsCount
+	return func(phrase NormalizedPhrase) MatchedForm {
+		if matches(phrase.Wpc) {
+			return MatchedFormWpc
+		}
+		if phrase.Wpc != phrase.Wp && matches(phrase.Wp) {
+			return MatchedFormWp
+		}
+		return MatchedFormNone
+	}
+}
+
+// getDidYouMean returns the phrase or concept closest to a normalized query without
+// results, by edit distance, to suggest it instead. If the suggestion is a concept, the
+// concept is returned too, to link to its page rather than to a search.
+//
+// Postconditions:
+//   - Returns empty strings if nothing is close enough, i.e. within getMaxEditDistance
+//     of the query and a quarter of its length, so that short queries do not get
+//     unrelated suggestions
+//   - Phrases are preferred to concepts at the same distance, and ties are broken by
+//     the normalized phrase or concept, so that the result is stable
+//   - Never suggests the query itself
+func getDidYouMean(normalizedQuery string) (string, string) {
+	queryLength := utf8.RuneCountInString(normalizedQuery)
+	maxDistance := min(getMaxEditDistance(normalizedQuery), max(queryLength/4, 1))
+
+	bestSuggestion, bestConcept := "", ""
+	bestDistance := maxDistance + 1
+	isCloser := func(candidate string) bool {
+		// The distance is at least the difference in length.
+		lengthDifference := utf8.RuneCountInString(candidate) - queryLength
+		if lengthDifference >= bestDistance || -lengthDifference >= bestDistance {
+			return false
+		}
+		distance := getEditDistance(candidate, normalizedQuery)
+		if distance == 0 || distance >= bestDistance {
+			return false
+		}
+		bestDistance = distance
+		return true
+	}
+
+	// PhraseSuggestions is sorted by the normalized phrase.
+	for _, suggestion := range PhraseSuggestions {
+		if isCloser(suggestion.Normalized) {
+			bestSuggestion = removeParenthesesContent(suggestion.Title)
+		}
+	}
+
+	// ConceptSuggestions is sorted by the normalized title.
+	for _, suggestion := range ConceptSuggestions {
+		if isCloser(suggestion.Normalized) {
+			bestSuggestion = getConceptTitle(suggestion.Concept)
+			bestConcept = suggestion.Concept
+		}
+	}
+
+	return bestSuggestion, bestConcept
+}
+
+// getMaxEditDistance returns the maximum edit distance between a phrase and a normalized
+// query for the phrase to match in SearchModeAproximat. Longer queries tolerate more typos.
+func getMaxEditDistance(normalizedQuery string) int {
+	if utf8.RuneCountInString(normalizedQuery) < 12 {
+		return 2
+	}
+	return 3
+}
+
+// getEditDistance returns the Levenshtein distance between two strings, counting
+// characters rather than bytes: the minimum number of insertions, deletions, and
+// substitutions to turn one into the other.
+func getEditDistance(a, b string) int {
+	runesA, runesB := []rune(a), []rune(b)
+
+	// Only two rows of the matrix are kept, as each depends on the previous one.
+	previousRow := make([]int, len(runesB)+1)
+	currentRow := make([]int, len(runesB)+1)
+	for j := range previousRow {
+		previousRow[j] = j
+	}
+	for i, runeA := range runesA {
+		currentRow[0] = i + 1
+		for j, runeB := range runesB {
+			substitutionCost := 1
+			if runeA == runeB {
+				substitutionCost = 0
+			}
+			currentRow[j+1] = min(previousRow[j+1]+1, currentRow[j]+1, previousRow[j]+substitutionCost)
+		}
+		previousRow, currentRow = currentRow, previousRow
+	}
+	return previousRow[len(runesB)]
+}
+
+// getSearchCandidates returns the entries that may match a normalized search query, in
+// the order of AllEntries, to be checked with newEntryMatcher, so that not all the entries
+// need to be scanned. In the default search mode, only phrases that contain all the words
+// of the query can match, so the candidates are looked up in EntryIndicesByWord. In
+// SearchModeComencaPer, they are looked up with getEntryIndicesWithPrefix.
+//
+// Postconditions:
+//   - Returns AllEntries in other search modes, when other fields than the phrase are
+//     searched, if the query has no words in the default search mode, e.g. it is a
+//     number, or if more than an eighth of the entries start with it in
+//     SearchModeComencaPer
+//   - Every entry that matches the query is among the candidates
+func getSearchCandidates(normalizedQuery string, options SearchOptions) []Entry {
+	if options.Field != "" {
+		return AllEntries
+	}
+
+	var indices []int
+	switch options.Mode {
+	case "", SearchModeConte:
+		words := getPhraseWords(normalizedQuery)
+		if len(words) == 0 {
+			return AllEntries
+		}
+
+		// Intersect the lists of entries of each word, starting with the shortest.
+		slices.SortFunc(words, func(a, b string) int {
+			return len(EntryIndicesByWord[a]) - len(EntryIndicesByWord[b])
+		})
+		indices = EntryIndicesByWord[words[0]]
+		for _, word := range words[1:] {
+			indices = intersectSortedInts(indices, EntryIndicesByWord[word])
+		}
+	case SearchModeComencaPer:
+		// Checking the start of a phrase is cheaper than copying its entry, so all the
+		// entries are scanned if many of them start with the query.
+		indices = getEntryIndicesWithPrefix(normalizedQuery)
+		if len(indices) > len(AllEntries)/8 {
+			return AllEntries
+		}
+	default:
+		return AllEntries
+	}
+
+	candidates := make([]Entry, 0, len(indices))
+	for _, index := range indices {
+		candidates = append(candidates, AllEntries[index])
+	}
+	return candidates
+}
+
+// getEntryIndicesWithPrefix returns the indices, in ascending order, of the entries whose
+// normalized phrase starts with a prefix, in either form. The phrases that start with it
+// are contiguous in PhrasesByNormalized, so they are found by binary search.
+func getEntryIndicesWithPrefix(normalizedPrefix string) []int {
+	start, _ := slices.BinarySearchFunc(PhrasesByNormalized, normalizedPrefix, func(phrase IndexedPhrase, prefix string) int {
+		return strings.Compare(phrase.Normalized, prefix)
+	})
+
+	var indices []int
+	for _, phrase := range PhrasesByNormalized[start:] {
+		if !strings.HasPrefix(phrase.Normalized, normalizedPrefix) {
+			break
+		}
+		indices = append(indices, phrase.Index)
+	}
+	slices.Sort(indices)
+	return slices.Compact(indices)
+}
+
+// getPhraseWords returns the distinct words of some normalized phrases, in order of
+// appearance. Words are sequences of letters and marks, as delimited by newWholeWordsRegexp.
+func getPhraseWords(normalizedPhrases ...string) []string {
+	var words []string
+	for _, phrase := range normalizedPhrases {
+		for _, word := range phraseWordRegexp.FindAllString(phrase, -1) {
+			if !slices.Contains(words, word) {
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+var phraseWordRegexp = regexp.MustCompile(`[\p{L}\p{M}]+`)
+
+// intersectSortedInts returns the numbers that are in both ascending lists, in ascending order.
+func intersectSortedInts(a, b []int) []int {
+	var intersection []int
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			intersection = append(intersection, a[i])
+			i++
+			j++
+		}
+	}
+	return intersection
+}
+
+// newWholeWordsRegexp returns a regular expression that matches a text containing
+// the given words, not as part of longer words. Regexps are compiled once and kept in
+// WholeWordsRegexpCache, as they are safe for concurrent use.
+func newWholeWordsRegexp(words string) *regexp.Regexp {
+	regex, found := WholeWordsRegexpCache.Get(words)
+	if found {
+		return regex
+	}
+
+	regex = regexp.MustCompile(fmt.Sprintf(`(^|[^\p{L}\p{M}])%s([^\p{L}\p{M}]|$)`, regexp.QuoteMeta(words)))
+	WholeWordsRegexpCache.Set(words, regex)
+	return regex
+}
+
+// hasExamples returns whether an entry has usage examples. Examples that contain only
+// markup or whitespace do not count.
+func hasExamples(entry Entry) bool {
+	return entry.ExemplesNormalized != ""
+}
+
+// newEntryMatcher returns a function that checks if an entry matches a normalized
+// search query, according to the search options. The phrase of the entry is always
+// searched. Optionally, its synonyms and related phrases, its examples, or its
+// definition are searched too. Examples and definitions are searched for the query
+// as whole words, whatever the search mode, as the modes only make sense for
+// phrases. New incorporations never match if options.ExcludeNew is set, nor phrases
+// without examples if options.OnlyWithExamples is set, nor phrases of other categories
+// if options.Category is set. The function returns the form that matched, or
+// MatchedFormNone.
+func newEntryMatcher(normalizedQuery string, options SearchOptions) func(Entry) MatchedForm {
+	matchesPhrase := newPhraseMatcher(normalizedQuery, options.Mode)
+	var textRegex *regexp.Regexp
+	if options.Field == SearchFieldExemples || options.Field == SearchFieldDefinicio {
+		textRegex = newWholeWordsRegexp(normalizedQuery)
+	}
+
+	return func(entry Entry) MatchedForm {
+		if options.ExcludeNew && entry.NovaIncorporacio {
+			return MatchedFormNone
+		}
+		if options.OnlyWithExamples && !hasExamples(entry) {
+			return MatchedFormNone
+		}
+		if options.Category != "" && entry.Categoria != options.Category {
+			return MatchedFormNone
+		}
+
+		matchedForm := matchesPhrase(NormalizedPhrase{
+			Wpc:     entry.TitleNormalizedWpc,
+			Wp:      entry.TitleNormalizedWp,
+			Stemmed: entry.TitleStemmed,
+		})
+		if matchedForm != MatchedFormNone {
+			return matchedForm
+		}
+
+		if options.Field == SearchFieldSinonims {
+			for _, relatedPhrase := range entry.RelatedPhrasesNormalized {
+				if matchesPhrase(relatedPhrase) != MatchedFormNone {
+					return MatchedFormRelated
+				}
+			}
+		}
+
+		if options.Field == SearchFieldExemples && textRegex.MatchString(entry.ExemplesNormalized) {
+			return MatchedFormExample
+		}
+		if options.Field == SearchFieldDefinicio && textRegex.MatchString(entry.DefinicioNormalized) {
+			return MatchedFormDefinition
+		}
+
+		return MatchedFormNone
+	}
+}
+
+// filterSearchResults returns the entries that match a normalized search query,
+// keeping their original order, along with the form that matched for each.
+//
+// Lists of at least ParallelSearchThreshold entries, e.g. all the entries in search
+// modes without an index (see getSearchCandidates), are split into one shard per CPU
+// that Go may use (GOMAXPROCS), which are scanned in parallel. Their results are
+// concatenated in the order of the shards, so the results are the same as scanning the
+// list at once. Shorter lists are scanned at once, as starting goroutines would cost
+// more than it saves.
+func filterSearchResults(entries []Entry, normalizedQuery string, options SearchOptions) []SearchResult {
+	matches := newEntryMatcher(normalizedQuery, options)
+
+	shardCount := runtime.GOMAXPROCS(0)
+	if len(entries) < ParallelSearchThreshold || shardCount < 2 {
+		return matchEntries(entries, matches)
+	}
+
+	shardSize := (len(entries) + shardCount - 1) / shardCount
+	shardResults := make([][]SearchResult, shardCount)
+	var waitGroup sync.WaitGroup
+	for i := range shardCount {
+		start := min(i*shardSize, len(entries))
+		end := min(start+shardSize, len(entries))
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			shardResults[i] = matchEntries(entries[start:end], matches)
+		}()
+	}
+	waitGroup.Wait()
+	return slices.Concat(shardResults...)
+}
+
+// matchEntries returns the entries that a function of newEntryMatcher matches, keeping
+// their original order, along with the form that matched for each.
+func matchEntries(entries []Entry, matches func(Entry) MatchedForm) []SearchResult {
+	var results []SearchResult
+	for _, entry := range entries {
+		matchedForm := matches(entry)
+		if matchedForm != MatchedFormNone {
+			results = append(results, SearchResult{Entry: entry, MatchedForm: matchedForm})
+		}
+	}
+	return results
+}
+
+// filterEntries returns the entries that match a normalized search query,
+// keeping their original order.
+func filterEntries(entries []Entry, normalizedQuery string, options SearchOptions) []Entry {
+	return getResultEntries(filterSearchResults(entries, normalizedQuery, options))
+}
+
+// getResultEntries returns the entries of a list of search results.
+func getResultEntries(results []SearchResult) []Entry {
+	var entries []Entry
+	for _, result := range results {
+		entries = append(entries, result.Entry)
+	}
+	return entries
+}
+
+// normalizePhrase normalizes a phrase for searching, in the same forms as the
+// Entry.TitleNormalizedWpc and Entry.TitleNormalizedWp fields of the export.
+func normalizePhrase(phrase string) NormalizedPhrase {
+	wpc := normalizeForSearch(removeParenthesesContent(phrase))
+	return NormalizedPhrase{
+		Wpc:     wpc,
+		Wp:      normalizeForSearch(phrase),
+		Stemmed: stemPhrase(wpc),
+	}
+}
+
+// Common endings of Catalan verbs and nouns, normalized (lowercase and without accents).
+// They are removed by stemWord, longest first.
+var StemEndings = sortByLengthDescending([]string{
+	// Infinitives
+	"ar", "er", "re", "ure", "ir",
+	// Gerunds and participles
+	"ant", "ent", "int", "at", "ada", "ats", "ades", "it", "ida", "its", "ides", "ut", "uda", "uts", "udes",
+	// Present, imperfect, future, and conditional tenses
+	"o", "es", "em", "eu", "en", "ic", "ava", "aves", "avem", "aveu", "aven",
+	"ia", "ies", "iem", "ieu", "ien", "are", "aras", "ara", "arem", "areu", "aran", "aria", "aries", "arien",
+	// Subjunctive
+	"i", "is", "in", "igui", "iguis", "iguin", "igut", "iguda",
+	// Nouns and adjectives
+	"a", "s", "os",
+})
+
+// MinStemLength is the minimum number of letters left by stemWord.
+const MinStemLength = 2
+
+// sortByLengthDescending sorts a list of strings from longest to shortest, in place.
+func sortByLengthDescending(list []string) []string {
+	slices.SortStableFunc(list, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	return list
+}
+
+// stemWord removes the longest common ending from a normalized word, leaving at
+// least MinStemLength letters. This is a rough approximation of its lemma, e.g.
+// "caure", "caic", and "caigut" become "ca".
+func stemWord(word string) string {
+	for _, ending := range StemEndings {
+		if strings.HasSuffix(word, ending) && utf8.RuneCountInString(word)-len(ending) >= MinStemLength {
+			return strings.TrimSuffix(word, ending)
+		}
+	}
+	return word
+}
+
+// stemPhrase stems every word of a normalized phrase, keeping everything else.
+func stemPhrase(normalizedPhrase string) string {
+	return wordRegexp.ReplaceAllStringFunc(normalizedPhrase, stemWord)
+}
+
+var wordRegexp = regexp.MustCompile(`\p{L}+`)
+
+// getSynonyms returns the synonyms of a phrase, listed in the Sinonims field of the
+// entries whose phrase matches it exactly. The Sinonims field is split with the same
+// rules as renderBoldPhrases.
+//
+// Postconditions:
+//   - Synonyms are de-duplicated, keeping the order of first appearance
+//   - Synonyms that exist in the dictionary have a URL to search for them
+//   - Returns nil if the phrase has no synonyms
+func getSynonyms(phrase string) []Synonym {
+	normalizedPhrase := normalizePhrase(phrase)
+	if normalizedPhrase.Wp == "" {
+		return nil
+	}
+
+	var synonyms []Synonym
+	seen := make(map[string]bool)
+	for _, entry := range AllEntries {
+		if entry.TitleNormalizedWpc != normalizedPhrase.Wpc && entry.TitleNormalizedWp != normalizedPhrase.Wp {
+			continue
+		}
+		for _, synonym := range splitPhrases(entry.Sinonims, PhrasesMap) {
+			if synonym == "" || seen[synonym] {
+				continue
+			}
+			seen[synonym] = true
+
+			result := Synonym{Phrase: synonym}
+			if phraseExists(synonym) {
+				result.URL = BaseCanonicalURL + getPhraseSearchPath(synonym)
+			}
+			synonyms = append(synonyms, result)
+		}
+	}
+	return synonyms
+}
+
+// getRepresentativeConcept returns the spelling of a concept that is shown for all its
+// entries, which may spell it with different casing or accents. See ConceptsBySlug.
+// Returns the concept unchanged if it is not in the dictionary.
+func getRepresentativeConcept(concept string) string {
+	representative, exists := ConceptsBySlug[getConceptSlug(concept)]
+	if !exists {
+		return concept
+	}
+	return representative
+}
+
+// compareConcepts returns the phrases shared by two concepts and those unique to each.
+// Phrases are compared by TitleNormalizedWpc, and returned as written in the first
+// entry with each normalized form, sorted alphabetically.
+//
+// Postconditions:
+//   - Each phrase is listed once, in exactly one of the lists
+//   - Lists are empty rather than nil, so that they are encoded as JSON arrays
+func compareConcepts(conceptA string, entriesA []Entry, conceptB string, entriesB []Entry) ConceptComparison {
+	getPhrasesByNormalizedForm := func(entries []Entry) map[string]string {
+		phrases := make(map[string]string)
+		for _, entry := range entries {
+			if _, exists := phrases[entry.TitleNormalizedWpc]; !exists {
+				phrases[entry.TitleNormalizedWpc] = entry.Title
+			}
+		}
+		return phrases
+	}
+	phrasesA := getPhrasesByNormalizedForm(entriesA)
+	phrasesB := getPhrasesByNormalizedForm(entriesB)
+
+	comparison := ConceptComparison{
+		ConceptA: conceptA,
+		ConceptB: conceptB,
+		Shared:   []string{},
+		OnlyA:    []string{},
+		OnlyB:    []string{},
+	}
+	for normalizedPhrase, phrase := range phrasesA {
+		if _, exists := phrasesB[normalizedPhrase]; exists {
+			comparison.Shared = append(comparison.Shared, phrase)
+		} else {
+			comparison.OnlyA = append(comparison.OnlyA, phrase)
+		}
+	}
+	for normalizedPhrase, phrase := range phrasesB {
+		if _, exists := phrasesA[normalizedPhrase]; !exists {
+			comparison.OnlyB = append(comparison.OnlyB, phrase)
+		}
+	}
+
+	collator := collate.New(language.Catalan)
+	for _, phrases := range [][]string{comparison.Shared, comparison.OnlyA, comparison.OnlyB} {
+		slices.SortFunc(phrases, collator.CompareString)
+	}
+	return comparison
+}
+
+// getRelatedConcepts returns the concepts that share phrases with a concept, given its
+// slug and entries, using ConceptSlugsByPhrase. Phrases are compared as in
+// compareConcepts, so the relation is symmetric.
+//
+// Postconditions:
+//   - The concept itself is not included
+//   - Sorted by the number of shared phrases, most first, then by slug
+//   - Returns at most MaxRelatedConcepts, and an empty slice rather than nil
+func getRelatedConcepts(conceptSlug string, entries []Entry) []RelatedConcept {
+	sharedPhraseCounts := make(map[string]int)
+	seenPhrases := make(map[string]bool)
+	for _, entry := range entries {
+		if seenPhrases[entry.TitleNormalizedWpc] {
+			continue
+		}
+		seenPhrases[entry.TitleNormalizedWpc] = true
+		for _, relatedSlug := range ConceptSlugsByPhrase[entry.TitleNormalizedWpc] {
+			if relatedSlug != conceptSlug {
+				sharedPhraseCounts[relatedSlug]++
+			}
+		}
+	}
+
+	related := make([]RelatedConcept, 0, len(sharedPhraseCounts))
+	for relatedSlug, count := range sharedPhraseCounts {
+		concept := ConceptsBySlug[relatedSlug]
+		related = append(related, RelatedConcept{
+			ConceptLink: ConceptLink{
+				Concept: concept,
+				Slug:    relatedSlug,
+				Path:    getConceptPathFromSlug(relatedSlug),
+			},
+			SharedPhrases: count,
+		})
+	}
+	slices.SortFunc(related, func(a, b RelatedConcept) int {
+		return cmp.Or(cmp.Compare(b.SharedPhrases, a.SharedPhrases), strings.Compare(a.Slug, b.Slug))
+	})
+	return related[:min(len(related), MaxRelatedConcepts)]
+}
+
+// getEntriesByCategory returns the entries of a grammatical category, sorted by concept
+// and then by phrase using the Catalan locale, so that they can be grouped by concept.
+// It uses EntryIndicesByCategory, so AllEntries is not scanned.
+func getEntriesByCategory(categoryKey string) []Entry {
+	indices := EntryIndicesByCategory[categoryKey]
+	entries := make([]Entry, 0, len(indices))
+	for _, index := range indices {
+		entries = append(entries, AllEntries[index])
+	}
+	return entries
 }
 
 // getEntriesByConceptSlug retrieves all dictionary entries for a given concept slug.
-// The slug is converted back to the original concept format for matching.
+// The slug of each concept is compared with the given one, so that any concept
+// name round-trips, even if it contains underscores or repeated spaces.
 //
 // Postconditions:
 //   - Returns all entries matching the concept (case-insensitive)
 //   - Returns empty slice if no matches found
-//   - Slug format: underscores converted to spaces for matching
 func getEntriesByConceptSlug(conceptSlug string) []Entry {
 	var records []Entry
 
-	// Normalize the incoming slug back (space separated)
-	conceptToMatch := strings.ReplaceAll(conceptSlug, "_", " ")
-
 	for _, entry := range AllEntries {
-		if strings.EqualFold(entry.Concepte, conceptToMatch) {
+		if strings.EqualFold(getConceptSlug(entry.Concepte), conceptSlug) {
 			records = append(records, entry)
 		}
 	}
 	return records
 }
+
+// parseTemplates parses the templates of the pages from TemplateFS into the global
+// template variables, e.g. MainTemplate. It panics if a template is invalid.
+// References to PageData fields are only checked when rendered, so MainTemplate is
+// checked against PageData by TestMainTemplateFields.
+func parseTemplates() {
+	MainTemplate = template.Must(template.New("main.html").
+		Funcs(template.FuncMap{"pluralize": pluralize, "pluralForm": pluralForm, "searchURL": searchURL, "categoryURL": getCategoryPath,
+			"assetURL": assetURL}).
+		ParseFS(TemplateFS, "templates/main.html"))
+	NotFoundTemplate = template.Must(template.New("404.html").ParseFS(TemplateFS, "templates/404.html"))
+	MaintenanceTemplate = template.Must(template.New("503.html").ParseFS(TemplateFS, "templates/503.html"))
+	GoneTemplate = template.Must(template.New("410.html").ParseFS(TemplateFS, "templates/410.html"))
+	OpenSearchTemplate = texttemplate.Must(texttemplate.New("opensearch.xml").
+		Funcs(texttemplate.FuncMap{"xml": escapeXML}).
+		ParseFS(TemplateFS, "templates/opensearch.xml"))
+	SitemapTemplate = texttemplate.Must(texttemplate.New("sitemap.xml").
+		Funcs(texttemplate.FuncMap{"xml": escapeXML}).
+		ParseFS(TemplateFS, "templates/sitemap.xml"))
+}
@@ -0,0 +1,2972 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"text/template/parse"
+	"time"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+func TestGetETagChangesWithBuildDate(t *testing.T) {
+	previousBuildDate, previousDataVersion := BuildDate, DataVersion
+	t.Cleanup(func() {
+		BuildDate, DataVersion = previousBuildDate, previousDataVersion
+	})
+	request := httptest.NewRequest(http.MethodGet, "/concepte/callar?pagina=2", nil)
+
+	BuildDate, DataVersion = "", "v1"
+	if etag := getETag(request); etag != "" {
+		t.Errorf("getETag() = %q without BuildDate, want no ETag", etag)
+	}
+
+	BuildDate = "2025-01-01"
+	firstETag := getETag(request)
+	if firstETag == "" {
+		t.Fatal("getETag() returned no ETag with BuildDate set")
+	}
+	if etag := getETag(request); etag != firstETag {
+		t.Errorf("getETag() = %q, then %q for the same request", firstETag, etag)
+	}
+
+	BuildDate = "2025-02-01"
+	if etag := getETag(request); etag == firstETag {
+		t.Errorf("getETag() = %q after changing BuildDate, want a different ETag", etag)
+	}
+
+	// Reloading the data invalidates cached pages, as a deploy does.
+	BuildDate, DataVersion = "2025-01-01", "v2"
+	if etag := getETag(request); etag == firstETag {
+		t.Errorf("getETag() = %q after changing DataVersion, want a different ETag", etag)
+	}
+
+	DataVersion = "v1"
+	otherRequest := httptest.NewRequest(http.MethodGet, "/concepte/callar", nil)
+	if etag := getETag(otherRequest); etag == firstETag {
+		t.Errorf("getETag() = %q for a different query, want a different ETag", etag)
+	}
+}
+
+func TestGetCacheKeySeparatesParts(t *testing.T) {
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+
+	BuildDate = "2025-01-01"
+	if getCacheKey("ab", "c") == getCacheKey("a", "bc") {
+		t.Error(`getCacheKey("ab", "c") == getCacheKey("a", "bc")`)
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2025-01-01"
+
+	request := httptest.NewRequest(http.MethodGet, "/lletra/A", nil)
+	etag := getETag(request)
+	request.Header.Set("If-None-Match", `"other", W/`+etag)
+	recorder := httptest.NewRecorder()
+	if !checkNotModified(recorder, request) {
+		t.Fatal("checkNotModified() = false for a matching If-None-Match")
+	}
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+
+	BuildDate = "2025-02-01"
+	recorder = httptest.NewRecorder()
+	if checkNotModified(recorder, request) {
+		t.Error("checkNotModified() = true for an ETag of a previous build")
+	}
+	if recorder.Header().Get("ETag") == etag {
+		t.Error("the ETag of the previous build was set")
+	}
+}
+
+func TestIsQueryTooShort(t *testing.T) {
+	previousMinQueryLength := MinQueryLength
+	t.Cleanup(func() {
+		MinQueryLength = previousMinQueryLength
+	})
+	MinQueryLength = 3
+
+	tests := []struct {
+		query string
+		mode  string
+		want  bool
+	}{
+		{query: "fe", mode: SearchModeConte, want: true},
+		{query: "fe", mode: SearchModeComencaPer, want: true},
+		{query: "fè", mode: SearchModeConte, want: true}, // Characters, not bytes.
+		{query: "fer", mode: SearchModeConte, want: false},
+		{query: "fer el", mode: SearchModeConte, want: false},
+		{query: "fe", mode: SearchModeCoincident, want: false},
+	}
+	for _, test := range tests {
+		got := isQueryTooShort(test.query, test.mode)
+		if got != test.want {
+			t.Errorf("isQueryTooShort(%q, %q) = %t, want %t", test.query, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestGetCanonicalURLMode(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "/?mode=Cont%C3%A9&frase=mort", want: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=mort"},
+		{target: "/?mode=Coincident&frase=mort", want: BaseCanonicalURL + "/?mode=Coincident&frase=mort"},
+		{target: "/?mode=&frase=mort", want: BaseCanonicalURL + "/?frase=mort"},
+		{target: "/?frase=mort", want: BaseCanonicalURL + "/?frase=mort"},
+		{target: "/?mode=xyz&frase=mort", want: BaseCanonicalURL + "/?frase=mort"},
+		{target: "/?mode=cont%C3%A9&frase=mort", want: BaseCanonicalURL + "/?frase=mort"},
+		{target: "/?mode=xyz", want: BaseCanonicalURL + "/"},
+		{target: "/?frase=mort&camp=sinonims", want: BaseCanonicalURL + "/?frase=mort&camp=sinonims"},
+		{target: "/?frase=mort&camp=exemples", want: BaseCanonicalURL + "/?frase=mort&camp=exemples"},
+		{target: "/?frase=mort&camp=definicio", want: BaseCanonicalURL + "/?frase=mort&camp=definicio"},
+		{target: "/?frase=mort&camp=xyz", want: BaseCanonicalURL + "/?frase=mort"},
+	}
+	for _, test := range tests {
+		got := getCanonicalURL(httptest.NewRequest(http.MethodGet, test.target, nil))
+		if got != test.want {
+			t.Errorf("getCanonicalURL(%s) = %q, want %q", test.target, got, test.want)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		page, pageSize int
+		want           []int
+	}{
+		{page: 1, pageSize: 2, want: []int{1, 2}},
+		{page: 3, pageSize: 2, want: []int{5}},
+		{page: 4, pageSize: 2, want: nil},
+		{page: 1, pageSize: 10, want: []int{1, 2, 3, 4, 5}},
+	}
+	for _, test := range tests {
+		got := paginate(items, test.page, test.pageSize)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("paginate(%v, %d, %d) = %v, want %v", items, test.page, test.pageSize, got, test.want)
+		}
+	}
+}
+
+// viewTestConcepts sets the cookie of recently viewed concepts as conceptHandler does for
+// each of the concepts in turn, starting with the cookie of the request, and returns a
+// request with the resulting cookie.
+func viewTestConcepts(request *http.Request, concepts ...string) *http.Request {
+	for _, concept := range concepts {
+		recorder := httptest.NewRecorder()
+		setRecentConceptsCookie(recorder, request, concept)
+		request = httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, cookie := range recorder.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+	}
+	return request
+}
+
+func TestRecentConceptsCookie(t *testing.T) {
+	loadTestData(t)
+	previousCookieSecret := CookieSecret
+	t.Cleanup(func() {
+		CookieSecret = previousCookieSecret
+	})
+	CookieSecret = []byte("test secret")
+
+	tests := []struct {
+		name     string
+		concepts []string
+		want     []string
+	}{
+		{name: "first", concepts: []string{"CALLAR"}, want: []string{"callar"}},
+		{name: "most recent first", concepts: []string{"CALLAR", "MORIR"}, want: []string{"morir", "callar"}},
+		{
+			name:     "viewed again moves to the front",
+			concepts: []string{"CALLAR", "MORIR", "CALLAR"},
+			want:     []string{"callar", "morir"},
+		},
+		{
+			name:     "capped",
+			concepts: []string{"ALLIBERAR", "CALLAR", "DESCANSAR", "ENGANYAR", "MORIR", "COMPTAR"},
+			want:     []string{"comptar", "morir", "enganyar", "descansar", "callar"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := viewTestConcepts(httptest.NewRequest(http.MethodGet, "/", nil), test.concepts...)
+			got := getRecentConceptSlugs(request)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("getRecentConceptSlugs() = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	concepts := getRecentConcepts(viewTestConcepts(httptest.NewRequest(http.MethodGet, "/", nil), "CALLAR"))
+	if !slices.Equal(concepts, []string{"CALLAR"}) {
+		t.Errorf("getRecentConcepts() = %q, want the concepts as written in the data", concepts)
+	}
+}
+
+func TestRecentConceptsCookieMalformed(t *testing.T) {
+	loadTestData(t)
+	previousCookieSecret := CookieSecret
+	t.Cleanup(func() {
+		CookieSecret = previousCookieSecret
+	})
+
+	CookieSecret = []byte("other secret")
+	signedWithOtherSecret := signCookieValue("callar")
+	CookieSecret = []byte("test secret")
+	validValue := signCookieValue("callar")
+	encodedValue, signature, _ := strings.Cut(validValue, ".")
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{name: "not signed", value: "callar"},
+		{name: "not base64", value: "!!!.???"},
+		{name: "empty", value: ""},
+		{name: "signed with another secret", value: signedWithOtherSecret},
+		{name: "tampered value", value: base64.RawURLEncoding.EncodeToString([]byte("morir")) + "." + signature},
+		{name: "truncated signature", value: encodedValue + "." + signature[:10]},
+		{name: "unknown concepts left out", value: signCookieValue("no_existeix\ncallar\n../morir"), want: []string{"callar"}},
+		{name: "duplicates left out", value: signCookieValue("callar\ncallar\nmorir"), want: []string{"callar", "morir"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.AddCookie(&http.Cookie{Name: RecentConceptsCookieName, Value: test.value})
+			got := getRecentConceptSlugs(request)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("getRecentConceptSlugs() = %q, want %q", got, test.want)
+			}
+
+			// Malformed cookies are replaced, rather than breaking the concept page.
+			request = viewTestConcepts(request, "MORIR")
+			got = getRecentConceptSlugs(request)
+			if len(got) == 0 || got[0] != "morir" {
+				t.Errorf("getRecentConceptSlugs() after viewing a concept = %q, want it first", got)
+			}
+		})
+	}
+}
+
+func TestGetEntriesSearchField(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		query   string
+		options SearchOptions
+		want    []string
+	}{
+		// "a les tres" is not a phrase of its own, only one of the synonyms of "a les dues, a les tres".
+		{query: "a les tres", options: SearchOptions{Mode: SearchModeCoincident}},
+		{
+			query:   "a les tres",
+			options: SearchOptions{Mode: SearchModeCoincident, Field: SearchFieldSinonims},
+			want:    []string{"a les dues, a les tres"},
+		},
+		{query: "vendre fum", options: SearchOptions{Mode: SearchModeCoincident}, want: []string{"vendre fum"}},
+		{
+			query:   "vendre fum",
+			options: SearchOptions{Mode: SearchModeCoincident, Field: SearchFieldSinonims},
+			want:    []string{"donar gat per llebre", "vendre fum"},
+		},
+		// The content of parentheses is optional in related phrases too.
+		{
+			query:   "rompre les cadenes d'algu",
+			options: SearchOptions{Mode: SearchModeCoincident, Field: SearchFieldSinonims},
+			want:    []string{"rompre el jou (d'algú)", "rompre les cadenes (d'algú)"},
+		},
+	}
+	for _, test := range tests {
+		entries, total := getEntries(test.query, test.options, 1, DefaultPageSize)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Title)
+		}
+		if !slices.Equal(got, test.want) || total != len(test.want) {
+			t.Errorf("getEntries(%q, %+v) = %q (total %d), want %q", test.query, test.options, got, total, test.want)
+		}
+	}
+}
+
+func TestGetSynonyms(t *testing.T) {
+	first := newTestEntry("PARLAR", "xerrar pels descosits")
+	first.Sinonims = "parlar (més) que una cotorra, no parar de parlar"
+	second := newTestEntry("PARLAR", "xerrar pels descosits (d'algú)")
+	second.Sinonims = "no parar de parlar; parlar pels colzes, sense aturador"
+	setTestEntries(t, []Entry{
+		first,
+		second,
+		newTestEntry("PARLAR", "parlar (més) que una cotorra"),
+		newTestEntry("PARLAR", "parlar pels colzes, sense aturador"),
+		newTestEntry("PARLAR", "no parar de parlar"),
+	})
+
+	// The synonyms of the first entry are separated with commas, and those of the second
+	// with semicolons, because one of them has a comma. Both share "no parar de parlar".
+	tests := []struct {
+		phrase string
+		want   []Synonym
+	}{
+		{phrase: "xerrar pels descosits", want: []Synonym{
+			{Phrase: "parlar (més) que una cotorra", URL: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=parlar+que+una+cotorra"},
+			{Phrase: "no parar de parlar", URL: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=no+parar+de+parlar"},
+			{Phrase: "parlar pels colzes, sense aturador", URL: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=parlar+pels+colzes%2C+sense+aturador"},
+		}},
+		// The content of parentheses is optional, so both entries match.
+		{phrase: "Xerrar pels descosits (d'algú)", want: []Synonym{
+			{Phrase: "parlar (més) que una cotorra", URL: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=parlar+que+una+cotorra"},
+			{Phrase: "no parar de parlar", URL: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=no+parar+de+parlar"},
+			{Phrase: "parlar pels colzes, sense aturador", URL: BaseCanonicalURL + "/?mode=Cont%C3%A9&frase=parlar+pels+colzes%2C+sense+aturador"},
+		}},
+		{phrase: "parlar que una cotorra"},
+		{phrase: "no parar de parlar"},
+		{phrase: ""},
+	}
+	for _, test := range tests {
+		got := getSynonyms(test.phrase)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("getSynonyms(%q) = %q, want %q", test.phrase, got, test.want)
+		}
+	}
+}
+
+// loadEmptyTestData loads testdata/empty.json, a data file with an empty array, as the
+// dictionary data.
+func loadEmptyTestData(t *testing.T) {
+	t.Helper()
+	content, err := os.ReadFile("testdata/empty.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadDataFromFile(writeGzippedTestFile(t, content))
+	if !errors.Is(err, ErrDataEmpty) {
+		t.Fatalf("loadDataFromFile() error = %v, want %v", err, ErrDataEmpty)
+	}
+}
+
+func TestLoadDataFromFileEmpty(t *testing.T) {
+	loadTestData(t)
+
+	loadEmptyTestData(t)
+	if len(AllEntries) != 0 || len(ConceptsByFirstLetter) != 0 {
+		t.Errorf("loaded %d entries and %d letters, want none", len(AllEntries), len(ConceptsByFirstLetter))
+	}
+	if isDataLoaded() {
+		t.Error("isDataLoaded() = true with no entries")
+	}
+}
+
+func TestFormatDateCatalan(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{date: time.Date(2025, time.April, 7, 0, 0, 0, 0, time.UTC), want: "7 d'abril de 2025"},
+		{date: time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC), want: "31 de gener de 2024"},
+		{date: time.Date(2024, time.August, 1, 0, 0, 0, 0, time.UTC), want: "1 d'agost de 2024"},
+		{date: time.Date(2024, time.October, 15, 0, 0, 0, 0, time.UTC), want: "15 d'octubre de 2024"},
+		{date: time.Date(2023, time.March, 2, 0, 0, 0, 0, time.UTC), want: "2 de març de 2023"},
+	}
+	for _, test := range tests {
+		got := formatDateCatalan(test.date)
+		if got != test.want {
+			t.Errorf("formatDateCatalan(%s) = %q, want %q", test.date.Format(time.DateOnly), got, test.want)
+		}
+	}
+}
+
+func TestRenderSingleEntryChanged(t *testing.T) {
+	loadTestData(t)
+	entry := newTestEntry("CALLAR", "fer el mort")
+
+	html := renderSingleEntry(entry)
+	if strings.Contains(html, "Darrera actualització") {
+		t.Errorf("renderSingleEntry() of an entry without a timestamp shows the last update:\n%s", html)
+	}
+
+	entry.Changed = time.Date(2025, time.April, 7, 10, 30, 0, 0, time.UTC).Unix()
+	html = renderSingleEntry(entry)
+	want := `Darrera actualització: <time datetime="2025-04-07">7 d'abril de 2025</time>`
+	if !strings.Contains(html, want) {
+		t.Errorf("renderSingleEntry() of an entry with a timestamp does not contain %q:\n%s", want, html)
+	}
+}
+
+func TestLoadDataFromFileErrors(t *testing.T) {
+	gzippedEntries := writeGzippedTestFile(t, TestEntries)
+	validGzip, err := os.ReadFile(gzippedEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filePath string
+		wantErr  error
+	}{
+		{name: "missing", filePath: filepath.Join(t.TempDir(), "missing.json.gz"), wantErr: ErrDataMissing},
+		{name: "bad plain JSON", filePath: writeTestFile(t, []byte("not json")), wantErr: ErrDataCorrupt},
+		{name: "truncated plain JSON", filePath: writeTestFile(t, TestEntries[:len(TestEntries)/2]), wantErr: ErrDataCorrupt},
+		{name: "bad gzip header", filePath: writeTestFile(t, []byte{0x1f, 0x8b, 0, 0}), wantErr: ErrDataCorrupt},
+		{name: "truncated gzip", filePath: writeTestFile(t, validGzip[:len(validGzip)/2]), wantErr: ErrDataCorrupt},
+		{name: "bad gzip checksum", filePath: writeTestFile(t, append(slices.Clone(validGzip[:len(validGzip)-8]), 0, 0, 0, 0, 0, 0, 0, 0)), wantErr: ErrDataCorrupt},
+		{name: "bad JSON", filePath: writeGzippedTestFile(t, []byte("not json")), wantErr: ErrDataCorrupt},
+		{name: "truncated JSON", filePath: writeGzippedTestFile(t, TestEntries[:len(TestEntries)/2]), wantErr: ErrDataCorrupt},
+		{name: "object instead of array", filePath: writeGzippedTestFile(t, []byte(`{"title": "fer el mort"}`)), wantErr: ErrDataCorrupt},
+		{name: "bad entry", filePath: writeGzippedTestFile(t, []byte(`[{"title": 1}]`)), wantErr: ErrDataCorrupt},
+		{name: "trailing comma", filePath: writeGzippedTestFile(t, []byte(`[{"title": "fer el mort"},]`)), wantErr: ErrDataCorrupt},
+		{name: "empty", filePath: writeGzippedTestFile(t, []byte("[]")), wantErr: ErrDataEmpty},
+		{name: "valid", filePath: gzippedEntries},
+		{name: "valid plain JSON", filePath: "testdata/entries.json"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loadTestData(t)
+			previousEntries := AllEntries
+
+			err := loadDataFromFile(test.filePath)
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("loadDataFromFile() error = %v, want %v", err, test.wantErr)
+			}
+			for _, otherErr := range []error{ErrDataMissing, ErrDataCorrupt, ErrDataEmpty} {
+				if otherErr != test.wantErr && errors.Is(err, otherErr) {
+					t.Errorf("loadDataFromFile() error = %v, also wraps %v", err, otherErr)
+				}
+			}
+
+			// The current data is kept on errors other than an empty file.
+			keepsData := test.wantErr == ErrDataMissing || test.wantErr == ErrDataCorrupt
+			if keepsData && len(AllEntries) != len(previousEntries) {
+				t.Errorf("loaded %d entries, want the %d entries loaded before", len(AllEntries), len(previousEntries))
+			}
+			if test.wantErr == nil && len(AllEntries) != len(previousEntries) {
+				t.Errorf("loaded %d entries, want %d", len(AllEntries), len(previousEntries))
+			}
+		})
+	}
+}
+
+func TestDecodeEntries(t *testing.T) {
+	var want []Entry
+	err := json.Unmarshal(TestEntries, &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := decodeEntries(bytes.NewReader(TestEntries))
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Errorf("decodeEntries() = %d entries, %v, want the %d entries of json.Unmarshal", len(entries), err, len(want))
+	}
+
+	entries, err = decodeEntries(strings.NewReader(" [ ] "))
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Errorf("decodeEntries() of an empty array = %#v, %v, want an empty slice", entries, err)
+	}
+
+	// Errors name the entry that failed.
+	_, err = decodeEntries(strings.NewReader(`[{"title": "fer el mort"}, {"title": 1}]`))
+	if err == nil || !strings.Contains(err.Error(), "entry 1") {
+		t.Errorf("decodeEntries() error = %v, want an error about entry 1", err)
+	}
+}
+
+func TestDecodeEntriesMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("decoding a large fixture")
+	}
+	if isRaceEnabled() {
+		t.Skip("the race detector adds allocations to each decoded entry")
+	}
+
+	// A fixture with as many entries as the dictionary, see BenchmarkEntryCount.
+	var testEntries []json.RawMessage
+	err := json.Unmarshal(TestEntries, &testEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(slices.Repeat(testEntries, BenchmarkEntryCount/len(testEntries)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding the whole array at once buffers all of its JSON, on top of the entries.
+	measure := func(decode func() error) uint64 {
+		t.Helper()
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		err := decode()
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	wholeArray := measure(func() error {
+		var entries []Entry
+		return json.NewDecoder(bytes.NewReader(content)).Decode(&entries)
+	})
+	oneAtATime := measure(func() error {
+		_, err := decodeEntries(bytes.NewReader(content))
+		return err
+	})
+	t.Logf("decoding %d MB of JSON allocated %d MB at once, and %d MB one entry at a time",
+		len(content)>>20, wholeArray>>20, oneAtATime>>20)
+	if oneAtATime >= wholeArray {
+		t.Errorf("decodeEntries() allocated %d bytes, want less than the %d bytes of decoding the whole array", oneAtATime, wholeArray)
+	}
+}
+
+// isRaceEnabled returns whether the tests were built with the race detector.
+func isRaceEnabled() bool {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return false
+	}
+	for _, setting := range buildInfo.Settings {
+		if setting.Key == "-race" {
+			return setting.Value == "true"
+		}
+	}
+	return false
+}
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		count int
+		word  string
+		want  string
+	}{
+		{count: 0, word: "resultat", want: "0 resultats"},
+		{count: 1, word: "resultat", want: "1 resultat"},
+		{count: 2, word: "resultat", want: "2 resultats"},
+		{count: 0, word: "pàgina", want: "0 pàgines"},
+		{count: 1, word: "pàgina", want: "1 pàgina"},
+		{count: 12, word: "pàgina", want: "12 pàgines"},
+		{count: 0, word: "frase", want: "0 frases"},
+		{count: 1, word: "frase", want: "1 frase"},
+		{count: 3, word: "frase", want: "3 frases"},
+		{count: 0, word: "concepte", want: "0 conceptes"},
+		{count: 1, word: "concepte", want: "1 concepte"},
+		{count: 100, word: "concepte", want: "100 conceptes"},
+		{count: 1, word: "desconeguda", want: "1 desconeguda"},
+		{count: 2, word: "desconeguda", want: "2 desconeguda"}, // Not listed, unchanged.
+	}
+	for _, test := range tests {
+		got := pluralize(test.count, test.word)
+		if got != test.want {
+			t.Errorf("pluralize(%d, %q) = %q, want %q", test.count, test.word, got, test.want)
+		}
+	}
+
+	// Adjectives agree in gender and number with their noun.
+	agreementTests := []struct {
+		count int
+		word  string
+		want  string
+	}{
+		{count: 0, word: "trobat", want: "trobats"},
+		{count: 1, word: "trobat", want: "trobat"},
+		{count: 5, word: "trobat", want: "trobats"},
+		{count: 0, word: "trobada", want: "trobades"},
+		{count: 1, word: "trobada", want: "trobada"},
+		{count: 5, word: "trobada", want: "trobades"},
+	}
+	for _, test := range agreementTests {
+		got := pluralForm(test.count, test.word)
+		if got != test.want {
+			t.Errorf("pluralForm(%d, %q) = %q, want %q", test.count, test.word, got, test.want)
+		}
+	}
+}
+
+func TestGetHyphenFallbackQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "fer-se", want: "fer se"},
+		{query: "fer-se el mort", want: "fer se el mort"},
+		{query: "fer - se", want: "fer se"},
+		{query: "fer se", want: "fer-se"},
+		{query: "mort", want: ""},
+	}
+	for _, test := range tests {
+		got := getHyphenFallbackQuery(test.query)
+		if got != test.want {
+			t.Errorf("getHyphenFallbackQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+// BenchmarkEntryCount is the number of entries of the data used in benchmarks, about
+// the size of the dictionary.
+const BenchmarkEntryCount = 20000
+
+// loadBenchmarkData loads BenchmarkEntryCount entries as the dictionary data. They are
+// copies of TestEntries with a made-up word added to each phrase, so that phrases are
+// distinct, and their concepts are grouped so that each has a few hundred phrases, as
+// the largest concepts of the dictionary.
+func loadBenchmarkData(b *testing.B) {
+	var testEntries []Entry
+	err := json.Unmarshal(TestEntries, &testEntries)
+	if err != nil {
+		b.Fatalf("decoding test data: %v", err)
+	}
+
+	entries := make([]Entry, 0, BenchmarkEntryCount)
+	for i := range BenchmarkEntryCount {
+		entry := testEntries[i%len(testEntries)]
+		copyNumber := i / len(testEntries)
+		entry.Title = fmt.Sprintf("%s %s", entry.Title, getBenchmarkWord(copyNumber))
+		normalizedTitle := normalizePhrase(entry.Title)
+		entry.TitleNormalizedWp, entry.TitleNormalizedWpc = normalizedTitle.Wp, normalizedTitle.Wpc
+		entry.Concepte = fmt.Sprintf("%s %s", entry.Concepte, getBenchmarkWord(copyNumber/50))
+		entries = append(entries, entry)
+	}
+	setTestEntries(b, entries)
+}
+
+// getBenchmarkWord returns a made-up word for a number, e.g. "ba" for 0.
+func getBenchmarkWord(number int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	word := []byte{'b'}
+	for {
+		word = append(word, letters[number%len(letters)])
+		number /= len(letters)
+		if number == 0 {
+			return string(word)
+		}
+	}
+}
+
+func BenchmarkGetEntries(b *testing.B) {
+	loadBenchmarkData(b)
+	queries := map[string]string{
+		SearchModeConte:      "fer el mort",
+		SearchModeComencaPer: "fer",
+		SearchModeAcabaEn:    "mort ba",
+		SearchModeCoincident: "fer el mort ba",
+		SearchModeArrel:      "estirant",
+	}
+	for _, mode := range SearchModes {
+		b.Run(mode, func(b *testing.B) {
+			options := SearchOptions{Mode: mode}
+			_, total := getEntries(queries[mode], options, 1, DefaultPageSize)
+			if total == 0 {
+				b.Fatalf("no results for %q", queries[mode])
+			}
+			for b.Loop() {
+				getEntries(queries[mode], options, 1, DefaultPageSize)
+			}
+		})
+	}
+}
+
+// BenchmarkFilterSearchCandidates compares filtering the candidates from
+// EntryIndicesByWord, in the default search mode, or PhrasesByNormalized, in
+// SearchModeComencaPer, with filtering all the entries.
+func BenchmarkFilterSearchCandidates(b *testing.B) {
+	loadBenchmarkData(b)
+	benchmarks := []struct {
+		mode    string
+		queries []string
+	}{
+		{mode: SearchModeConte, queries: []string{"fer el mort", "mort", "estirar la pota ba"}},
+		{mode: SearchModeComencaPer, queries: []string{"a", "rompre", "rompre el jou ba"}},
+	}
+	for _, benchmark := range benchmarks {
+		options := SearchOptions{Mode: benchmark.mode}
+		for _, query := range benchmark.queries {
+			b.Run(benchmark.mode+"/"+query+"/indexed", func(b *testing.B) {
+				for b.Loop() {
+					filterSearchResults(getSearchCandidates(query, options), query, options)
+				}
+			})
+			b.Run(benchmark.mode+"/"+query+"/linear", func(b *testing.B) {
+				for b.Loop() {
+					filterSearchResults(AllEntries, query, options)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkFilterSearchResults compares scanning all the entries in parallel, with
+// several CPUs, with a single scan, in search modes without an index.
+func BenchmarkFilterSearchResults(b *testing.B) {
+	loadBenchmarkData(b)
+	previousProcs := runtime.GOMAXPROCS(0)
+	b.Cleanup(func() {
+		runtime.GOMAXPROCS(previousProcs)
+	})
+	queries := map[string]string{
+		SearchModeArrel:   "estirant",
+		SearchModeAcabaEn: "mort ba",
+	}
+	for _, mode := range []string{SearchModeArrel, SearchModeAcabaEn} {
+		query, options := queries[mode], SearchOptions{Mode: mode}
+		for _, procs := range []int{1, 4} {
+			b.Run(fmt.Sprintf("%s/GOMAXPROCS=%d", mode, procs), func(b *testing.B) {
+				runtime.GOMAXPROCS(procs)
+				for b.Loop() {
+					filterSearchResults(AllEntries, query, options)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRenderEntriesForConceptPage(b *testing.B) {
+	loadBenchmarkData(b)
+	entries := getEntriesByConceptSlug(getConceptSlug("CALLAR ba"))
+	if len(entries) < 100 {
+		b.Fatalf("the concept has %d entries, want a large one", len(entries))
+	}
+	for b.Loop() {
+		renderEntriesForConceptPage(entries, "")
+	}
+}
+
+func BenchmarkNormalizeForSearch(b *testing.B) {
+	for b.Loop() {
+		normalizeForSearch("  Anar-se’n a l’Altre Barri (d’Algú)... ")
+	}
+}
+
+// BenchmarkNewWholeWordsRegexp compares getting the regexps of a few repeated queries
+// from WholeWordsRegexpCache with compiling them every time.
+func BenchmarkNewWholeWordsRegexp(b *testing.B) {
+	previousCache := WholeWordsRegexpCache
+	b.Cleanup(func() {
+		WholeWordsRegexpCache = previousCache
+	})
+	queries := []string{"fer el mort", "mort", "cap", "anima", "estirar la pota", "rompre les cadenes"}
+	for _, capacity := range []int{DefaultRegexpCacheSize, 0} {
+		name := "cached"
+		if capacity == 0 {
+			name = "uncached"
+		}
+		b.Run(name, func(b *testing.B) {
+			WholeWordsRegexpCache = newLRUCache[*regexp.Regexp](capacity)
+			b.ReportAllocs()
+			for b.Loop() {
+				for _, query := range queries {
+					newWholeWordsRegexp(query)
+				}
+			}
+		})
+	}
+}
+
+func TestGetEntriesOrder(t *testing.T) {
+	loadTestData(t)
+
+	// Results are given as "CONCEPT: phrase", as some phrases are in several concepts.
+	tests := []struct {
+		name  string
+		mode  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "contains, exact matches first, then by parentheses content",
+			mode:  SearchModeConte,
+			query: "fer el mort",
+			want: []string{
+				"CALLAR: fer el mort",
+				"ENGANYAR: fer el mort",
+				"DESCANSAR: fer el mort (a l'aigua)",
+				"CALLAR: fer el mort (davant d'algú)",
+				"CALLAR: no fer el mort",
+			},
+		},
+		{
+			name:  "contains, exact match before an earlier phrase",
+			mode:  SearchModeConte,
+			query: "fer la migdiada",
+			want: []string{
+				"DESCANSAR: fer la migdiada",
+				"DESCANSAR: anar a fer la migdiada",
+			},
+		},
+		{
+			name:  "contains a word",
+			mode:  SearchModeConte,
+			query: "mort",
+			want: []string{
+				"CALLAR: fer el mort",
+				"ENGANYAR: fer el mort",
+				"DESCANSAR: fer el mort (a l'aigua)",
+				"CALLAR: fer el mort (davant d'algú)",
+				"MORIR: fer-se el mort",
+				"CALLAR: no fer el mort",
+			},
+		},
+		{
+			name:  "starts with",
+			mode:  SearchModeComencaPer,
+			query: "fer",
+			want: []string{
+				"MORIR: fer el darrer badall",
+				"CALLAR: fer el mort",
+				"ENGANYAR: fer el mort",
+				"DESCANSAR: fer el mort (a l'aigua)",
+				"CALLAR: fer el mort (davant d'algú)",
+				"DESCANSAR: fer la migdiada",
+				"DESCANSAR: fer una becaina",
+				"ENGANYAR: fer veure (una cosa)",
+				"MORIR: fer-se el mort",
+			},
+		},
+		{
+			name:  "ends with",
+			mode:  SearchModeAcabaEn,
+			query: "mort",
+			want: []string{
+				"CALLAR: fer el mort",
+				"ENGANYAR: fer el mort",
+				"DESCANSAR: fer el mort (a l'aigua)",
+				"CALLAR: fer el mort (davant d'algú)",
+				"MORIR: fer-se el mort",
+				"CALLAR: no fer el mort",
+			},
+		},
+		{
+			name:  "coincident, only differing in parentheses content",
+			mode:  SearchModeCoincident,
+			query: "fer el mort",
+			want: []string{
+				"CALLAR: fer el mort",
+				"ENGANYAR: fer el mort",
+				"DESCANSAR: fer el mort (a l'aigua)",
+				"CALLAR: fer el mort (davant d'algú)",
+			},
+		},
+		{
+			name:  "by stem",
+			mode:  SearchModeArrel,
+			query: "estirant",
+			want: []string{
+				"MORIR: estirar la pota",
+				"DESCANSAR: estirar les cames",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, _ := getEntries(test.query, SearchOptions{Mode: test.mode}, 1, DefaultPageSize)
+			got := make([]string, len(entries))
+			for i, entry := range entries {
+				got[i] = entry.Concepte + ": " + entry.Title
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("getEntries(%q, %q) =\n%s\nwant\n%s", test.query, test.mode,
+					strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestGetSearchResultsMatchedForm(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		query   string
+		options SearchOptions
+		want    map[string]MatchedForm // Matched form of each phrase, by phrase.
+	}{
+		// Matches without the parentheses content.
+		{query: "becaina", options: SearchOptions{Mode: SearchModeConte}, want: map[string]MatchedForm{
+			"fer una becaina": MatchedFormWpc,
+		}},
+		// Matches only with the parentheses content.
+		{query: "davant d'algu", options: SearchOptions{Mode: SearchModeAcabaEn}, want: map[string]MatchedForm{
+			"fer el mort (davant d'algú)": MatchedFormWp,
+		}},
+		// Matches both, and the form without the parentheses content is reported.
+		{query: "rompre el jou", options: SearchOptions{Mode: SearchModeComencaPer}, want: map[string]MatchedForm{
+			"rompre el jou (d'algú)": MatchedFormWpc,
+		}},
+		// Matches only a synonym.
+		{query: "a les tres", options: SearchOptions{Mode: SearchModeCoincident, Field: SearchFieldSinonims}, want: map[string]MatchedForm{
+			"a les dues, a les tres": MatchedFormRelated,
+		}},
+	}
+	for _, test := range tests {
+		results, _ := getSearchResults(test.query, test.options, 1, DefaultPageSize)
+		got := make(map[string]MatchedForm)
+		for _, result := range results {
+			got[result.Entry.Title] = result.MatchedForm
+		}
+		if !maps.Equal(got, test.want) {
+			t.Errorf("getSearchResults(%q, %+v) matched %q, want %q", test.query, test.options, got, test.want)
+		}
+	}
+}
+
+func TestStemWord(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{words: []string{"caure", "caic", "caigut", "caiguda"}, want: "ca"},
+		{words: []string{"estirar", "estiro", "estirant", "estirat", "estirava", "estiraria"}, want: "estir"},
+		{words: []string{"parlar", "parlo", "parles", "parlem", "parleu", "parlen", "parlat", "parlades"}, want: "parl"},
+		{words: []string{"dormir", "dormint", "dormit", "dormia"}, want: "dorm"},
+		{words: []string{"cama", "cames"}, want: "cam"},
+		// At least MinStemLength letters are kept.
+		{words: []string{"da"}, want: "da"},
+		{words: []string{"rar"}, want: "rar"},
+	}
+	for _, test := range tests {
+		for _, word := range test.words {
+			got := stemWord(word)
+			if got != test.want {
+				t.Errorf("stemWord(%q) = %q, want %q", word, got, test.want)
+			}
+		}
+	}
+}
+
+func TestGetEntriesStemmed(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "estiro la pota", want: []string{"estirar la pota"}},
+		{query: "estirava les cames", want: []string{"estirar les cames"}},
+		{query: "estirat", want: []string{"estirar la pota", "estirar les cames"}},
+		{query: "vendre fums", want: []string{"vendre fum"}},
+		{query: "fa el mort", want: nil}, // "fa" is not a form of "fer".
+	}
+	for _, test := range tests {
+		entries, _ := getEntries(normalizeForSearch(test.query), SearchOptions{Mode: SearchModeArrel}, 1, DefaultPageSize)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Title)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("getEntries(%q) by stem = %q, want %q", test.query, got, test.want)
+		}
+
+		// Other modes do not match the conjugated forms.
+		entries, _ = getEntries(normalizeForSearch(test.query), SearchOptions{Mode: SearchModeConte}, 1, DefaultPageSize)
+		if len(entries) != 0 {
+			t.Errorf("getEntries(%q) in mode %q = %d entries, want none", test.query, SearchModeConte, len(entries))
+		}
+	}
+}
+
+func TestNewLoggerJSON(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "json")
+	t.Setenv("LOG_LEVEL", "debug")
+	var output bytes.Buffer
+	logger := newLogger(&output)
+
+	logger.Debug("Debugging", "query", "fer el mort")
+	logger.Info("Loaded data", "entries", 30, "letters", 7)
+	logger.Warn("Multiline \"value\"", "error", errors.New("line 1\nline 2"))
+	logger.Error("Server stopped", "address", ":8080")
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	wantMessages := []string{"Debugging", "Loaded data", "Multiline \"value\"", "Server stopped"}
+	if len(lines) != len(wantMessages) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(wantMessages), output.String())
+	}
+	for i, line := range lines {
+		var record map[string]any
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Errorf("line %d is not valid JSON: %v\n%s", i+1, err, line)
+			continue
+		}
+		if record["msg"] != wantMessages[i] || record["level"] == nil || record["time"] == nil {
+			t.Errorf("line %d = %v, want the time, level, and message %q", i+1, record, wantMessages[i])
+		}
+	}
+}
+
+func TestNewLoggerConfiguration(t *testing.T) {
+	tests := []struct {
+		format, level string
+		wantJSON      bool
+		wantInfo      bool
+	}{
+		{wantInfo: true},
+		{format: "text", level: "info", wantInfo: true},
+		{format: "JSON", level: "warn", wantJSON: true},
+		{format: "json", level: "xyz", wantJSON: true, wantInfo: true},
+	}
+	for _, test := range tests {
+		t.Setenv("LOG_FORMAT", test.format)
+		t.Setenv("LOG_LEVEL", test.level)
+		var output bytes.Buffer
+		logger := newLogger(&output)
+		logger.Info("Information")
+		logger.Warn("Warning")
+
+		lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+		wantLines := 1
+		if test.wantInfo {
+			wantLines = 2
+		}
+		if len(lines) != wantLines {
+			t.Errorf("LOG_FORMAT=%q LOG_LEVEL=%q logged %d lines, want %d", test.format, test.level, len(lines), wantLines)
+		}
+		isJSON := json.Valid([]byte(lines[0]))
+		if isJSON != test.wantJSON {
+			t.Errorf("LOG_FORMAT=%q logged JSON: %t, want %t", test.format, isJSON, test.wantJSON)
+		}
+	}
+}
+
+func TestLoadDataFromFileOrSample(t *testing.T) {
+	var sampleEntries []Entry
+	err := json.NewDecoder(mustGunzip(t, SampleData)).Decode(&sampleEntries)
+	if err != nil || len(sampleEntries) == 0 {
+		t.Fatalf("SampleData has %d entries: %v", len(sampleEntries), err)
+	}
+	var testEntries []Entry
+	_ = json.Unmarshal(TestEntries, &testEntries)
+
+	tests := []struct {
+		name        string
+		dataFile    string // DATA_FILE env variable.
+		defaultFile bool   // Whether DefaultDataFile exists.
+		wantErr     error
+		wantSample  bool
+	}{
+		{name: "no data file", wantSample: true},
+		{name: "default data file", defaultFile: true},
+		{name: "DATA_FILE", dataFile: "entries.json.gz"},
+		{name: "missing DATA_FILE", dataFile: "missing.json.gz", wantErr: ErrDataMissing},
+		{name: "missing DATA_FILE, with a default data file", dataFile: "missing.json.gz", defaultFile: true, wantErr: ErrDataMissing},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			gzippedEntries, err := os.ReadFile(writeGzippedTestFile(t, TestEntries))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = os.WriteFile("entries.json.gz", gzippedEntries, 0o644)
+			if test.defaultFile {
+				_ = os.WriteFile(DefaultDataFile, gzippedEntries, 0o644)
+			}
+			t.Setenv("DATA_FILE", test.dataFile)
+			logs := captureLogs(t)
+			AllEntries = nil
+
+			err = loadDataFromFileOrSample()
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("loadDataFromFileOrSample() error = %v, want %v", err, test.wantErr)
+			}
+			usedSample := strings.Contains(logs.String(), "embedded sample data")
+			if usedSample != test.wantSample {
+				t.Errorf("logged %q, want a warning about the sample: %t", logs.String(), test.wantSample)
+			}
+
+			wantEntries := len(testEntries)
+			switch {
+			case test.wantSample:
+				wantEntries = len(sampleEntries)
+			case test.wantErr != nil:
+				wantEntries = 0
+			}
+			if len(AllEntries) != wantEntries {
+				t.Errorf("loaded %d entries, want %d", len(AllEntries), wantEntries)
+			}
+		})
+	}
+}
+
+// mustGunzip returns a reader of the decompressed content of gzipped data.
+func mustGunzip(t *testing.T, data []byte) io.Reader {
+	t.Helper()
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestSplitPhrasesWithCommas(t *testing.T) {
+	setTestEntries(t, []Entry{
+		newTestEntry("FER", "fer-ho tot, fer-ho bé"),
+		newTestEntry("FER", "ficar-se en tot"),
+		newTestEntry("FER", "fer de tot"),
+		newTestEntry("FER", "a tort i a dret, sense mirar prim"),
+	})
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{input: "fer-ho tot, fer-ho bé, ficar-se en tot", want: []string{"fer-ho tot, fer-ho bé", "ficar-se en tot"}},
+		{input: "ficar-se en tot, fer-ho tot, fer-ho bé", want: []string{"ficar-se en tot", "fer-ho tot, fer-ho bé"}},
+		{input: "fer de tot, fer-ho tot, fer-ho bé, ficar-se en tot", want: []string{"fer de tot", "fer-ho tot, fer-ho bé", "ficar-se en tot"}},
+		// The longest existing phrase is kept whole.
+		{input: "a tort i a dret, sense mirar prim, fer de tot", want: []string{"a tort i a dret, sense mirar prim", "fer de tot"}},
+		// Parts that do not form an existing phrase are split as before.
+		{input: "fer-ho tot, fer-ho malament, fer de tot", want: []string{"fer-ho tot", "fer-ho malament", "fer de tot"}},
+		// With semicolons, commas are not separators.
+		{input: "fer-ho tot, fer-ho bé; ficar-se en tot", want: []string{"fer-ho tot, fer-ho bé", "ficar-se en tot"}},
+		// A single existing phrase is not split.
+		{input: "fer-ho tot, fer-ho bé", want: []string{"fer-ho tot, fer-ho bé"}},
+	}
+	for _, test := range tests {
+		got := splitPhrases(test.input, PhrasesMap)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("splitPhrases(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+
+	got := renderBoldPhrases("fer-ho tot, fer-ho bé, ficar-se en tot", false)
+	want := "<strong>fer-ho tot, fer-ho bé</strong>, <strong>ficar-se en tot</strong>"
+	if got != want {
+		t.Errorf("renderBoldPhrases() = %q, want %q", got, want)
+	}
+}
+
+func TestGetHomographs(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		concept string
+		want    []string
+	}{
+		{concept: "CAP1", want: []string{"CAP2", "CAP12"}},
+		{concept: "CAP2", want: []string{"CAP1", "CAP12"}},
+		{concept: "CAP12", want: []string{"CAP1", "CAP2"}},
+		{concept: "CALLAR"},
+		{concept: "MORIR1"}, // Unknown concept.
+	}
+	for _, test := range tests {
+		got := getHomographs(test.concept)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("getHomographs(%q) = %q, want %q", test.concept, got, test.want)
+		}
+	}
+}
+
+func TestGetPageSizesByMode(t *testing.T) {
+	t.Setenv("SEARCH_PAGE_SIZES", "Coincident=50, Conté = 20,Desconegut=5,Acaba en=0,Comença per=x,Coincident")
+
+	got := getPageSizesByMode()
+	want := map[string]int{SearchModeCoincident: 50, SearchModeConte: 20}
+	if !maps.Equal(got, want) {
+		t.Errorf("getPageSizesByMode() = %v, want %v", got, want)
+	}
+}
+
+func TestGetDefaultPageSize(t *testing.T) {
+	previousPageSizes := PageSizesByMode
+	t.Cleanup(func() {
+		PageSizesByMode = previousPageSizes
+	})
+	PageSizesByMode = map[string]int{SearchModeCoincident: 50, SearchModeConte: 20}
+
+	tests := []struct {
+		mode string
+		want int
+	}{
+		{mode: SearchModeCoincident, want: 50},
+		{mode: SearchModeConte, want: 20},
+		{mode: "", want: 20},
+		{mode: SearchModeComencaPer, want: DefaultPageSize},
+	}
+	for _, test := range tests {
+		if got := getDefaultPageSize(test.mode); got != test.want {
+			t.Errorf("getDefaultPageSize(%q) = %d, want %d", test.mode, got, test.want)
+		}
+	}
+}
+
+func TestLoadRetiredConceptSlugs(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "retired.txt")
+	err := os.WriteFile(filePath, []byte("# Removed in 2025\nCAP3\n\n  ànima2  \n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slugs, err := loadRetiredConceptSlugs(filePath)
+	if err != nil {
+		t.Fatalf("loadRetiredConceptSlugs() error = %v", err)
+	}
+	want := map[string]bool{getConceptSlug("CAP3"): true, getConceptSlug("ànima2"): true}
+	if !maps.Equal(slugs, want) {
+		t.Errorf("loadRetiredConceptSlugs() = %v, want %v", slugs, want)
+	}
+
+	slugs, err = loadRetiredConceptSlugs("")
+	if err != nil || len(slugs) != 0 {
+		t.Errorf("loadRetiredConceptSlugs(\"\") = %v, %v, want an empty set", slugs, err)
+	}
+
+	_, err = loadRetiredConceptSlugs(filepath.Join(t.TempDir(), "missing.txt"))
+	if err == nil {
+		t.Errorf("loadRetiredConceptSlugs() with a missing file returned no error")
+	}
+}
+
+func TestSplitPhrasesAlternateSeparators(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{input: "fer el mort / fer-se el mort", want: []string{"fer el mort", "fer-se el mort"}},
+		{input: "anar a la fira · anar al mercat · anar a plaça", want: []string{"anar a la fira", "anar al mercat", "anar a plaça"}},
+		// Separators inside phrases are not surrounded by spaces.
+		{input: "ser cul i merda/ser carn i ungla", want: []string{"ser cul i merda/ser carn i ungla"}},
+		{input: "col·lar, col·locar", want: []string{"col·lar", "col·locar"}},
+		// The geminated l does not prevent splitting by other separators.
+		{input: "col·locar-se / posar-se", want: []string{"col·locar-se", "posar-se"}},
+		// Semicolons take precedence.
+		{input: "fer el mort / fer-se el mort; fer l'orni", want: []string{"fer el mort / fer-se el mort", "fer l'orni"}},
+	}
+	for _, test := range tests {
+		got := splitPhrases(test.input, PhrasesMap)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("splitPhrases(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+
+	got := renderBoldPhrases("fer el mort / fer-se el mort", false)
+	want := "<strong>fer el mort</strong> / <strong>fer-se el mort</strong>"
+	if got != want {
+		t.Errorf("renderBoldPhrases() = %q, want %q", got, want)
+	}
+}
+
+func TestSmartSplit(t *testing.T) {
+	tests := []struct {
+		input     string
+		separator string
+		want      []string
+	}{
+		{input: "fer el mort, fer l'orni", separator: ",", want: []string{"fer el mort", "fer l'orni"}},
+		{input: "fer (a algú, o a tothom) el mort, fer l'orni", separator: ",", want: []string{"fer (a algú, o a tothom) el mort", "fer l'orni"}},
+		// Separators longer than one character.
+		{input: "<em>Va callar.</em> / <em>No va dir res (ni sí / ni no).</em>", separator: " / ", want: []string{"<em>Va callar.</em>", "<em>No va dir res (ni sí / ni no).</em>"}},
+		{input: "", separator: " / ", want: []string{""}},
+	}
+	for _, test := range tests {
+		got := smartSplit(test.input, test.separator)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("smartSplit(%q, %q) = %q, want %q", test.input, test.separator, got, test.want)
+		}
+	}
+}
+
+func TestSplitExamples(t *testing.T) {
+	tests := []struct {
+		examples string
+		want     []string
+	}{
+		{examples: "", want: []string{}},
+		{examples: "<em>Va fer el mort.</em>", want: []string{"<em>Va fer el mort.</em>"}},
+		{examples: "<em>Va fer el mort.</em> / <em>Fes el mort!</em>", want: []string{"<em>Va fer el mort.</em>", "<em>Fes el mort!</em>"}},
+		// Not every part is a complete example in italics.
+		{examples: "<em>Va dir: entra / surt.</em>", want: []string{"<em>Va dir: entra / surt.</em>"}},
+		{examples: "<em>Va fer el mort.</em> / sense cursiva", want: []string{"<em>Va fer el mort.</em> / sense cursiva"}},
+	}
+	for _, test := range tests {
+		got := splitExamples(test.examples)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("splitExamples(%q) = %q, want %q", test.examples, got, test.want)
+		}
+	}
+
+	previousSeparator := ExamplesSeparator
+	t.Cleanup(func() {
+		ExamplesSeparator = previousSeparator
+	})
+	ExamplesSeparator = " | "
+	got := renderExamples("<em>Va fer el mort.</em> | <em>Fes el mort!</em>")
+	want := `<ul class="list-unstyled exemples"><li><em>Va fer el mort.</em></li><li><em>Fes el mort!</em></li></ul>`
+	if got != want {
+		t.Errorf("renderExamples() = %q, want %q", got, want)
+	}
+}
+
+func TestGetConceptTitle(t *testing.T) {
+	tests := []struct {
+		concept string
+		want    string
+	}{
+		{concept: "CALLAR", want: "callar"},
+		{concept: "CAP1", want: "cap 1"},
+		{concept: "CAP12", want: "cap 12"},
+		{concept: "Concepte 12", want: "concepte 12"},
+		{concept: "Concepte   12", want: "concepte 12"},
+		{concept: "FER  EL MORT2", want: "fer el mort 2"},
+	}
+	for _, test := range tests {
+		got := getConceptTitle(test.concept)
+		if got != test.want {
+			t.Errorf("getConceptTitle(%q) = %q, want %q", test.concept, got, test.want)
+		}
+	}
+}
+
+func TestGetConceptTitleHTML(t *testing.T) {
+	tests := []struct {
+		concept string
+		want    string
+	}{
+		{concept: "CALLAR", want: "CALLAR"},
+		{concept: "CAP1", want: "CAP<sup>1</sup>"},
+		{concept: "CAP12", want: "CAP<sup>12</sup>"},
+		{concept: "CAP 12", want: "CAP<sup>12</sup>"},
+		{concept: "FER  EL MORT2", want: "FER EL MORT<sup>2</sup>"},
+		{concept: "ANAR-SE'N <A> \"L'ALTRE\" BARRI3", want: "ANAR-SE&#39;N &lt;A&gt; &#34;L&#39;ALTRE&#34; BARRI<sup>3</sup>"},
+	}
+	for _, test := range tests {
+		got := getConceptTitleHTML(test.concept)
+		if got != test.want {
+			t.Errorf("getConceptTitleHTML(%q) = %q, want %q", test.concept, got, test.want)
+		}
+	}
+}
+
+func TestGetSearchResultsPageCache(t *testing.T) {
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+		SearchResultsCache.Clear()
+	})
+	BuildDate = "2025-01-01"
+	setTestEntries(t, []Entry{newTestEntry("CALLAR", "fer el mort")})
+
+	options := SearchOptions{Mode: SearchModeConte}
+	resultsPage := getSearchResultsPage("fer el mort", options, false, 1, DefaultPageSize)
+	if resultsPage.Total != 1 {
+		t.Fatalf("Total = %d, want 1", resultsPage.Total)
+	}
+
+	// Changing the entries directly does not invalidate the cache, so a hit returns
+	// the previous page. The word index is changed with them, as loading data does.
+	AllEntries, EntryIndicesByWord, PhrasesByNormalized = nil, nil, nil
+	cachedPage := getSearchResultsPage("fer el mort", options, false, 1, DefaultPageSize)
+	if !reflect.DeepEqual(cachedPage, resultsPage) {
+		t.Errorf("getSearchResultsPage() = %+v for a cached page, want %+v", cachedPage, resultsPage)
+	}
+
+	// Each option that affects the results is part of the key.
+	misses := []struct {
+		name           string
+		options        SearchOptions
+		groupByConcept bool
+		page, pageSize int
+	}{
+		{"mode", SearchOptions{Mode: SearchModeComencaPer}, false, 1, DefaultPageSize},
+		{"field", SearchOptions{Mode: SearchModeConte, Field: SearchFieldSinonims}, false, 1, DefaultPageSize},
+		{"exclusion", SearchOptions{Mode: SearchModeConte, ExcludeNew: true}, false, 1, DefaultPageSize},
+		{"order", SearchOptions{Mode: SearchModeConte, ExportOrder: true}, false, 1, DefaultPageSize},
+		{"accents", SearchOptions{Mode: SearchModeConte, AccentedQuery: "mòrt"}, false, 1, DefaultPageSize},
+		{"grouping", options, true, 1, DefaultPageSize},
+		{"page", options, false, 2, DefaultPageSize},
+		{"page size", options, false, 1, 5},
+	}
+	for _, miss := range misses {
+		got := getSearchResultsPage("fer el mort", miss.options, miss.groupByConcept, miss.page, miss.pageSize)
+		if got.Total != 0 {
+			t.Errorf("getSearchResultsPage() with another %s returned a cached page", miss.name)
+		}
+	}
+
+	// Loading data clears the cache.
+	setTestEntries(t, []Entry{newTestEntry("CALLAR", "fer el mort"), newTestEntry("MORIR", "fer el mort")})
+	resultsPage = getSearchResultsPage("fer el mort", options, false, 1, DefaultPageSize)
+	if resultsPage.Total != 2 {
+		t.Errorf("Total = %d after loading data, want 2", resultsPage.Total)
+	}
+
+	// Nothing is cached in development builds.
+	BuildDate = ""
+	getSearchResultsPage("fer el mort", options, false, 1, DefaultPageSize)
+	AllEntries, EntryIndicesByWord, PhrasesByNormalized = nil, nil, nil
+	resultsPage = getSearchResultsPage("fer el mort", options, false, 1, DefaultPageSize)
+	if resultsPage.Total != 0 {
+		t.Errorf("getSearchResultsPage() returned a cached page without BuildDate")
+	}
+}
+
+func TestRenderEntriesForConceptPageRepeatedPhrases(t *testing.T) {
+	newAccepcioEntry := func(accepcio, title, definition string) Entry {
+		entry := newTestEntry("CALLAR", title)
+		entry.AccepcioConcepte = accepcio
+		entry.Definicio = definition
+		return entry
+	}
+	entries := []Entry{
+		newAccepcioEntry("no parlar", "fer el mort", "No dir res."),
+		newAccepcioEntry("no parlar", "no badar boca", "No dir res."),
+		newAccepcioEntry("dissimular", "fer el mort", "No dir res."),
+		newAccepcioEntry("dissimular", "fer el mort (davant d'algú)", "Fer veure que no se sap res."),
+	}
+
+	html := renderEntriesForConceptPage(entries, "")
+
+	// Each phrase is rendered in full once, and its anchor is not reused.
+	if got := strings.Count(html, `id="fer_el_mort"`); got != 1 {
+		t.Errorf("found %d anchors for %q, want 1", got, "fer el mort")
+	}
+	if got := strings.Count(html, "No dir res."); got != 2 {
+		t.Errorf("found %d definitions %q, want 2", got, "No dir res.")
+	}
+	if got := strings.Count(html, `class="entry frase repetida"`); got != 2 {
+		t.Fatalf("found %d repeated phrases, want 2", got)
+	}
+
+	// The identical entry collapses into a link to the first occurrence.
+	if !strings.Contains(html, `vegeu-la en una accepció anterior (<a href="#fer_el_mort">més amunt</a>)`) {
+		t.Error("identical repeated phrase is not collapsed into a link")
+	}
+	// The entry with another definition is rendered with its own anchor, and a note
+	// pointing back to the first occurrence.
+	if !strings.Contains(html, `<article class="entry frase repetida" id="fer_el_mort_davant_d'algu">`) ||
+		!strings.Contains(html, "Fer veure que no se sap res.") ||
+		!strings.Contains(html, `<p class="nota">Aquesta frase també apareix en una accepció anterior (<a href="#fer_el_mort">més amunt</a>)`) {
+		t.Error("repeated phrase with another definition is not rendered with a note")
+	}
+
+	html = renderEntriesForConceptPage(entries, "fer_el_mort_davant_d'algu")
+	if !strings.Contains(html, `<article class="entry frase repetida destacada" id="fer_el_mort_davant_d'algu">`) {
+		t.Error("repeated phrase with another definition is not highlighted")
+	}
+}
+
+func TestRenderEntryPlainText(t *testing.T) {
+	entry := newTestEntry("CALLAR", "fer el mort")
+	entry.AccepcioConcepte = "no parlar"
+	entry.AntonimConcepte = true
+	entry.Definicio = "No dir res <i>per</i> no comprometre&#39;s."
+	entry.Observacions = "<b>Col·loquial</b>."
+
+	got := renderEntryPlainText(entry)
+	want := "fer el mort [sv] — CALLAR (no parlar) [ANT]\n" +
+		"  No dir res per no comprometre's.\n" +
+		"  Observacions: Col·loquial.\n"
+	if got != want {
+		t.Errorf("renderEntryPlainText() = %q, want %q", got, want)
+	}
+}
+
+func TestLoadPopularQueries(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "popular.txt")
+	err := os.WriteFile(filePath, []byte("# Most popular first\nFer el mort\n\nfer el MORT\na\n  Cap  \n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries, err := loadPopularQueries(filePath)
+	if err != nil {
+		t.Fatalf("loadPopularQueries() error = %v", err)
+	}
+	// Queries are normalized, and duplicates and queries too short to run are skipped.
+	want := []string{"fer el mort", "cap"}
+	if !slices.Equal(queries, want) {
+		t.Errorf("loadPopularQueries() = %q, want %q", queries, want)
+	}
+
+	queries, err = loadPopularQueries("")
+	if err != nil || len(queries) != 0 {
+		t.Errorf("loadPopularQueries(\"\") = %q, %v, want no queries", queries, err)
+	}
+
+	_, err = loadPopularQueries(filepath.Join(t.TempDir(), "missing.txt"))
+	if err == nil {
+		t.Errorf("loadPopularQueries() with a missing file returned no error")
+	}
+}
+
+func TestWarmSearchResultsCache(t *testing.T) {
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+		SearchResultsCache.Clear()
+	})
+	loadTestData(t)
+
+	// Nothing is cached in development builds.
+	BuildDate = ""
+	warmSearchResultsCache([]string{"mort"})
+	if got := len(SearchResultsCache.items); got != 0 {
+		t.Errorf("warming without BuildDate cached %d pages, want 0", got)
+	}
+
+	BuildDate = "2025-01-01"
+	warmSearchResultsCache([]string{"mort", "cap"})
+	if got := len(SearchResultsCache.items); got != 2 {
+		t.Fatalf("warming cached %d pages, want 2", got)
+	}
+
+	// The most popular query is the most recently used.
+	front := SearchResultsCache.order.Front().Value.(*lruCacheItem[SearchResultsPage])
+	want := getSearchResultsPage("mort", SearchOptions{Mode: SearchModeConte}, false, 1, DefaultPageSize)
+	if !reflect.DeepEqual(front.value, want) {
+		t.Errorf("the most recently used page is not the most popular query")
+	}
+}
+
+func TestNormalizeForSearchDecomposed(t *testing.T) {
+	// Text pasted from some systems, such as macOS, is in NFD, with the accents as
+	// combining characters after the letters.
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "a\u0300", want: "a"},
+		{input: "A\u0300nima", want: "anima"},
+		{input: "ca\u0300ntir", want: "cantir"},
+		{input: "Jesu\u0301s", want: "jesus"},
+		{input: "pingu\u0308i\u0301", want: "pingui"},
+	}
+	for _, test := range tests {
+		if got := normalizeForSearch(test.input); got != test.want {
+			t.Errorf("normalizeForSearch(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+
+	decomposed := normalizePhrase("fer-se l'a\u0300nima (d'algu\u0301)")
+	precomposed := normalizePhrase("fer-se l'ànima (d'algú)")
+	if decomposed != precomposed {
+		t.Errorf("normalizePhrase() of a decomposed phrase = %+v, want %+v", decomposed, precomposed)
+	}
+}
+
+func TestGetAllSearchResultsDecomposedQuery(t *testing.T) {
+	loadTestData(t)
+
+	for _, mode := range SearchModes {
+		precomposed := "ànima"
+		decomposed := "a\u0300nima"
+		options := SearchOptions{Mode: mode}
+		want := getAllSearchResults(normalizeForSearch(precomposed), options)
+		got := getAllSearchResults(normalizeForSearch(decomposed), options)
+
+		if mode == SearchModeConte && len(want) == 0 {
+			t.Fatalf("no results for %q in mode %q", precomposed, mode)
+		}
+		if len(got) != len(want) {
+			t.Errorf("mode %q: got %d results for the decomposed query, want %d", mode, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if got[i].Entry.Title != want[i].Entry.Title || got[i].Entry.Concepte != want[i].Entry.Concepte {
+				t.Errorf("mode %q: result %d = %q, want %q", mode, i, got[i].Entry.Title, want[i].Entry.Title)
+			}
+		}
+	}
+}
+
+func TestHighlightMatches(t *testing.T) {
+	tests := []struct {
+		fieldHTML       string
+		normalizedQuery string
+		want            string
+	}{
+		{"Va fer el mort.", "mort", "Va fer el <mark>mort</mark>."},
+		{"Ja és l'hora, Àngel.", "angel", "Ja és l'hora, <mark>Àngel</mark>."},
+		{"Va fer el mort per no pagar.", "fer el mort", "Va <mark>fer el mort</mark> per no pagar."},
+		{"<i>Fes</i> el mort, fes-ho!", "fes", "<i><mark>Fes</mark></i> el mort, <mark>fes</mark>-ho!"},
+		// Only whole words are highlighted.
+		{"La morterada.", "mort", "La morterada."},
+	}
+	for _, test := range tests {
+		got := highlightMatches(test.fieldHTML, test.normalizedQuery)
+		if got != test.want {
+			t.Errorf("highlightMatches(%q, %q) = %q, want %q", test.fieldHTML, test.normalizedQuery, got, test.want)
+		}
+	}
+}
+
+func TestHighlightPhraseMatches(t *testing.T) {
+	tests := []struct {
+		title           string
+		normalizedQuery string
+		mode            string
+		want            string
+	}{
+		{"fer el mort", "mort", SearchModeConte, "<strong>fer el <mark>mort</mark></strong>"},
+		{"Fer el Mort", "fer el", SearchModeComencaPer, "<strong><mark>Fer el</mark> Mort</strong>"},
+		{"ànima en pena", "anima", SearchModeConte, "<strong><mark>ànima</mark> en pena</strong>"},
+		// Parentheses content is never highlighted.
+		{"fer el mort (davant d'algú)", "el mort", SearchModeAcabaEn, "<strong>fer <mark>el mort</mark> </strong>(davant d'algú)"},
+		{"fer el mort (davant d'algú)", "fer el mort", SearchModeCoincident, "<strong><mark>fer el mort</mark> </strong>(davant d'algú)"},
+		// A match around parentheses content is highlighted in several parts.
+		{"fer (algú) el mort", "fer el", SearchModeConte, "<strong><mark>fer </mark></strong>(algú)<strong> <mark>el</mark> mort</strong>"},
+		// Only whole words are highlighted.
+		{"la morterada", "mort", SearchModeConte, "<strong>la morterada</strong>"},
+	}
+	for _, test := range tests {
+		got := highlightPhraseMatches(getPhrase(test.title), newTitleHighlightRegexp(test.normalizedQuery, test.mode))
+		// getPhrase links the phrases that belong to several concepts.
+		if !strings.Contains(got, test.want) {
+			t.Errorf("highlightPhraseMatches(%q, %q, %q) = %q, want it to contain %q", test.title, test.normalizedQuery, test.mode, got, test.want)
+		}
+	}
+
+	for _, mode := range []string{SearchModeArrel, SearchModeAproximat} {
+		if regex := newTitleHighlightRegexp("mort", mode); regex != nil {
+			t.Errorf("newTitleHighlightRegexp(%q, %q) = %v, want nil", "mort", mode, regex)
+		}
+	}
+}
+
+func TestCompareConcepts(t *testing.T) {
+	loadTestData(t)
+
+	// "fer el mort (a l'aigua)" is the same phrase as "fer el mort", once normalized.
+	comparison := compareConcepts("CALLAR", getEntriesByConceptSlug("callar"), "DESCANSAR", getEntriesByConceptSlug("descansar"))
+	if comparison.ConceptA != "CALLAR" || comparison.ConceptB != "DESCANSAR" {
+		t.Errorf("concepts = %q, %q, want %q, %q", comparison.ConceptA, comparison.ConceptB, "CALLAR", "DESCANSAR")
+	}
+	wantShared := []string{"fer el mort"}
+	if !slices.Equal(comparison.Shared, wantShared) {
+		t.Errorf("shared = %q, want %q", comparison.Shared, wantShared)
+	}
+	wantOnlyA := []string{"no dir ni piu", "no fer el mort", "tancar la boca"}
+	if !slices.Equal(comparison.OnlyA, wantOnlyA) {
+		t.Errorf("only a = %q, want %q", comparison.OnlyA, wantOnlyA)
+	}
+	wantOnlyB := []string{"anar a fer la migdiada", "estirar les cames", "fer la migdiada", "fer una becaina"}
+	if !slices.Equal(comparison.OnlyB, wantOnlyB) {
+		t.Errorf("only b = %q, want %q", comparison.OnlyB, wantOnlyB)
+	}
+
+	// A concept compared with itself has no unique phrases, and the empty lists are
+	// encoded as JSON arrays.
+	comparison = compareConcepts("MORIR", getEntriesByConceptSlug("morir"), "MORIR", getEntriesByConceptSlug("morir"))
+	if len(comparison.Shared) != 4 {
+		t.Errorf("shared = %q, want 4 phrases", comparison.Shared)
+	}
+	encoded, err := json.Marshal(comparison)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `"nomes_a":[],"nomes_b":[]`) {
+		t.Errorf("encoded comparison = %s, want empty arrays", encoded)
+	}
+}
+
+func TestGetAllSearchResultsExportOrder(t *testing.T) {
+	loadTestData(t)
+
+	getTitles := func(results []SearchResult) []string {
+		var titles []string
+		for i, result := range results {
+			if result.Position != i+1 {
+				t.Errorf("position of result %d = %d, want %d", i, result.Position, i+1)
+			}
+			titles = append(titles, result.Entry.Title)
+		}
+		return titles
+	}
+
+	// The order of the test data file.
+	results := getAllSearchResults("mort", SearchOptions{Mode: SearchModeConte, ExportOrder: true})
+	want := []string{"fer el mort", "fer el mort (davant d'algú)", "no fer el mort", "fer el mort (a l'aigua)", "fer el mort", "fer-se el mort"}
+	if got := getTitles(results); !slices.Equal(got, want) {
+		t.Errorf("titles in export order = %q, want %q", got, want)
+	}
+
+	// The default order is unchanged.
+	results = getAllSearchResults("mort", SearchOptions{Mode: SearchModeConte})
+	want = []string{"fer el mort", "fer el mort", "fer el mort (a l'aigua)", "fer el mort (davant d'algú)", "fer-se el mort", "no fer el mort"}
+	if got := getTitles(results); !slices.Equal(got, want) {
+		t.Errorf("titles in default order = %q, want %q", got, want)
+	}
+}
+
+func TestGetConceptInitial(t *testing.T) {
+	tests := []struct {
+		concept     string
+		wantInitial string
+		wantOK      bool
+	}{
+		{"ANIMA", "A", true},
+		{"ànima", "A", true},
+		{"ÀNIMA", "A", true},
+		{"ÇA", "C", true},
+		{"«ÀNIMA»", "A", true},
+		{"(CAP)", "C", true},
+		{"'ÈXIT'", "E", true},
+		{"1714", "", false},
+		{"3R GRAU", "", false},
+		{"...", "", false},
+		{"", "", false},
+		{"ΑΛΦΑ", "", false},
+	}
+	for _, test := range tests {
+		initial, ok := getConceptInitial(test.concept)
+		if initial != test.wantInitial || ok != test.wantOK {
+			t.Errorf("getConceptInitial(%q) = %q, %t, want %q, %t", test.concept, initial, ok, test.wantInitial, test.wantOK)
+		}
+	}
+}
+
+func TestConceptsByFirstLetterInitials(t *testing.T) {
+	setTestEntries(t, []Entry{
+		newTestEntry("«ÀNIMA»", "ànima de càntir"),
+		newTestEntry("ÇA", "ça i lla"),
+		newTestEntry("1714", "caure Barcelona"),
+		newTestEntry("...", "punts suspensius"),
+	})
+
+	want := map[string][]string{"A": {"«ÀNIMA»"}, "C": {"ÇA"}}
+	if !maps.EqualFunc(ConceptsByFirstLetter, want, slices.Equal) {
+		t.Errorf("ConceptsByFirstLetter = %q, want %q", ConceptsByFirstLetter, want)
+	}
+
+	// Concepts without an initial can still be looked up.
+	if entries := getEntriesByConceptSlug("1714"); len(entries) != 1 {
+		t.Errorf("entries of concept %q = %d, want 1", "1714", len(entries))
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		want           bool
+	}{
+		{"gzip", "gzip", true},
+		{"br, gzip", "gzip", true},
+		{"br, GZIP;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"gzip; q=0.0", "gzip", false},
+		{"x-gzip", "gzip", false},
+		{"*", "gzip", false},
+		{"", "gzip", false},
+		{"br;q=1, gzip", "br", true},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", test.acceptEncoding)
+		if got := acceptsEncoding(request, test.encoding); got != test.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %t, want %t", test.acceptEncoding, test.encoding, got, test.want)
+		}
+	}
+}
+
+func TestGetSuggestions(t *testing.T) {
+	previousMinQueryLength := MinQueryLength
+	t.Cleanup(func() {
+		MinQueryLength = previousMinQueryLength
+	})
+	MinQueryLength = 2
+
+	// More phrases than MaxSuggestions start with "fer", and their collation order
+	// differs from the order of their normalized forms.
+	var entries []Entry
+	for _, word := range []string{"òliba", "ou", "Ase", "àpat", "abril", "ànec", "úlcera", "ull", "èxit", "Eco", "ermita", "ïota", "illa", "bé", "Bo"} {
+		entries = append(entries, newTestEntry("FER", "fer "+word), newTestEntry("FER2", "fer "+word))
+	}
+	entries = append(entries, newTestEntry("CALLAR", "no dir ni piu"))
+	setTestEntries(t, entries)
+
+	collator := collate.New(language.Catalan)
+	for _, prefix := range []string{"fe", "fer", "fer a", "fer o", "fer u", "fer ull", "no", "xyz"} {
+		// Collect all the matching phrases, and sort them with the collation rules.
+		var want []string
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.TitleNormalizedWpc, prefix) && !slices.Contains(want, entry.Title) {
+				want = append(want, entry.Title)
+			}
+		}
+		slices.SortFunc(want, collator.CompareString)
+		want = want[:min(len(want), MaxSuggestions)]
+
+		got := getSuggestions(prefix)
+		if !slices.Equal(got, want) && (len(got) > 0 || len(want) > 0) {
+			t.Errorf("getSuggestions(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+	if got := getSuggestions("fer"); len(got) != MaxSuggestions {
+		t.Errorf("getSuggestions(%q) returned %d phrases, want %d", "fer", len(got), MaxSuggestions)
+	}
+
+	// Prefixes too short to be searched are not suggested.
+	if got := getSuggestions("f"); len(got) != 0 {
+		t.Errorf("getSuggestions(%q) = %q, want none", "f", got)
+	}
+}
+
+func BenchmarkGetSuggestions(b *testing.B) {
+	loadBenchmarkData(b)
+	for b.Loop() {
+		getSuggestions("fer")
+	}
+}
+
+func TestNormalizeForSearchKeepingAccents(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"À la  Bàbala ", "à la bàbala"},
+		{"a la ba\u0300bala", "a la bàbala"},
+		{"l’ànima", "l'ànima"},
+		{"-fer-se, ", "fer-se"},
+	}
+	for _, test := range tests {
+		if got := normalizeForSearchKeepingAccents(test.input); got != test.want {
+			t.Errorf("normalizeForSearchKeepingAccents(%q) = %q, want %q", test.input, got, test.want)
+		}
+		if got, want := normalizeForSearch(test.input), toLowercaseNoAccents(test.want); got != want {
+			t.Errorf("normalizeForSearch(%q) = %q, want %q", test.input, got, want)
+		}
+	}
+}
+
+func TestGetAllSearchResultsAccentedQuery(t *testing.T) {
+	setTestEntries(t, []Entry{
+		newTestEntry("DESORDRE", "a la babalà"),
+		newTestEntry("DESORDRE", "anar a la bàbala"),
+		newTestEntry("DESORDRE", "a la bàbala"),
+		newTestEntry("DESORDRE", "a la babala"),
+	})
+
+	getTitles := func(options SearchOptions) []string {
+		var titles []string
+		for _, result := range getAllSearchResults("babala", options) {
+			titles = append(titles, result.Entry.Title)
+		}
+		return titles
+	}
+
+	// Every phrase matches, and those with the accents of the query come first, in
+	// the usual order. Phrases that only differ in accents keep their export order.
+	tests := []struct {
+		name    string
+		options SearchOptions
+		want    []string
+	}{
+		{"without accents", SearchOptions{Mode: SearchModeConte}, []string{"a la babalà", "a la bàbala", "a la babala", "anar a la bàbala"}},
+		{"bàbala", SearchOptions{Mode: SearchModeConte, AccentedQuery: "bàbala"}, []string{"a la bàbala", "anar a la bàbala", "a la babalà", "a la babala"}},
+		{"babalà", SearchOptions{Mode: SearchModeConte, AccentedQuery: "babalà"}, []string{"a la babalà", "a la bàbala", "a la babala", "anar a la bàbala"}},
+		{"export order", SearchOptions{Mode: SearchModeConte, ExportOrder: true, AccentedQuery: "bàbala"}, []string{"anar a la bàbala", "a la bàbala", "a la babalà", "a la babala"}},
+	}
+	for _, test := range tests {
+		if got := getTitles(test.options); !slices.Equal(got, test.want) {
+			t.Errorf("%s: titles = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetEditDistance(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"", "", 0},
+		{"mort", "", 4},
+		{"", "mort", 4},
+		{"fer el mort", "fer el mort", 0},
+		{"fer el mort", "fer el mrot", 2},
+		{"fer el mort", "fer els mort", 1},
+		{"fer el mort", "fer l mort", 1},
+		{"fer el mort", "far el mort", 1},
+		{"anima", "ànima", 1}, // Characters are counted, not bytes.
+		{"kitten", "sitting", 3},
+	}
+	for _, test := range tests {
+		if got := getEditDistance(test.a, test.b); got != test.want {
+			t.Errorf("getEditDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestGetAllSearchResultsApproximate(t *testing.T) {
+	loadTestData(t)
+
+	getTitles := func(normalizedQuery string) []string {
+		var titles []string
+		for _, result := range getAllSearchResults(normalizedQuery, SearchOptions{Mode: SearchModeAproximat}) {
+			titles = append(titles, result.Entry.Title)
+		}
+		return titles
+	}
+
+	// The closest phrases come first, and phrases too far from the query are left out.
+	tests := []struct {
+		normalizedQuery string
+		want            []string
+	}{
+		{"fer el mrot", []string{"fer el mort", "fer el mort", "fer el mort (a l'aigua)", "fer el mort (davant d'algú)"}},
+		{"fer-se el mort", []string{"fer-se el mort", "fer el mort", "fer el mort", "fer el mort (a l'aigua)", "fer el mort (davant d'algú)"}},
+		{"estirar la pota", []string{"estirar la pota"}},
+		{"estirar la cames", []string{"estirar les cames"}},
+		{"no dir ni pius", []string{"no dir ni piu"}},
+		{"xyz", nil},
+	}
+	for _, test := range tests {
+		if got := getTitles(test.normalizedQuery); !slices.Equal(got, test.want) {
+			t.Errorf("titles for %q = %q, want %q", test.normalizedQuery, got, test.want)
+		}
+	}
+}
+
+func TestSearchURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		mode    string
+		page    int
+		filters url.Values
+		want    string
+	}{
+		{"query only", "fer por", "", 0, nil, "/?frase=fer+por"},
+		{"mode", "fer por", SearchModeConte, 1, nil, "/?mode=Cont%C3%A9&frase=fer+por"},
+		{"escaping", "a&b=c #d?", SearchModeComencaPer, 0, nil, "/?mode=Comen%C3%A7a+per&frase=a%26b%3Dc+%23d%3F"},
+		{"page", "mort", SearchModeCoincident, 3, nil, "/?mode=Coincident&frase=mort&pagina=3"},
+		{"empty query", "", "", 0, nil, "/?frase="},
+		{
+			"filters in order", "mort", SearchModeConte, 2,
+			url.Values{"accents": {AccentsExact}, "exclou": {ExcludeNovetats}, "camp": {SearchFieldSinonims}, "mida": {"5"}},
+			"/?mode=Cont%C3%A9&frase=mort&camp=sinonims&mida=5&exclou=novetats&accents=exacte&pagina=2",
+		},
+		{"empty filters", "mort", "", 0, url.Values{"camp": {""}, "exclou": nil}, "/?frase=mort"},
+		{"unknown filters", "mort", "", 0, url.Values{"z": {"1"}, "a b": {"&"}, "camp": {SearchFieldExemples}}, "/?frase=mort&camp=exemples&a+b=%26&z=1"},
+	}
+	for _, test := range tests {
+		if got := searchURL(test.query, test.mode, test.page, test.filters); got != test.want {
+			t.Errorf("%s: searchURL = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetSearchFilters(t *testing.T) {
+	options := SearchOptions{Mode: SearchModeConte, Field: SearchFieldExemples, ExcludeNew: true, ExportOrder: true, AccentedQuery: "mòrt"}
+	got := searchURL("mort", options.Mode, 0, getSearchFilters(options, true, 10))
+	want := "/?mode=Cont%C3%A9&frase=mort&camp=exemples&mida=10&agrupa=concepte&exclou=novetats&ordre=export&accents=exacte"
+	if got != want {
+		t.Errorf("searchURL with all the filters = %q, want %q", got, want)
+	}
+
+	// Default values are left out.
+	if filters := getSearchFilters(SearchOptions{Mode: SearchModeConte}, false, 0); len(filters) != 0 {
+		t.Errorf("getSearchFilters without filters = %v, want none", filters)
+	}
+}
+
+func TestRenderBoldPhrasesLinks(t *testing.T) {
+	// Links are escaped, so that their separators are valid in the href attribute.
+	got := renderBoldPhrases("fer el mort (davant d'algú)", true)
+	want := `<a href="/?mode=Cont%C3%A9&amp;frase=fer+el+mort" rel="nofollow"><strong>fer el mort </strong>(davant d'algú)</a>`
+	if got != want {
+		t.Errorf("renderBoldPhrases() = %q, want %q", got, want)
+	}
+}
+
+func TestConceptsByFirstLetterVariants(t *testing.T) {
+	setTestEntries(t, []Entry{
+		newTestEntry("Callar", "fer el mort"),
+		newTestEntry("CALLAR", "no dir ni piu"),
+		newTestEntry("callar", "tancar la boca"),
+		newTestEntry("CALLAR", "no badar boca"),
+		newTestEntry("ÀNIMA", "en cos i ànima"),
+		newTestEntry("ANIMA", "ànima de càntir"),
+		newTestEntry("ÀNIMA", "amb l'ànima als peus"),
+		newTestEntry("CAP", "de cap a peus"),
+	})
+
+	// Concepts that differ only in casing or accents are listed once, with their most
+	// common spelling.
+	want := map[string][]string{"A": {"ÀNIMA"}, "C": {"CALLAR", "CAP"}}
+	if !maps.EqualFunc(ConceptsByFirstLetter, want, slices.Equal) {
+		t.Errorf("ConceptsByFirstLetter = %q, want %q", ConceptsByFirstLetter, want)
+	}
+
+	// Slugs are not changed: they are only case-insensitive.
+	if got := getConceptSlug("ÀNIMA"); got != "ànima" {
+		t.Errorf("getConceptSlug(%q) = %q, want %q", "ÀNIMA", got, "ànima")
+	}
+	if got := ConceptsBySlug["callar"]; got != "CALLAR" {
+		t.Errorf("ConceptsBySlug[%q] = %q, want %q", "callar", got, "CALLAR")
+	}
+}
+
+func TestGetDidYouMean(t *testing.T) {
+	loadTestData(t)
+
+	tests := []struct {
+		query       string
+		want        string
+		wantConcept string
+	}{
+		{query: "fer el mprt", want: "fer el mort"},
+		{query: "estirar la pta", want: "estirar la pota"},
+		{query: "descansr", want: "descansar", wantConcept: "DESCANSAR"},
+		{query: "fer el mort", want: ""},     // The query itself.
+		{query: "xyz", want: ""},             // Too short for unrelated suggestions.
+		{query: "zzzzzzzzzzzzzzz", want: ""}, // Nothing close enough.
+	}
+	for _, test := range tests {
+		got, gotConcept := getDidYouMean(test.query)
+		if got != test.want || gotConcept != test.wantConcept {
+			t.Errorf("getDidYouMean(%q) = %q, %q, want %q, %q", test.query, got, gotConcept, test.want, test.wantConcept)
+		}
+	}
+}
+
+func TestGetSearchResultsPageCachesDidYouMean(t *testing.T) {
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+		SearchResultsCache.Clear()
+	})
+	BuildDate = "2026-01-01"
+	loadTestData(t)
+
+	options := SearchOptions{Mode: SearchModeConte}
+	resultsPage := getSearchResultsPage("fer el mprt", options, false, 1, DefaultPageSize)
+	if resultsPage.Total != 0 || resultsPage.DidYouMean != "fer el mort" {
+		t.Fatalf("got %d results, suggesting %q, want none, suggesting %q", resultsPage.Total, resultsPage.DidYouMean, "fer el mort")
+	}
+
+	// The cached page keeps the suggestion, even if the phrases it was found among change.
+	ConceptSuggestions, PhraseSuggestions = nil, nil
+	t.Cleanup(func() {
+		loadTestData(t)
+	})
+	resultsPage = getSearchResultsPage("fer el mprt", options, false, 1, DefaultPageSize)
+	if resultsPage.DidYouMean != "fer el mort" {
+		t.Errorf("cached page suggests %q, want %q", resultsPage.DidYouMean, "fer el mort")
+	}
+}
+
+func TestGetSources(t *testing.T) {
+	const (
+		abbrAM = `<abbr title="Alcover, A. M. - F. de B. Moll, Diccionari Català-Valencià-Balear">A-M</abbr>`
+		abbrRM = `<abbr title="Raspall, J. - J. Martí, Diccionari de Locucions i de Frases Fetes">R-M</abbr>`
+		abbrB  = `<abbr title="Balbastre, J., Nou Recull de Modismes i Frases Fetes. Català-castellà / castellà-català">B</abbr>`
+	)
+	tests := []struct {
+		sources string
+		want    string
+	}{
+		{sources: "", want: ""},
+		{sources: "(A-M, R-M)", want: "(" + abbrAM + ",&nbsp;" + abbrRM + ")"},
+		{sources: "A-M, R-M", want: "(" + abbrAM + ",&nbsp;" + abbrRM + ")"},
+		{sources: "((A-M))", want: "(" + abbrAM + ")"},
+		{sources: "(A-M, XYZ)", want: "(" + abbrAM + ",&nbsp;XYZ)"},
+		// Annotations in parentheses are kept as text.
+		{sources: "(A-M (via B))", want: "(" + abbrAM + " (via B))"},
+		{sources: "(A-M (via B, 1990), R-M)", want: "(" + abbrAM + " (via B, 1990),&nbsp;" + abbrRM + ")"},
+		// Unmatched parentheses are removed.
+		{sources: "(A-M (via B)", want: "(" + abbrAM + " (via B))"},
+		{sources: "(A-M, B))", want: "(" + abbrAM + ",&nbsp;" + abbrB + ")"},
+		{sources: ")A-M(", want: "(" + abbrAM + ")"},
+	}
+	for _, test := range tests {
+		got := getSources(test.sources)
+		if got != test.want {
+			t.Errorf("getSources(%q) = %q, want %q", test.sources, got, test.want)
+		}
+	}
+}
+
+func TestRemoveUnmatchedParentheses(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "(A-M (via B))", want: "(A-M (via B))"},
+		{text: "(A-M (via B)", want: "A-M (via B)"},
+		{text: "A-M) (B", want: "A-M B"},
+		{text: "((à)", want: "(à)"},
+	}
+	for _, test := range tests {
+		got := removeUnmatchedParentheses(test.text)
+		if got != test.want {
+			t.Errorf("removeUnmatchedParentheses(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestGetSitemapIndexURLs(t *testing.T) {
+	got := getSitemapIndexURLs(5, 2)
+	want := []string{BaseCanonicalURL + "/sitemap.xml?pagina=1", BaseCanonicalURL + "/sitemap.xml?pagina=2", BaseCanonicalURL + "/sitemap.xml?pagina=3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("getSitemapIndexURLs(5, 2) = %q, want %q", got, want)
+	}
+	if got := getSitemapIndexURLs(4, 2); len(got) != 2 {
+		t.Errorf("getSitemapIndexURLs(4, 2) = %q, want 2 URLs", got)
+	}
+}
+
+func TestLoadDataFromFileVersion(t *testing.T) {
+	loadTestData(t)
+	version := DataVersion
+	if version == "" {
+		t.Fatal("DataVersion is empty after loading the data")
+	}
+
+	// Compressing the same contents differently keeps the version.
+	var buffer bytes.Buffer
+	gzipWriter, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = gzipWriter.Write(TestEntries)
+	_ = gzipWriter.Close()
+	err = loadDataFromFile(writeTestFile(t, buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DataVersion != version {
+		t.Errorf("DataVersion = %q after recompressing the data, want %q", DataVersion, version)
+	}
+
+	// The same contents without compression also keep the version.
+	err = loadDataFromFile("testdata/entries.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if DataVersion != version {
+		t.Errorf("DataVersion = %q for the plain JSON data, want %q", DataVersion, version)
+	}
+
+	// Changing the contents changes the version.
+	setTestEntries(t, []Entry{newTestEntry("DESCANSAR", "fer el mort")})
+	if DataVersion == version {
+		t.Errorf("DataVersion = %q after changing the data, want a different version", DataVersion)
+	}
+}
+
+// TestMainTemplateFields checks that MainTemplate and PageData agree, so that a typo in
+// a field name, e.g. CanonicalUrl instead of CanonicalURL, is caught by the tests rather
+// than on the first request for the page that renders it. html/template only reports a
+// field that does not exist when the branch that references it is rendered, and a page
+// whose field is never printed just renders it empty.
+//
+// The test works in two steps:
+//   - Every field referenced in the parse tree of the templates must be a field of
+//     PageData, whichever branch it is in
+//   - The template is rendered with missingkey=error, in full and as a fragment, for
+//     every kind of page and state of the search results, with a PageData whose fields
+//     are all set to values that identify them. Every field that the template prints
+//     must appear in at least one of the renders
+//
+// New fields of PageData are populated automatically. Fields of a type not handled
+// below make the test fail, so that they are not left empty.
+func TestMainTemplateFields(t *testing.T) {
+	parseTemplates()
+	mainTemplate := template.Must(MainTemplate.Clone()).Option("missingkey=error")
+
+	// Collect the fields referenced and printed by the templates, before rendering
+	// them, as html/template then rewrites the parse trees to escape the output.
+	referencedFields := map[string]bool{}
+	printedFields := map[string]bool{}
+	var walk func(node parse.Node, isInRange bool)
+	walk = func(node parse.Node, isInRange bool) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node != nil {
+				for _, child := range node.Nodes {
+					walk(child, isInRange)
+				}
+			}
+		case *parse.ActionNode:
+			if len(node.Pipe.Decl) == 0 && len(node.Pipe.Cmds) == 1 && len(node.Pipe.Cmds[0].Args) == 1 {
+				if field, ok := node.Pipe.Cmds[0].Args[0].(*parse.FieldNode); ok && !isInRange {
+					printedFields[field.Ident[0]] = true
+				}
+			}
+			walk(node.Pipe, isInRange)
+		case *parse.IfNode:
+			walk(node.Pipe, isInRange)
+			walk(node.List, isInRange)
+			walk(node.ElseList, isInRange)
+		case *parse.WithNode:
+			// The dot is the value of the pipeline in the body.
+			walk(node.Pipe, isInRange)
+			walk(node.List, true)
+			walk(node.ElseList, isInRange)
+		case *parse.RangeNode:
+			// The dot is each element in the body.
+			walk(node.Pipe, isInRange)
+			walk(node.List, true)
+			walk(node.ElseList, isInRange)
+		case *parse.TemplateNode:
+			if node.Pipe != nil {
+				walk(node.Pipe, isInRange)
+			}
+		case *parse.PipeNode:
+			for _, command := range node.Cmds {
+				walk(command, isInRange)
+			}
+		case *parse.CommandNode:
+			for _, argument := range node.Args {
+				walk(argument, isInRange)
+			}
+		case *parse.ChainNode:
+			walk(node.Node, isInRange)
+		case *parse.FieldNode:
+			if !isInRange {
+				referencedFields[node.Ident[0]] = true
+			}
+		case *parse.VariableNode:
+			if len(node.Ident) > 1 && node.Ident[0] == "$" {
+				referencedFields[node.Ident[1]] = true
+			}
+		}
+	}
+	for _, namedTemplate := range mainTemplate.Templates() {
+		if namedTemplate.Tree != nil {
+			walk(namedTemplate.Tree.Root, false)
+		}
+	}
+	if len(printedFields) == 0 {
+		t.Fatal("found no fields printed by the templates")
+	}
+	pageDataType := reflect.TypeFor[PageData]()
+	for field := range referencedFields {
+		if _, exists := pageDataType.FieldByName(field); !exists {
+			t.Errorf("the templates reference .%s, which is not a field of PageData", field)
+		}
+	}
+
+	// Populate every field with a value that identifies it.
+	var pageData PageData
+	pageDataValue := reflect.ValueOf(&pageData).Elem()
+	renderedValues := map[string]string{}
+	for i := range pageDataValue.NumField() {
+		field, name := pageDataValue.Field(i), pageDataType.Field(i).Name
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString("valor" + name)
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Int:
+			field.SetInt(int64(1000 + i))
+		case reflect.Slice:
+			if field.Type() == reflect.TypeFor[[]CategoryFacet]() {
+				field.Set(reflect.ValueOf([]CategoryFacet{{Count: 2, URL: "/"}, {Name: "valor" + name, Count: 1, URL: "/", IsActive: true}}))
+			} else {
+				field.Set(reflect.ValueOf([]string{"valor" + name}))
+			}
+		case reflect.Map:
+			field.Set(reflect.ValueOf(url.Values{"camp": {"valor" + name}}))
+		default:
+			t.Fatalf("field %s of PageData has type %s, which the test does not populate", name, field.Type())
+		}
+		renderedValues[name] = fmt.Sprint(field.Interface())
+	}
+
+	var renders strings.Builder
+	pageFlags := []*bool{nil, &pageData.IsHomepage, &pageData.IsAbreviaturesPage, &pageData.IsCategoryPage,
+		&pageData.IsConceptPage, &pageData.IsConeixPage, &pageData.IsCreditsPage, &pageData.IsLetterPage,
+		&pageData.IsPresentacioPage}
+	for _, pageFlag := range pageFlags {
+		for _, flag := range pageFlags[1:] {
+			*flag = flag == pageFlag
+		}
+		// The search results are either too short, found, or not found.
+		for _, isQueryTooShort := range []bool{true, false} {
+			for _, phrasesHTML := range []template.HTML{"valorPhrasesHTML", ""} {
+				pageData.IsQueryTooShort = isQueryTooShort
+				pageData.PhrasesHTML = phrasesHTML
+				for _, isFragment := range []bool{false, true} {
+					pageData.IsFragment = isFragment
+					var err error
+					if isFragment {
+						err = mainTemplate.ExecuteTemplate(&renders, "fragment", pageData)
+					} else {
+						err = mainTemplate.Execute(&renders, pageData)
+					}
+					if err != nil {
+						t.Fatalf("rendering the main template: %v", err)
+					}
+				}
+			}
+		}
+	}
+	for field := range printedFields {
+		if !strings.Contains(renders.String(), renderedValues[field]) {
+			t.Errorf("the templates print .%s, but it is never rendered", field)
+		}
+	}
+}
+
+func TestGetBaseCanonicalURL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: DefaultBaseCanonicalURL},
+		{value: "https://staging.dsff.uab.cat", want: "https://staging.dsff.uab.cat"},
+		{value: "http://localhost:8080/", want: "http://localhost:8080"},
+		// Invalid URLs are ignored.
+		{value: "staging.dsff.uab.cat", want: DefaultBaseCanonicalURL},
+		{value: "ftp://dsff.uab.cat", want: DefaultBaseCanonicalURL},
+		{value: "https://", want: DefaultBaseCanonicalURL},
+	}
+	for _, test := range tests {
+		t.Setenv("CANONICAL_BASE_URL", test.value)
+		if got := getBaseCanonicalURL(); got != test.want {
+			t.Errorf("getBaseCanonicalURL() with %q = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	// Canonical URLs use the configured base URL.
+	previousBaseURL := BaseCanonicalURL
+	t.Cleanup(func() {
+		BaseCanonicalURL = previousBaseURL
+	})
+	BaseCanonicalURL = "https://staging.dsff.uab.cat"
+	got := getCanonicalURL(httptest.NewRequest(http.MethodGet, "/?frase=mort", nil))
+	if want := "https://staging.dsff.uab.cat/?frase=mort"; got != want {
+		t.Errorf("getCanonicalURL() = %q, want %q", got, want)
+	}
+}
+
+func TestReplaceAbbreviationsWholeWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{
+			text: "Mall. (met.)",
+			want: `<abbr title="Mallorca i mallorquí">Mall.</abbr> (<abbr title="metàfora, metafòric">met.</abbr>)`,
+		},
+		// Place names that contain abbreviations are kept.
+		{text: "Palamet.", want: "Palamet."},
+		{text: "Comet. i Vall.", want: "Comet. i Vall."},
+		{
+			text: "Val. i Tarr.",
+			want: `<abbr title="València i valencià">Val.</abbr> i <abbr title="Tarragona">Tarr.</abbr>`,
+		},
+		// Longer abbreviations are preferred.
+		{
+			text: "Camp de Tarr.",
+			want: `<abbr title="Camp de Tarragona">Camp de Tarr.</abbr>`,
+		},
+	}
+	for _, test := range tests {
+		got := replaceAbbreviationsWholeWords(test.text)
+		if got != test.want {
+			t.Errorf("replaceAbbreviationsWholeWords(%q) =\n%s\nwant\n%s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestRenderSingleEntryDialectalMarking(t *testing.T) {
+	entry := newTestEntry("CALLAR", "no dir ni piu")
+	entry.MarcatgeDialectal = "Palamet., Mall."
+	got := renderSingleEntry(entry)
+	want := `<p>[Palamet., <abbr title="Mallorca i mallorquí">Mall.</abbr>]</p>`
+	if !strings.Contains(got, want) {
+		t.Errorf("renderSingleEntry() =\n%s\nwant it to contain\n%s", got, want)
+	}
+}
+
+func TestReloadData(t *testing.T) {
+	previousLogger := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previousLogger)
+	})
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	loadTestData(t)
+	version, entryCount := DataVersion, len(AllEntries)
+
+	// A missing, corrupt, or empty file keeps the current data.
+	for _, dataFile := range []string{
+		filepath.Join(t.TempDir(), "missing.json.gz"),
+		writeTestFile(t, []byte("not gzip")),
+		writeGzippedTestFile(t, []byte("[]")),
+	} {
+		t.Setenv("DATA_FILE", dataFile)
+		reloadData()
+		if DataVersion != version || len(AllEntries) != entryCount {
+			t.Errorf("reloading %s replaced the data with %d entries, want the current %d", dataFile, len(AllEntries), entryCount)
+		}
+	}
+
+	content, err := json.Marshal([]Entry{newTestEntry("DESCANSAR", "fer el mort")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DATA_FILE", writeGzippedTestFile(t, content))
+	reloadData()
+	if DataVersion == version || len(AllEntries) != 1 {
+		t.Errorf("reloading a new file kept %d entries, version %q, want 1 entry and a new version", len(AllEntries), DataVersion)
+	}
+	if len(ConceptsBySlug) != 1 || len(PhraseSuggestions) != 1 || len(ConceptSuggestions) != 1 {
+		t.Errorf("reloading a new file did not replace the lookup structures")
+	}
+}
+
+func TestReloadDataDuringRequests(t *testing.T) {
+	previousBuildDate := BuildDate
+	previousLogger := slog.Default()
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+		slog.SetDefault(previousLogger)
+		SearchResultsCache.Clear()
+	})
+	BuildDate = "2026-01-01"
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	t.Setenv("DATA_FILE", writeGzippedTestFile(t, TestEntries))
+	loadTestData(t)
+	parseTemplates()
+	handler := dataLockMiddleware(newServeMux())
+
+	// Run it with -race, so that the race detector checks the locking.
+	targets := []string{
+		"/?frase=mort",
+		"/?frase=fer+el+mprt",
+		"/concepte/callar",
+		"/lletra/C",
+		"/api/cerca?frase=mort",
+		"/export.json",
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 20 {
+				target := targets[i%len(targets)]
+				response := serveTestRequest(handler.ServeHTTP, target)
+				if response.Code != http.StatusOK {
+					t.Errorf("GET %s = %d during a reload, want %d", target, response.Code, http.StatusOK)
+				}
+			}
+		}()
+	}
+	// Several reloads overlap, as with a SIGHUP sent while another is handled.
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 3 {
+				reloadData()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(AllEntries) == 0 {
+		t.Error("no entries after reloading")
+	}
+}
+
+func TestGetRelatedConcepts(t *testing.T) {
+	loadTestData(t)
+
+	// "fer el mort" is shared by CALLAR and DESCANSAR, see TestCompareConcepts.
+	related := getRelatedConcepts("callar", getEntriesByConceptSlug("callar"))
+	index := slices.IndexFunc(related, func(concept RelatedConcept) bool { return concept.Slug == "descansar" })
+	if index < 0 {
+		t.Fatalf("related concepts of callar = %+v, want descansar", related)
+	}
+	if related[index].SharedPhrases != 1 || related[index].Path != getConceptPath("DESCANSAR") {
+		t.Errorf("related concept = %+v, want 1 shared phrase and path %s", related[index], getConceptPath("DESCANSAR"))
+	}
+
+	// The relation is symmetric, and never includes the concept itself.
+	for conceptSlug := range ConceptsBySlug {
+		for _, relatedConcept := range getRelatedConcepts(conceptSlug, getEntriesByConceptSlug(conceptSlug)) {
+			if relatedConcept.Slug == conceptSlug {
+				t.Errorf("related concepts of %s include itself", conceptSlug)
+			}
+			reverse := getRelatedConcepts(relatedConcept.Slug, getEntriesByConceptSlug(relatedConcept.Slug))
+			found := slices.ContainsFunc(reverse, func(concept RelatedConcept) bool {
+				return concept.Slug == conceptSlug && concept.SharedPhrases == relatedConcept.SharedPhrases
+			})
+			if !found {
+				t.Errorf("%s is related to %s with %d phrases, but not the other way around", relatedConcept.Slug, conceptSlug, relatedConcept.SharedPhrases)
+			}
+		}
+	}
+
+	// Concepts without shared phrases have an empty list.
+	if related := getRelatedConcepts("inexistent", nil); related == nil || len(related) != 0 {
+		t.Errorf("related concepts without entries = %#v, want an empty slice", related)
+	}
+}
+
+func TestNormalizeConceptSlug(t *testing.T) {
+	tests := []struct {
+		conceptSlug string
+		want        string
+	}{
+		{conceptSlug: "cap_gros", want: "cap_gros"},
+		{conceptSlug: "Cap_Gros", want: "cap_gros"},
+		{conceptSlug: "cap gros ", want: "cap_gros"},
+		{conceptSlug: "cap+gros", want: "cap_gros"},
+		{conceptSlug: "_cap__gros_", want: "cap_gros"},
+		{conceptSlug: " +CAP + GROS+ ", want: "cap_gros"},
+		{conceptSlug: "ànima", want: "ànima"},
+	}
+	for _, test := range tests {
+		if got := normalizeConceptSlug(test.conceptSlug); got != test.want {
+			t.Errorf("normalizeConceptSlug(%q) = %q, want %q", test.conceptSlug, got, test.want)
+		}
+	}
+}
+
+func TestLoadDataFromFileMissingAbsolutePath(t *testing.T) {
+	directory := t.TempDir()
+	t.Chdir(directory)
+
+	err := loadDataFromFile("missing.json.gz")
+	if !errors.Is(err, ErrDataMissing) {
+		t.Fatalf("loadDataFromFile() error = %v, want %v", err, ErrDataMissing)
+	}
+	absolutePath := filepath.Join(directory, "missing.json.gz")
+	if !strings.Contains(err.Error(), absolutePath) {
+		t.Errorf("loadDataFromFile() error = %q, want it to name %s", err, absolutePath)
+	}
+}
+
+func TestEntryIndicesByCategory(t *testing.T) {
+	loadTestData(t)
+
+	collator := collate.New(language.Catalan)
+	counts := make(map[string]int)
+	for _, entry := range AllEntries {
+		counts[entry.Categoria]++
+	}
+	if len(EntryIndicesByCategory) != len(counts) {
+		t.Errorf("indexed %d categories, want %d", len(EntryIndicesByCategory), len(counts))
+	}
+	for categoryKey, count := range counts {
+		indices := EntryIndicesByCategory[categoryKey]
+		if len(indices) != count {
+			t.Errorf("indexed %d entries of category %q, want %d", len(indices), categoryKey, count)
+		}
+		for _, index := range indices {
+			if AllEntries[index].Categoria != categoryKey {
+				t.Errorf("index of category %q has %q, of category %q", categoryKey, AllEntries[index].Title, AllEntries[index].Categoria)
+			}
+		}
+
+		entries := getEntriesByCategory(categoryKey)
+		isSorted := slices.IsSortedFunc(entries, func(a, b Entry) int {
+			return cmp.Or(
+				collator.CompareString(a.Concepte, b.Concepte),
+				collator.CompareString(a.TitleNormalizedWpc, b.TitleNormalizedWpc),
+			)
+		})
+		if !isSorted {
+			t.Errorf("entries of category %q are not sorted by concept and phrase", categoryKey)
+		}
+	}
+	if entries := getEntriesByCategory("sa"); len(entries) != 0 {
+		t.Errorf("getEntriesByCategory(%q) returned %d entries, want none", "sa", len(entries))
+	}
+}
+
+func TestGetSlowRequestTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: DefaultSlowRequestTime},
+		{value: "500ms", want: 500 * time.Millisecond},
+		{value: "0", want: 0},
+		// Invalid and negative durations are ignored.
+		{value: "500", want: DefaultSlowRequestTime},
+		{value: "-1s", want: DefaultSlowRequestTime},
+	}
+	for _, test := range tests {
+		t.Setenv("SLOW_REQUEST_TIME", test.value)
+		if got := getSlowRequestTime(); got != test.want {
+			t.Errorf("getSlowRequestTime() with %q = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestGetRegexpCacheSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{value: "", want: DefaultRegexpCacheSize},
+		{value: "1000", want: 1000},
+		{value: "0", want: 0},
+		// Invalid and negative sizes are ignored.
+		{value: "many", want: DefaultRegexpCacheSize},
+		{value: "-1", want: DefaultRegexpCacheSize},
+	}
+	for _, test := range tests {
+		t.Setenv("REGEXP_CACHE_SIZE", test.value)
+		if got := getRegexpCacheSize(); got != test.want {
+			t.Errorf("getRegexpCacheSize() with %q = %d, want %d", test.value, got, test.want)
+		}
+	}
+}
+
+func TestNewWholeWordsRegexpCache(t *testing.T) {
+	previousCache := WholeWordsRegexpCache
+	t.Cleanup(func() {
+		WholeWordsRegexpCache = previousCache
+	})
+
+	// The same words get the same compiled regexp.
+	WholeWordsRegexpCache = newLRUCache[*regexp.Regexp](DefaultRegexpCacheSize)
+	regex := newWholeWordsRegexp("fer el mort")
+	if newWholeWordsRegexp("fer el mort") != regex {
+		t.Error("newWholeWordsRegexp() compiled the same words again")
+	}
+	if other := newWholeWordsRegexp("mort"); other == regex || !other.MatchString("fer el mort") || other.MatchString("mortal") {
+		t.Errorf("newWholeWordsRegexp(%q) = %s, want a regexp of the whole word", "mort", other)
+	}
+
+	// A size of 0 disables the cache.
+	WholeWordsRegexpCache = newLRUCache[*regexp.Regexp](0)
+	if newWholeWordsRegexp("fer el mort") == newWholeWordsRegexp("fer el mort") {
+		t.Error("newWholeWordsRegexp() reused a regexp with the cache disabled")
+	}
+}
+
+func TestGetStaticAssetVersions(t *testing.T) {
+	cssPath := writeTestFile(t, []byte("body{}"))
+	versions := getStaticAssetVersions(map[string]string{
+		"/main.min.css":  cssPath,
+		"/search.min.js": filepath.Join(t.TempDir(), "missing.js"),
+	})
+	if len(versions) != 1 || len(versions["/main.min.css"]) != 10 {
+		t.Fatalf("getStaticAssetVersions() = %v, want a 10-character version of the CSS only", versions)
+	}
+
+	// The version only changes with the contents.
+	if again := getStaticAssetVersions(map[string]string{"/main.min.css": cssPath}); again["/main.min.css"] != versions["/main.min.css"] {
+		t.Errorf("version = %q for the same contents, want %q", again["/main.min.css"], versions["/main.min.css"])
+	}
+	changed := getStaticAssetVersions(map[string]string{"/main.min.css": writeTestFile(t, []byte("body{color:red}"))})
+	if changed["/main.min.css"] == versions["/main.min.css"] {
+		t.Errorf("version = %q for different contents, want a different version", changed["/main.min.css"])
+	}
+
+	previousVersions := StaticAssetVersions
+	t.Cleanup(func() {
+		StaticAssetVersions = previousVersions
+	})
+	StaticAssetVersions = versions
+	if got, want := assetURL("/main.min.css"), "/main.min.css?v="+versions["/main.min.css"]; got != want {
+		t.Errorf("assetURL(%q) = %q, want %q", "/main.min.css", got, want)
+	}
+	if got := assetURL("/search.min.js"); got != "/search.min.js" {
+		t.Errorf("assetURL(%q) = %q, want it unchanged", "/search.min.js", got)
+	}
+}
+
+func TestPrecompressedFileHandlerCaching(t *testing.T) {
+	cssPath := writeTestFile(t, []byte("body{}"))
+	for _, suffix := range []string{".br", ".gz"} {
+		err := os.WriteFile(cssPath+suffix, []byte("compressed"+suffix), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	previousVersions := StaticAssetVersions
+	t.Cleanup(func() {
+		StaticAssetVersions = previousVersions
+	})
+	StaticAssetVersions = map[string]string{"/main.min.css": "0123456789"}
+	handler := precompressedFileHandler(cssPath, "text/css")
+
+	tests := []struct {
+		target         string
+		acceptEncoding string
+		wantETag       string
+		wantImmutable  bool
+	}{
+		{target: "/main.min.css?v=0123456789", acceptEncoding: "br, gzip", wantETag: `"0123456789-br"`, wantImmutable: true},
+		{target: "/main.min.css?v=0123456789", acceptEncoding: "gzip", wantETag: `"0123456789-gzip"`, wantImmutable: true},
+		{target: "/main.min.css?v=0123456789", wantETag: `"0123456789"`, wantImmutable: true},
+		// Outdated links and links without a version are not pinned.
+		{target: "/main.min.css?v=9876543210", acceptEncoding: "gzip", wantETag: `"0123456789-gzip"`},
+		{target: "/main.min.css", wantETag: `"0123456789"`},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		request.Header.Set("Accept-Encoding", test.acceptEncoding)
+		response := httptest.NewRecorder()
+		handler(response, request)
+
+		if etag := response.Header().Get("ETag"); etag != test.wantETag {
+			t.Errorf("GET %s with %q: ETag = %s, want %s", test.target, test.acceptEncoding, etag, test.wantETag)
+		}
+		isImmutable := response.Header().Get("Cache-Control") == "public, max-age=31536000, immutable"
+		if isImmutable != test.wantImmutable {
+			t.Errorf("GET %s: immutable = %t, want %t", test.target, isImmutable, test.wantImmutable)
+		}
+
+		// The ETag validates the representation it was sent with.
+		request.Header.Set("If-None-Match", test.wantETag)
+		response = httptest.NewRecorder()
+		handler(response, request)
+		if response.Code != http.StatusNotModified {
+			t.Errorf("GET %s with its ETag = %d, want %d", test.target, response.Code, http.StatusNotModified)
+		}
+	}
+}
+
+func TestPrecompressedFileHandlerEncoding(t *testing.T) {
+	cssPath := writeTestFile(t, []byte("body{}"))
+	for _, suffix := range []string{".br", ".gz"} {
+		err := os.WriteFile(cssPath+suffix, []byte("compressed"+suffix), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	jsPath := writeTestFile(t, []byte("let a;"))
+	err := os.WriteFile(jsPath+".gz", []byte("compressed.gz"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filePath       string
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       string
+	}{
+		{filePath: cssPath, acceptEncoding: "br", wantEncoding: "br", wantBody: "compressed.br"},
+		{filePath: cssPath, acceptEncoding: "gzip, deflate, br", wantEncoding: "br", wantBody: "compressed.br"},
+		{filePath: cssPath, acceptEncoding: "gzip", wantEncoding: "gzip", wantBody: "compressed.gz"},
+		{filePath: cssPath, acceptEncoding: "br;q=0, gzip", wantEncoding: "gzip", wantBody: "compressed.gz"},
+		{filePath: cssPath, acceptEncoding: "brotli", wantBody: "body{}"},
+		{filePath: cssPath, wantBody: "body{}"},
+		// Missing variants are skipped.
+		{filePath: jsPath, acceptEncoding: "br, gzip", wantEncoding: "gzip", wantBody: "compressed.gz"},
+		{filePath: jsPath, acceptEncoding: "br", wantBody: "let a;"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/main.min.css", nil)
+		request.Header.Set("Accept-Encoding", test.acceptEncoding)
+		response := httptest.NewRecorder()
+		precompressedFileHandler(test.filePath, "text/css")(response, request)
+
+		encoding := response.Header().Get("Content-Encoding")
+		if encoding != test.wantEncoding || response.Body.String() != test.wantBody {
+			t.Errorf("GET %s with Accept-Encoding %q = %q encoded as %q, want %q encoded as %q", filepath.Base(test.filePath),
+				test.acceptEncoding, response.Body.String(), encoding, test.wantBody, test.wantEncoding)
+		}
+		if contentType := response.Header().Get("Content-Type"); contentType != "text/css" {
+			t.Errorf("Content-Type = %q, want %q", contentType, "text/css")
+		}
+	}
+}
+
+func TestCheckPrecompressedFiles(t *testing.T) {
+	cssPath := writeTestFile(t, []byte("body{}"))
+	for _, suffix := range []string{".br", ".gz"} {
+		err := os.WriteFile(cssPath+suffix, []byte("compressed"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	jsPath := writeTestFile(t, []byte("let a;"))
+	err := os.WriteFile(jsPath+".gz", []byte("compressed"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+
+	checkPrecompressedFiles(map[string]string{"/main.min.css": cssPath})
+	if logs.Len() != 0 {
+		t.Errorf("logged %q with all the pre-compressed files, want nothing", logs.String())
+	}
+
+	checkPrecompressedFiles(map[string]string{"/main.min.css": cssPath, "/search.min.js": jsPath})
+	if strings.Count(logs.String(), "\n") != 1 || !strings.Contains(logs.String(), jsPath+".br") {
+		t.Errorf("logged %q, want a single warning about %s", logs.String(), jsPath+".br")
+	}
+}
+
+func TestLogDataProblems(t *testing.T) {
+	entry := newTestEntry("CALLAR", "fer el mort")
+	entry.Definicio = "No dir res."
+	accentedEntry := newTestEntry("CALLAR", "no dir ni piu")
+	accentedEntry.Definicio = "No dir res."
+	accentedEntry.TitleNormalizedWpc = "no dir ni piú"
+	setTestEntries(t, []Entry{entry, accentedEntry})
+	logs := captureLogs(t)
+
+	logDataProblems()
+	type problemRecord struct {
+		Message  string `json:"msg"`
+		Title    string `json:"title"`
+		Problems int    `json:"problems"`
+	}
+	var records []problemRecord
+	for line := range strings.Lines(logs.String()) {
+		var record problemRecord
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatalf("logged %q, want JSON records: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 || records[0].Title != "no dir ni piu" || records[1].Message != "Validated data" || records[1].Problems != 1 {
+		t.Errorf("logged %+v, want a problem of %q and a summary", records, "no dir ni piu")
+	}
+
+	for value, want := range map[string]bool{"": false, "true": true, "1": true, "false": false, "x": false} {
+		t.Setenv("VALIDATE_DATA", value)
+		if got := getValidateData(); got != want {
+			t.Errorf("getValidateData() with %q = %t, want %t", value, got, want)
+		}
+	}
+}
+
+func TestGetSearchCandidates(t *testing.T) {
+	loadTestData(t)
+
+	queries := []string{"fer el mort", "mort", "el", "fer mort", "mo", "ort", "anar-se'n", "altre barri", "ni un", "1", "xyz", "", "a", "fer", "ro"}
+	optionsList := []SearchOptions{
+		{},
+		{Mode: SearchModeConte},
+		{Mode: SearchModeConte, ExcludeNew: true},
+		{Mode: SearchModeConte, Field: SearchFieldSinonims},
+		{Mode: SearchModeComencaPer},
+		{Mode: SearchModeCoincident},
+	}
+	getTitles := func(results []SearchResult) []string {
+		var titles []string
+		for _, result := range results {
+			titles = append(titles, result.Entry.Concepte+": "+result.Entry.Title)
+		}
+		return titles
+	}
+
+	// The candidates give the same results as all the entries.
+	for _, options := range optionsList {
+		for _, query := range queries {
+			normalizedQuery := normalizeForSearch(query)
+			got := getTitles(filterSearchResults(getSearchCandidates(normalizedQuery, options), normalizedQuery, options))
+			want := getTitles(filterSearchResults(AllEntries, normalizedQuery, options))
+			if !slices.Equal(got, want) {
+				t.Errorf("results for %q with %+v = %q from the candidates, want %q", query, options, got, want)
+			}
+		}
+	}
+
+	// In the default mode, only the entries with every word are candidates.
+	candidates := getSearchCandidates("fer mort", SearchOptions{})
+	if len(candidates) == 0 || len(candidates) >= len(AllEntries) {
+		t.Fatalf("got %d candidates for %q, want some of the %d entries", len(candidates), "fer mort", len(AllEntries))
+	}
+	for _, entry := range candidates {
+		if !strings.Contains(entry.TitleNormalizedWp, "fer") || !strings.Contains(entry.TitleNormalizedWp, "mort") {
+			t.Errorf("%q is a candidate for %q", entry.Title, "fer mort")
+		}
+	}
+	if candidates := getSearchCandidates("mort", SearchOptions{Mode: SearchModeCoincident}); len(candidates) != len(AllEntries) {
+		t.Errorf("got %d candidates in another mode, want all the %d entries", len(candidates), len(AllEntries))
+	}
+}
+
+func TestGetEntryIndicesWithPrefix(t *testing.T) {
+	loadTestData(t)
+
+	// The indices are the same as those found by checking every entry in both forms.
+	for _, prefix := range []string{"", "a", "anar", "rompre el jou d", "fer", "fer el mort", "ro", "xyz"} {
+		var want []int
+		for i, entry := range AllEntries {
+			if strings.HasPrefix(entry.TitleNormalizedWpc, prefix) || strings.HasPrefix(entry.TitleNormalizedWp, prefix) {
+				want = append(want, i)
+			}
+		}
+		if got := getEntryIndicesWithPrefix(prefix); !slices.Equal(got, want) {
+			t.Errorf("getEntryIndicesWithPrefix(%q) = %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+func TestFilterSearchResultsParallel(t *testing.T) {
+	loadTestData(t)
+	previousProcs := runtime.GOMAXPROCS(4)
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(previousProcs)
+	})
+
+	// Scanning in parallel gives the same results, in the same order, as a single
+	// scan. Run it with -race, so that the race detector checks the shards.
+	tests := []struct {
+		query   string
+		options SearchOptions
+	}{
+		{query: "estirant", options: SearchOptions{Mode: SearchModeArrel}},
+		{query: "mort", options: SearchOptions{Mode: SearchModeAcabaEn}},
+		{query: "fer el mort", options: SearchOptions{Mode: SearchModeCoincident}},
+		{query: "fer el mort", options: SearchOptions{Mode: SearchModeConte, Field: SearchFieldSinonims}},
+		{query: "inexistent", options: SearchOptions{Mode: SearchModeConte, Field: SearchFieldExemples}},
+	}
+	entries := slices.Repeat(AllEntries, ParallelSearchThreshold/len(AllEntries)+1)
+	for _, test := range tests {
+		got := filterSearchResults(entries, test.query, test.options)
+		want := matchEntries(entries, newEntryMatcher(test.query, test.options))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("filterSearchResults(%q, %+v) has %d results in parallel, want the %d of a single scan", test.query, test.options, len(got), len(want))
+		}
+		if len(want) == 0 && test.query != "inexistent" {
+			t.Errorf("filterSearchResults(%q, %+v) found nothing", test.query, test.options)
+		}
+	}
+}
+
+func TestIntersectSortedInts(t *testing.T) {
+	tests := []struct {
+		a, b []int
+		want []int
+	}{
+		{a: []int{1, 3, 5, 7}, b: []int{2, 3, 4, 7, 8}, want: []int{3, 7}},
+		{a: []int{1, 2}, b: []int{3, 4}, want: nil},
+		{a: nil, b: []int{1}, want: nil},
+		{a: []int{0, 1, 2}, b: []int{0, 1, 2}, want: []int{0, 1, 2}},
+	}
+	for _, test := range tests {
+		if got := intersectSortedInts(test.a, test.b); !slices.Equal(got, test.want) {
+			t.Errorf("intersectSortedInts(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -4,38 +4,146 @@
 //   - Loading dictionary data from a gzipped JSON file.
 //   - Parsing HTML templates for rendering web pages.
 //   - Handling HTTP requests for search, letter, and concept pages.
+//   - Exporting the dictionary data.
 //   - Serving static assets such as CSS, JavaScript, and images.
 //   - Redirecting legacy URLs to their new counterparts.
 package main
 
 import (
 	"embed"
+	"errors"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	texttemplate "text/template"
 	"time"
 )
 
 const (
-	BaseCanonicalURL     = "https://dsff.uab.cat"
-	DefaultPageSize      = 10
-	SearchModeConte      = "Conté"
-	SearchModeComencaPer = "Comença per"
-	SearchModeAcabaEn    = "Acaba en"
-	SearchModeCoincident = "Coincident"
+	DefaultBaseCanonicalURL  = "https://dsff.uab.cat"
+	DefaultDataFile          = "data.json.gz"
+	DefaultPageSize          = 10
+	MinCompressedSize        = 1024 // In bytes. See gzipHandler.
+	CategoryPageSize         = 50
+	MaxSearchPageSize        = 100
+	SearchResultsCacheSize   = 1000
+	DefaultRegexpCacheSize   = 256
+	ParallelSearchThreshold  = 10000 // In entries. See filterSearchResults.
+	MaxExportPageSize        = 1000
+	MaxSitemapURLs           = 50000 // The limit of the sitemaps protocol.
+	DefaultMinQueryLength    = 2
+	DefaultSlowRequestTime   = 2 * time.Second
+	MaintenanceRetryAfter    = 10 * 60 // In seconds.
+	MaxRecentConcepts        = 5
+	MaxSuggestions           = 10
+	MaxRelatedConcepts       = 10
+	DefaultExamplesSeparator = " / "
+	EnvironmentProduction    = "production"
+	RecentConceptsCookieName = "conceptes_recents"
+	SearchModeConte          = "Conté"
+	SearchModeComencaPer     = "Comença per"
+	SearchModeAcabaEn        = "Acaba en"
+	SearchModeCoincident     = "Coincident"
+	SearchModeArrel          = "Per arrel"
+	SearchModeAproximat      = "Aproximat"
+	SearchFieldSinonims      = "sinonims"
+	SearchFieldExemples      = "exemples"
+	SearchFieldDefinicio     = "definicio"
+	GroupByConcept           = "concepte"
+	ExcludeNovetats          = "novetats"
+	WithExemples             = "exemples"
+	OrderExport              = "export"
+	RenderHTML               = "html"
+	AccentsExact             = "exacte"
+	ProbeStatusOK            = "ok"
+	ProbeStatusNotReady      = "no preparat"
+
+	DefaultOpenSearchShortName   = "DSFF"
+	DefaultOpenSearchDescription = "El Diccionari de Sinònims de Frases Fetes és un diccionari conceptual d'expressions lexicalitzades, que relaciona conceptes amb expressions lexicalitzades de naturalesa gramatical diversa, allò que en la gramàtica tradicional s'han anomenat genèricament locucions i frases fetes."
 )
 
+// Forms of a phrase that can match a search query.
+const (
+	MatchedFormNone       MatchedForm = ""
+	MatchedFormWpc        MatchedForm = "wpc"         // The phrase without parentheses content.
+	MatchedFormWp         MatchedForm = "wp"          // The phrase with parentheses content, but not without it.
+	MatchedFormStemmed    MatchedForm = "arrel"       // The stems of the phrase, in SearchModeArrel.
+	MatchedFormRelated    MatchedForm = "relacionada" // A synonym or related phrase, not the phrase itself.
+	MatchedFormExample    MatchedForm = "exemple"     // The examples of the phrase, not the phrase itself.
+	MatchedFormDefinition MatchedForm = "definicio"   // The definition of the phrase, not the phrase itself.
+)
+
+// SearchModes lists the valid search modes, in the order shown in the search form.
+var SearchModes = []string{SearchModeConte, SearchModeComencaPer, SearchModeAcabaEn, SearchModeCoincident, SearchModeArrel, SearchModeAproximat}
+
+// HealthCheckPaths lists the routes used for monitoring and by container orchestrators.
+// They keep working in maintenance mode, and are not crawled.
+var HealthCheckPaths = []string{"/salut", "/healthz", "/readyz"}
+
+// StaticAssetFiles maps the URL paths of the CSS and JS files to the files served.
+// Their versions are computed at startup, see StaticAssetVersions.
+var StaticAssetFiles = map[string]string{
+	"/main.min.css":  "public/css/main.min.css",
+	"/search.min.js": "public/js/search.min.js",
+}
+
+// StaticAssetVersions maps the URL paths of StaticAssetFiles to a short hash of their
+// contents, used to bust caches when they change. See assetURL.
+var StaticAssetVersions map[string]string
+
 // BuildDate is set at compile time to indicate when the binary was built.
 var BuildDate string
 
+// PageSizesByMode maps search modes to their default page size, when it differs from
+// DefaultPageSize. E.g. exact matches usually return few results.
+var PageSizesByMode = map[string]int{}
+
+// ExamplesSeparator separates the examples of an entry, when it has several. They
+// are rendered as a list. See splitExamples.
+var ExamplesSeparator = DefaultExamplesSeparator
+
+// BaseCanonicalURL is the absolute URL of the site, without trailing slash, used in
+// canonical URLs, the sitemap, and other absolute links. It can be set with the
+// CANONICAL_BASE_URL env variable, e.g. for staging deployments.
+var BaseCanonicalURL = DefaultBaseCanonicalURL
+
+// SlowRequestTime is the duration above which a request is logged as slow, to find
+// pathological searches. It can be set with the SLOW_REQUEST_TIME env variable, e.g.
+// "500ms", and 0 disables the log. See slowRequestMiddleware.
+var SlowRequestTime = DefaultSlowRequestTime
+
+// MinQueryLength is the minimum number of characters of a normalized search
+// query. Shorter queries produce enormous result sets and are not run.
+var MinQueryLength = DefaultMinQueryLength
+
+var (
+	NotFoundTemplate    *template.Template
+	MaintenanceTemplate *template.Template
+	GoneTemplate        *template.Template
+	MainTemplate        *template.Template
+	OpenSearchTemplate  *texttemplate.Template
+	SitemapTemplate     *texttemplate.Template
+)
+
+// OpenSearchShortName and OpenSearchDescription are used in the OpenSearch
+// description document, which lets browsers add the dictionary as a search engine.
 var (
-	NotFoundTemplate *template.Template
-	MainTemplate     *template.Template
+	OpenSearchShortName   = DefaultOpenSearchShortName
+	OpenSearchDescription = DefaultOpenSearchDescription
 )
 
 //go:embed templates/*
 var TemplateFS embed.FS
 
+// SampleData is a tiny gzipped JSON data file, used when no data file is available,
+// e.g. for demos and CI.
+//
+//go:embed sample/data.json.gz
+var SampleData []byte
+
 var (
 	// AllEntries contains all dictionary entries loaded from the data file.
 	AllEntries []Entry
@@ -43,52 +151,225 @@ var (
 	PhrasesMap map[string]bool
 	// ConceptsByFirstLetter maps initial letters to their associated concepts.
 	ConceptsByFirstLetter map[string][]string
+	// ConceptsBySlug maps concept slugs to their concepts, in their most common spelling.
+	ConceptsBySlug map[string]string
+	// ConceptSlugsByPhrase maps the phrases, normalized as TitleNormalizedWpc, to the
+	// slugs of the concepts that have them. See getRelatedConcepts.
+	ConceptSlugsByPhrase map[string][]string
+	// EntryIndicesByCategory maps the grammatical categories to the indices of their
+	// entries in AllEntries, sorted by concept and phrase. See getEntriesByCategory.
+	EntryIndicesByCategory map[string][]int
+	// EntryIndicesByWord maps the words of the normalized phrases to the indices of the
+	// entries that contain them in AllEntries, in ascending order. See getSearchCandidates.
+	EntryIndicesByWord map[string][]int
+	// PhrasesByNormalized contains the normalized phrases of the entries, in both forms,
+	// sorted, for finding those that start with a prefix by binary search. See
+	// getEntryIndicesWithPrefix.
+	PhrasesByNormalized []IndexedPhrase
+	// PhraseSuggestions contains the phrases, sorted by their normalized form, for
+	// finding those that start with a prefix by binary search. See getSuggestions.
+	PhraseSuggestions []PhraseSuggestion
+	// ConceptSuggestions contains the concepts, sorted by their normalized title, for
+	// suggesting them instead of searches without results. See getDidYouMean.
+	ConceptSuggestions []ConceptSuggestion
+	// RetiredConceptSlugs contains the slugs of concepts permanently removed from the
+	// dictionary, which are served with 410 Gone instead of 404 Not Found.
+	RetiredConceptSlugs map[string]bool
+	// DataVersion is a hash of the contents of the loaded data, so that clients can
+	// detect changes without downloading the export. See loadData.
+	DataVersion string
+	// PopularQueries are searches whose first page of results is rendered in advance
+	// after loading the data, e.g. the most frequent ones in the analytics.
+	PopularQueries []string
+)
+
+// DataLock protects the variables above that are derived from the data file, as they
+// are replaced when the data is reloaded. Requests hold a read lock until they start
+// writing the response, see dataLockMiddleware.
+var DataLock sync.RWMutex
+
+// ReloadLock serializes reloads of the data, see reloadData, so that a reload never
+// replaces the data with an older file read by another reload that started earlier.
+var ReloadLock sync.Mutex
+
+// Errors returned when loading the dictionary data, so that callers can react
+// differently to each kind of failure.
+var (
+	ErrDataMissing = errors.New("data file not found")
+	ErrDataCorrupt = errors.New("data file is corrupt")
+	ErrDataEmpty   = errors.New("data file contains no entries")
 )
 
+// ConceptURLTrailingSlash sets whether the canonical URLs of concept pages end with
+// a slash. Requests for the other form are permanently redirected, to avoid
+// duplicate content.
+var ConceptURLTrailingSlash bool
+
+// Environment is the kind of deployment, e.g. "production" or "staging", set with the
+// ENVIRONMENT env variable. Non-production deployments are not crawled, see robotsHandler.
+var Environment = EnvironmentProduction
+
+// MaintenanceMode makes the server respond with a maintenance page to all user
+// routes, e.g. during data migrations. Health checks keep working.
+var MaintenanceMode bool
+
+// ValidateData makes the server log the problems found in the data (see getDataProblems)
+// every time it is loaded, like "dsff validate" does. Set with the VALIDATE_DATA env variable.
+var ValidateData bool
+
+// SearchResultsCache holds recently rendered pages of search results. See getSearchResultsPage.
+var SearchResultsCache = newLRUCache[SearchResultsPage](SearchResultsCacheSize)
+
+// WholeWordsRegexpCache holds recently compiled regexps of newWholeWordsRegexp, as
+// popular queries repeat often. Its size is set with the REGEXP_CACHE_SIZE env variable.
+var WholeWordsRegexpCache = newLRUCache[*regexp.Regexp](DefaultRegexpCacheSize)
+
+// CookieSecret is the key used to sign cookies.
+var CookieSecret []byte
+
 func main() {
-	// Load the dictionary data from the gzipped JSON file.
-	// This populates the AllEntries, PhrasesMap, and ConceptsByFirstLetter variables.
-	err := loadDataFromFile("data.json.gz")
+	// Set up structured logging first, so that all logs use the configured format.
+	// This also applies to the log package, used by net/http.
+	slog.SetDefault(newLogger(os.Stderr))
+
+	// Run a command-line subcommand instead of the server, if one is given.
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout))
+	}
+
+	// Load the dictionary data from the file set in DATA_FILE, or data.json.gz.
+	// This populates the AllEntries, PhrasesMap, ConceptsByFirstLetter, and
+	// ConceptsBySlug variables.
+	err := loadDataFromFileOrSample()
+	if errors.Is(err, ErrDataEmpty) {
+		// An empty dataset is not fatal, but the site is useless without entries:
+		// every search returns nothing. Make it visible in the logs and in /salut.
+		slog.Warn("All searches will return nothing", "error", err)
+	} else if errors.Is(err, ErrDataMissing) {
+		slog.Error("Data file not found, set DATA_FILE to its path", "error", err)
+		os.Exit(1)
+	} else if err != nil {
+		slog.Error("Failed to load data", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("Loaded data", "entries", len(AllEntries), "letters", len(ConceptsByFirstLetter))
+
+	ValidateData = getValidateData()
+	if ValidateData {
+		logDataProblems()
+	}
+
+	RetiredConceptSlugs, err = loadRetiredConceptSlugs(os.Getenv("RETIRED_CONCEPTS_FILE"))
 	if err != nil {
-		log.Fatalf("Failed to load data: %v", err)
+		slog.Error("Failed to load retired concepts", "error", err)
+		os.Exit(1)
 	}
 
-	log.Printf("Loaded %d entries, covering %d initial letters.\n",
-		len(AllEntries), len(ConceptsByFirstLetter))
+	BaseCanonicalURL = getBaseCanonicalURL()
+	MinQueryLength = getMinQueryLength()
+	WholeWordsRegexpCache = newLRUCache[*regexp.Regexp](getRegexpCacheSize())
+	SlowRequestTime = getSlowRequestTime()
+	StaticAssetVersions = getStaticAssetVersions(StaticAssetFiles)
+	checkPrecompressedFiles(StaticAssetFiles)
+	PageSizesByMode = getPageSizesByMode()
+	ConceptURLTrailingSlash = getConceptURLTrailingSlash()
+	MaintenanceMode = getMaintenanceMode()
+	if MaintenanceMode {
+		slog.Warn("Maintenance mode is on")
+	}
+	CookieSecret = getCookieSecret()
+	OpenSearchShortName = getEnvOrDefault("OPENSEARCH_SHORT_NAME", DefaultOpenSearchShortName)
+	OpenSearchDescription = getEnvOrDefault("OPENSEARCH_DESCRIPTION", DefaultOpenSearchDescription)
+	ExamplesSeparator = getEnvOrDefault("EXAMPLES_SEPARATOR", DefaultExamplesSeparator)
+	Environment = getEnvOrDefault("ENVIRONMENT", EnvironmentProduction)
+
+	PopularQueries, err = loadPopularQueries(os.Getenv("POPULAR_QUERIES_FILE"))
+	if err != nil {
+		slog.Error("Failed to load popular queries", "error", err)
+		os.Exit(1)
+	}
 
 	// Parse the HTML templates from the embedded filesystem.
-	MainTemplate = template.Must(template.New("main.html").ParseFS(TemplateFS, "templates/main.html"))
-	NotFoundTemplate = template.Must(template.New("404.html").ParseFS(TemplateFS, "templates/404.html"))
+	parseTemplates()
+
+	// Render the results of popular queries in the background, so that startup is
+	// not delayed. This needs the configuration above, as it affects rendering.
+	go warmSearchResultsCache(PopularQueries)
 
-	// Create a new ServeMux to handle HTTP requests.
+	// Reload the data on SIGHUP, so that updates do not need a restart.
+	go reloadDataOnSignal()
+
+	serverAddress := getServerAddress()
+	server := &http.Server{
+		Addr:         serverAddress,
+		Handler:      requestLoggerMiddleware(slowRequestMiddleware(maintenanceMiddleware(dataLockMiddleware(newServeMux())))),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	slog.Info("Server started", "address", serverAddress)
+	err = server.ListenAndServe()
+	slog.Error("Server stopped", "error", err)
+	os.Exit(1)
+}
+
+// newServeMux creates the ServeMux with the handlers of all the routes.
+func newServeMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	// Register handlers for the main application routes.
-	mux.HandleFunc("GET /", searchHandler)
-	mux.HandleFunc("GET /lletra/{letter}", letterHandler)
-	mux.HandleFunc("GET /concepte/{concept}", conceptHandler)
-	mux.HandleFunc("GET /abreviatures", basicPageHandler("Abreviatures"))
-	mux.HandleFunc("GET /coneix", basicPageHandler("Coneix el diccionari"))
-	mux.HandleFunc("GET /credits", basicPageHandler("Crèdits"))
-	mux.HandleFunc("GET /presentacio", basicPageHandler("Presentació"))
+	// Register handlers for the main application routes. Search and concept pages can
+	// be large, so pages are compressed, in case no proxy in front does it.
+	mux.HandleFunc("GET /{$}", gzipHandler(searchHandler))
+	mux.HandleFunc("GET /resultats", gzipHandler(searchFragmentHandler))
+	mux.HandleFunc("GET /lletra/{letter}", gzipHandler(letterHandler))
+	mux.HandleFunc("GET /lletra/{letter}/text", gzipHandler(letterTextHandler))
+	mux.HandleFunc("GET /categoria/{key}", gzipHandler(categoryHandler))
+	mux.HandleFunc("GET /concepte/{concept}", gzipHandler(conceptHandler))
+	// Match only a single trailing slash, as a pattern ending in a slash matches every
+	// path under it. See ConceptURLTrailingSlash.
+	mux.HandleFunc("GET /concepte/{concept}/{$}", gzipHandler(conceptHandler))
+	mux.HandleFunc("GET /abreviatures", gzipHandler(basicPageHandler("Abreviatures")))
+	mux.HandleFunc("GET /coneix", gzipHandler(basicPageHandler("Coneix el diccionari")))
+	mux.HandleFunc("GET /credits", gzipHandler(basicPageHandler("Crèdits")))
+	mux.HandleFunc("GET /presentacio", gzipHandler(basicPageHandler("Presentació")))
+
+	// Register handlers for exporting the dictionary data.
+	mux.HandleFunc("GET /export.json", exportJSONHandler)
+	mux.HandleFunc("GET /export.csv", exportCSVHandler)
+
+	// Register handlers for health checks, and for the liveness and readiness probes.
+	mux.HandleFunc("GET /salut", healthHandler)
+	mux.HandleFunc("GET /healthz", livenessHandler)
+	mux.HandleFunc("GET /readyz", readinessHandler)
+
+	// Register handlers for the API. JSON responses can be large, so they are compressed.
+	mux.HandleFunc("GET /api/cerca", gzipHandler(apiSearchHandler))
+	mux.HandleFunc("GET /api/suggeriments", gzipHandler(suggestionsHandler))
+	mux.HandleFunc("GET /api/suggeriments/opensearch", gzipHandler(openSearchSuggestionsHandler))
+	mux.HandleFunc("GET /api/concepte/{concept}", gzipHandler(apiConceptHandler))
+	mux.HandleFunc("GET /api/sinonims", gzipHandler(synonymsHandler))
+	mux.HandleFunc("GET /api/posicio", gzipHandler(searchPositionHandler))
+	mux.HandleFunc("GET /api/index", gzipHandler(letterIndexHandler))
+	mux.HandleFunc("GET /api/conceptes", gzipHandler(conceptsByLetterHandler))
+	mux.HandleFunc("GET /api/compara", gzipHandler(compareConceptsHandler))
+	mux.HandleFunc("GET /api/versio", dataVersionHandler)
 
 	// Register handlers for serving static files.
 	// These are handled individually to avoid showing the annoying default
 	// directory file listing.
 	//
-	// TODO:
-	//  - Set long cache headers for static assets (JS, CSS, images). But then:
-	//  - Append cache-busting query strings or version hashes to CSS/JS URLs.
-	//
-	// Default browser cache behaviour, although unpredictable, is acceptable. So
-	// this may stay as it is. File sizes are also relatively small.
-	mux.HandleFunc("GET /main.min.css", precompressedFileHandler("public/css/main.min.css", "text/css"))
-	mux.HandleFunc("GET /search.min.js", precompressedFileHandler("public/js/search.min.js", "application/javascript"))
+	// CSS and JS are linked with their version (see assetURL), so they are cached
+	// for a long time. Images rarely change, so they keep the default browser
+	// cache behaviour, which, although unpredictable, is acceptable.
+	mux.HandleFunc("GET /main.min.css", precompressedFileHandler(StaticAssetFiles["/main.min.css"], "text/css"))
+	mux.HandleFunc("GET /search.min.js", precompressedFileHandler(StaticAssetFiles["/search.min.js"], "application/javascript"))
 	mux.Handle("GET /by-nc-sa.svg", http.FileServer(http.Dir("public/img/")))
 	mux.Handle("GET /uab.svg", http.FileServer(http.Dir("public/img/")))
 	mux.Handle("GET /favicon.ico", http.FileServer(http.Dir("public/")))
-	mux.Handle("GET /opensearch.xml", http.FileServer(http.Dir("public/")))
-	mux.Handle("GET /robots.txt", http.FileServer(http.Dir("public/")))
+	mux.HandleFunc("GET /opensearch.xml", gzipHandler(openSearchHandler))
+	mux.HandleFunc("GET /sitemap.xml", gzipHandler(sitemapHandler))
+	mux.HandleFunc("GET /robots.txt", robotsHandler)
 
 	// Handle legacy /cerca URL by redirecting to the homepage.
 	// This ensures that old bookmarks and search engine links continue to work.
@@ -100,14 +381,9 @@ func main() {
 		http.Redirect(w, r, redirectURL, http.StatusMovedPermanently)
 	})
 
-	serverAddress := getServerAddress()
-	server := &http.Server{
-		Addr:         serverAddress,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
-	log.Println("Server started at", serverAddress)
-	log.Fatal(server.ListenAndServe())
+	// Serve a 404 page for any other path. Only genuinely unknown paths get here,
+	// as the homepage is matched exactly.
+	mux.HandleFunc("/", serveNotFound)
+
+	return mux
 }
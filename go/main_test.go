@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestEntries is a small data file with a few concepts, written to cover the cases
+// that the tests check: phrases that differ only in their parentheses content, the
+// same phrase in several concepts, concepts that differ only in casing or accents,
+// homographs, and lists of phrases with commas.
+//
+//go:embed testdata/entries.json
+var TestEntries []byte
+
+// loadTestData loads TestEntries as the dictionary data.
+func loadTestData(t testing.TB) {
+	t.Helper()
+	err := loadDataFromFile(writeGzippedTestFile(t, TestEntries))
+	if err != nil {
+		t.Fatalf("loading test data: %v", err)
+	}
+}
+
+// setTestEntries loads the given entries as the dictionary data, as if they were read
+// from a data file, so that the lookup structures are derived from them.
+func setTestEntries(t testing.TB, entries []Entry) {
+	t.Helper()
+	content, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatalf("encoding test entries: %v", err)
+	}
+	err = loadDataFromFile(writeGzippedTestFile(t, content))
+	if err != nil {
+		t.Fatalf("loading test entries: %v", err)
+	}
+}
+
+// newTestEntry returns an entry of the concept, with the phrase normalized as in the export.
+func newTestEntry(concept, title string) Entry {
+	normalizedTitle := normalizePhrase(title)
+	return Entry{
+		Title:              title,
+		TitleNormalizedWp:  normalizedTitle.Wp,
+		TitleNormalizedWpc: normalizedTitle.Wpc,
+		Concepte:           concept,
+		Categoria:          "sv",
+	}
+}
+
+// writeGzippedTestFile writes the content, gzipped, to a file in a temporary
+// directory, and returns its path.
+func writeGzippedTestFile(t testing.TB, content []byte) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	_, _ = gzipWriter.Write(content)
+	_ = gzipWriter.Close()
+	return writeTestFile(t, buffer.Bytes())
+}
+
+// writeTestFile writes the content to a file in a temporary directory, and returns its path.
+func writeTestFile(t testing.TB, content []byte) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "data.json.gz")
+	err := os.WriteFile(filePath, content, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+// serveTestRequest serves a GET request for the target URL with the handler, and
+// returns the response.
+func serveTestRequest(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+// hasEntry reports whether a rendered page has an entry for the phrase, rather than
+// only mentions of it, e.g. in the synonyms of other entries. Highlighted matches of
+// the query are ignored.
+func hasEntry(body, phrase string) bool {
+	return strings.Contains(removeHighlights(body), "<strong>"+phrase+"</strong></a> <em>")
+}
+
+// removeHighlights removes the <mark> tags of the matches of the query from a rendered
+// page, so that tests can look for phrases as they are written.
+func removeHighlights(body string) string {
+	return strings.NewReplacer("<mark>", "", "</mark>", "").Replace(body)
+}
+
+// captureLogs makes the default logger write JSON records to the returned buffer until
+// the end of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previousLogger := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previousLogger)
+	})
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	return &logs
+}
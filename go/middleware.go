@@ -0,0 +1,330 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenanceMiddleware serves a 503 maintenance page for all requests while
+// MaintenanceMode is on, except for health checks (see HealthCheckPaths), which
+// keep reporting the state of the server.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !MaintenanceMode || slices.Contains(HealthCheckPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Retry-After", strconv.Itoa(MaintenanceRetryAfter))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		err := MaintenanceTemplate.Execute(w, nil)
+		if err != nil {
+			serveInternalError(w, r, err)
+		}
+	})
+}
+
+// requestLoggerMiddleware assigns an ID to each request, and stores a logger with the
+// request context (ID, path, and query) in the context of the request, for
+// getRequestLogger. The ID is also returned in the X-Request-ID header, so that users
+// can report it.
+//
+// Additionally:
+//   - Logs each request once it has been served, with its method, status code, number
+//     of bytes written (compressed, if so), and duration
+//   - Logs health checks at the debug level, as they are frequent and uninteresting
+func requestLoggerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		requestID := newRequestID()
+		w.Header().Set("X-Request-ID", requestID)
+
+		logger := slog.Default().With(
+			"request_id", requestID,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+		)
+		ctx := context.WithValue(r.Context(), loggerContextKey{}, logger)
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if slices.Contains(HealthCheckPaths, r.URL.Path) {
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "Served request",
+			"method", r.Method,
+			"status", recorder.getStatusCode(),
+			"bytes", recorder.bytesWritten,
+			"duration", time.Since(startTime),
+		)
+	})
+}
+
+// slowRequestMiddleware logs a warning for requests that take longer than
+// SlowRequestTime to be served, with the request ID, path, and query from
+// getRequestLogger, so that pathological searches can be found. It does not stop slow
+// requests, which is left to the server timeouts. Note that the duration includes
+// sending the response, which is slow for large responses to slow clients.
+func slowRequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		next.ServeHTTP(w, r)
+
+		duration := time.Since(startTime)
+		if SlowRequestTime > 0 && duration > SlowRequestTime {
+			getRequestLogger(r).Warn("Slow request", "duration", duration, "threshold", SlowRequestTime)
+		}
+	})
+}
+
+// responseRecorder records the status code and the number of bytes of a response,
+// which http.ResponseWriter does not expose, for logging. See requestLoggerMiddleware.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode   int
+	bytesWritten int
+}
+
+func (w *responseRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytesWritten += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// getStatusCode returns the status code of the response. Handlers that write nothing
+// respond with 200 OK.
+func (w *responseRecorder) getStatusCode() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
+
+// getRequestLogger returns the logger stored by requestLoggerMiddleware, or the
+// default logger if there is none.
+func getRequestLogger(r *http.Request) *slog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return logger
+}
+
+// newRequestID returns a random ID to identify a request in the logs.
+func newRequestID() string {
+	randomBytes := make([]byte, 8)
+	_, _ = rand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
+
+// dataLockMiddleware holds a read lock of DataLock while each request is handled, so that
+// each request uses the data of a single load, even if the data is reloaded meanwhile.
+//
+// Additionally:
+//   - The lock is released as soon as the handler starts writing the response, so that
+//     a slow client does not block reloading the data, nor every other request while
+//     a reload waits for the lock. See dataLockResponseWriter
+//   - Handlers that stream long responses can release it earlier, see releaseDataLock
+//
+// Preconditions:
+//   - Handlers must get all they need from the data before writing the response, as
+//     with releaseDataLock
+func dataLockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		DataLock.RLock()
+		release := sync.OnceFunc(DataLock.RUnlock)
+		defer release()
+
+		ctx := context.WithValue(r.Context(), dataLockContextKey{}, release)
+		next.ServeHTTP(&dataLockResponseWriter{ResponseWriter: w, release: release}, r.WithContext(ctx))
+	})
+}
+
+// dataLockResponseWriter releases the read lock of DataLock held for a request before
+// anything is written to the client. See dataLockMiddleware.
+type dataLockResponseWriter struct {
+	http.ResponseWriter
+	release func() // Releases the read lock. It is safe to call several times.
+}
+
+func (w *dataLockResponseWriter) WriteHeader(statusCode int) {
+	w.release()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *dataLockResponseWriter) Write(data []byte) (int, error) {
+	w.release()
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for http.ResponseController.
+func (w *dataLockResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// releaseDataLock releases the read lock of DataLock held for a request, so that
+// reloading the data does not wait for the request to finish, e.g. a slow download.
+// The handler must not read the global variables derived from the data afterwards,
+// but it can keep using slices and entries that it got before, as reloading the data
+// replaces them rather than modifying them. Writing the response releases it too,
+// see dataLockMiddleware.
+func releaseDataLock(r *http.Request) {
+	release, ok := r.Context().Value(dataLockContextKey{}).(func())
+	if ok {
+		release()
+	}
+}
+
+// gzipHandler compresses the responses of a handler with gzip when the client accepts
+// it. Unlike precompressedFileHandler, compression happens at runtime, so it is meant
+// for dynamic responses that can be large, such as HTML pages and those of the JSON
+// API. The Content-Type set by the handler is kept.
+//
+// The compressed body is a different representation than the uncompressed one, so its
+// ETag, if any, gets a "-gzip" suffix. The suffix is removed from the If-None-Match
+// header of the request, so that the handler can compare it with its own ETag.
+//
+// Brotli is not offered, as the standard library has no encoder for it.
+func gzipHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, "gzip") || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		if ifNoneMatch != "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("If-None-Match", strings.ReplaceAll(ifNoneMatch, `-gzip"`, `"`))
+		}
+
+		gzipWriter := &gzipResponseWriter{ResponseWriter: w, ifNoneMatch: ifNoneMatch}
+		defer gzipWriter.Close()
+		next(gzipWriter, r)
+	}
+}
+
+// gzipResponseWriter compresses the body of a response with gzip. The start of the
+// body is buffered, and responses shorter than MinCompressedSize are sent as they are,
+// as compressing them saves little. Responses without a body, such as 304 Not
+// Modified, and responses already encoded by the handler are not compressed either.
+// See gzipHandler.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gzipWriter  *gzip.Writer
+	ifNoneMatch string // The If-None-Match header of the request, with the ETag suffixes.
+	statusCode  int    // The status code set by the handler, or 0 if none yet.
+	buffer      []byte // The start of the body, until it is known whether to compress it.
+	wroteHeader bool   // Whether the header has been sent to the client.
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode != 0 {
+		return
+	}
+	w.statusCode = statusCode
+
+	// Send the header right away if there is no body to compress.
+	if statusCode == http.StatusNotModified || statusCode == http.StatusNoContent {
+		w.sendHeader(false)
+	}
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.wroteHeader {
+		if w.gzipWriter == nil {
+			return w.ResponseWriter.Write(data)
+		}
+		return w.gzipWriter.Write(data)
+	}
+
+	w.buffer = append(w.buffer, data...)
+	if len(w.buffer) >= MinCompressedSize {
+		err := w.flushBuffer(true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// sendHeader sends the header to the client, setting the Content-Encoding and the ETag
+// suffix if the body is compressed.
+func (w *gzipResponseWriter) sendHeader(compress bool) {
+	w.wroteHeader = true
+	etag := w.Header().Get("ETag")
+	gzipETag := strings.TrimSuffix(etag, `"`) + `-gzip"`
+	if compress && w.Header().Get("Content-Encoding") == "" {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+		if strings.HasSuffix(etag, `"`) {
+			w.Header().Set("ETag", gzipETag)
+		}
+		w.gzipWriter = gzip.NewWriter(w.ResponseWriter)
+	} else if w.statusCode == http.StatusNotModified && strings.HasSuffix(etag, `"`) &&
+		strings.Contains(w.ifNoneMatch, gzipETag) {
+		// A 304 Not Modified response refers to the representation the client has.
+		w.Header().Set("ETag", gzipETag)
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode)
+}
+
+// flushBuffer sends the header and the buffered start of the body, compressed or not.
+func (w *gzipResponseWriter) flushBuffer(compress bool) error {
+	w.sendHeader(compress)
+	buffer := w.buffer
+	w.buffer = nil
+	if w.gzipWriter == nil {
+		_, err := w.ResponseWriter.Write(buffer)
+		return err
+	}
+	_, err := w.gzipWriter.Write(buffer)
+	return err
+}
+
+// Close sends the response if it is shorter than MinCompressedSize, and otherwise
+// writes the remaining compressed data to it.
+func (w *gzipResponseWriter) Close() error {
+	if !w.wroteHeader && w.statusCode != 0 {
+		err := w.flushBuffer(false)
+		if err != nil {
+			return err
+		}
+	}
+	if w.gzipWriter == nil {
+		return nil
+	}
+	return w.gzipWriter.Close()
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousMaintenanceMode := MaintenanceMode
+	t.Cleanup(func() {
+		MaintenanceMode = previousMaintenanceMode
+	})
+	MaintenanceMode = true
+	handler := maintenanceMiddleware(newServeMux())
+
+	for _, path := range []string{"/", "/?frase=fer+el+mort", "/concepte/callar", "/lletra/C", "/api/sinonims?frase=fer+el+mort", "/export.json", "/no-existeix"} {
+		response := serveTestRequest(handler.ServeHTTP, path)
+		if response.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s = %d in maintenance mode, want %d", path, response.Code, http.StatusServiceUnavailable)
+		}
+		if response.Header().Get("Retry-After") != strconv.Itoa(MaintenanceRetryAfter) {
+			t.Errorf("GET %s has Retry-After %q, want %d", path, response.Header().Get("Retry-After"), MaintenanceRetryAfter)
+		}
+		if response.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Errorf("GET %s has Content-Type %q, want an explicit HTML charset", path, response.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(response.Body.String(), "En manteniment") {
+			t.Errorf("GET %s does not show the maintenance page", path)
+		}
+	}
+
+	for _, path := range HealthCheckPaths {
+		response := serveTestRequest(handler.ServeHTTP, path)
+		if response.Code != http.StatusOK {
+			t.Errorf("GET %s = %d in maintenance mode, want %d", path, response.Code, http.StatusOK)
+		}
+	}
+
+	MaintenanceMode = false
+	response := serveTestRequest(handler.ServeHTTP, "/concepte/callar")
+	if response.Code != http.StatusOK {
+		t.Errorf("GET /concepte/callar = %d out of maintenance mode, want %d", response.Code, http.StatusOK)
+	}
+}
+
+// logRecord is a JSON record logged by requestLoggerMiddleware, or by a handler with
+// getRequestLogger.
+type logRecord struct {
+	Level     string `json:"level"`
+	Message   string `json:"msg"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
+	Path      string `json:"path"`
+	Query     string `json:"query"`
+	Method    string `json:"method"`
+	Status    int    `json:"status"`
+	Bytes     int    `json:"bytes"`
+	Threshold int64  `json:"threshold"`
+}
+
+// decodeLogRecords decodes the JSON records written to a buffer by captureLogs.
+func decodeLogRecords(t *testing.T, logs *bytes.Buffer) []logRecord {
+	t.Helper()
+	var records []logRecord
+	decoder := json.NewDecoder(logs)
+	for decoder.More() {
+		var record logRecord
+		err := decoder.Decode(&record)
+		if err != nil {
+			t.Fatalf("logged %q, want JSON records: %v", logs.String(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRequestLoggerMiddlewareLogsRequests(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	logs := captureLogs(t)
+	handler := requestLoggerMiddleware(newServeMux())
+
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{target: "/?frase=mort", wantStatus: http.StatusOK},
+		{target: "/concepte/no_existeix", wantStatus: http.StatusNotFound},
+		{target: "/concepte/CALLAR", wantStatus: http.StatusMovedPermanently},
+	}
+	for _, test := range tests {
+		response := serveTestRequest(handler.ServeHTTP, test.target)
+		records := decodeLogRecords(t, logs)
+		if len(records) != 1 {
+			t.Errorf("GET %s logged %d records, want 1", test.target, len(records))
+			continue
+		}
+		record := records[0]
+		if record.Level != "INFO" || record.Message != "Served request" || record.Method != http.MethodGet {
+			t.Errorf("GET %s logged %+v, want an info record of the request", test.target, record)
+		}
+		if record.Status != test.wantStatus || record.Bytes != response.Body.Len() {
+			t.Errorf("GET %s logged status %d and %d bytes, want %d and %d", test.target, record.Status, record.Bytes,
+				test.wantStatus, response.Body.Len())
+		}
+		if record.RequestID != response.Header().Get("X-Request-ID") {
+			t.Errorf("GET %s logged request ID %q, want %q", test.target, record.RequestID, response.Header().Get("X-Request-ID"))
+		}
+	}
+
+	// Health checks are logged at the debug level, which is not enabled by default.
+	for _, path := range HealthCheckPaths {
+		serveTestRequest(handler.ServeHTTP, path)
+		if logs.Len() != 0 {
+			t.Errorf("GET %s logged %q, want nothing at the info level", path, logs.String())
+		}
+	}
+}
+
+func TestRequestLoggerMiddlewareLogsErrors(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	t.Cleanup(parseTemplates)
+	logs := captureLogs(t)
+	handler := requestLoggerMiddleware(newServeMux())
+
+	// Break the main template, so that rendering the page fails.
+	MainTemplate = template.Must(template.New("main.html").Parse("{{ .NoSuchField }}"))
+	response := serveTestRequest(handler.ServeHTTP, "/?frase=mort")
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("GET /?frase=mort = %d, want %d", response.Code, http.StatusInternalServerError)
+	}
+
+	records := decodeLogRecords(t, logs)
+	if len(records) != 2 {
+		t.Fatalf("logged %q, want an error record and a record of the request", logs.String())
+	}
+	record := records[0]
+	if record.Level != "ERROR" || !strings.Contains(record.Error, "NoSuchField") {
+		t.Errorf("logged %+v, want an error record with the error", record)
+	}
+	if record.RequestID == "" || record.RequestID != response.Header().Get("X-Request-ID") {
+		t.Errorf("logged request ID %q, want the X-Request-ID header %q", record.RequestID, response.Header().Get("X-Request-ID"))
+	}
+	if record.Path != "/" || record.Query != "frase=mort" {
+		t.Errorf("logged path %q and query %q, want %q and %q", record.Path, record.Query, "/", "frase=mort")
+	}
+	if records[1].RequestID != record.RequestID || records[1].Status != http.StatusInternalServerError {
+		t.Errorf("logged %+v, want a record of the failed request", records[1])
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	mux := newServeMux()
+
+	serveRequest := func(target, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		if acceptEncoding != "" {
+			request.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// The compressed response has the same content and headers as the uncompressed one.
+	plainResponse := serveRequest("/api/cerca?frase=fer", "", "")
+	response := serveRequest("/api/cerca?frase=fer", "br, gzip", "")
+	if response.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want %q", response.Header().Get("Content-Encoding"), "gzip")
+	}
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", contentType, "application/json")
+	}
+	for _, recorder := range []*httptest.ResponseRecorder{plainResponse, response} {
+		if vary := recorder.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want %q", vary, "Accept-Encoding")
+		}
+	}
+	gzipReader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gzipReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, plainResponse.Body.Bytes()) {
+		t.Errorf("decompressed body = %q, want %q", body, plainResponse.Body.String())
+	}
+
+	// The JSON API and the HTML pages are compressed, if the client accepts it and they
+	// are at least MinCompressedSize. The exports are not compressed.
+	tests := []struct {
+		target         string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"/api/cerca?frase=fer", "gzip", "gzip"},
+		{"/api/cerca?frase=fer", "gzip;q=0", ""},
+		{"/api/cerca?frase=fer", "deflate", ""},
+		{"/api/sinonims?frase=fer+el+mort", "gzip", ""},
+		{"/export.json", "gzip", ""},
+		{"/export.csv", "gzip", ""},
+		{"/concepte/callar", "gzip", "gzip"},
+		{"/?frase=mort", "gzip", "gzip"},
+		{"/lletra/C", "gzip", "gzip"},
+		{"/categoria/sv", "gzip", "gzip"},
+		{"/credits", "gzip", "gzip"},
+		{"/concepte/CALLAR", "gzip", ""},
+	}
+	for _, test := range tests {
+		encoding := serveRequest(test.target, test.acceptEncoding, "").Header().Get("Content-Encoding")
+		if encoding != test.wantEncoding {
+			t.Errorf("GET %s with Accept-Encoding %q: Content-Encoding = %q, want %q", test.target, test.acceptEncoding, encoding, test.wantEncoding)
+		}
+	}
+}
+
+func TestGzipHandlerBuffering(t *testing.T) {
+	tests := []struct {
+		name            string
+		encoding        string // Content-Encoding set by the handler.
+		writes          []int  // Sizes of the writes of the handler.
+		wantCompression bool
+	}{
+		{name: "empty", wantCompression: false},
+		{name: "short", writes: []int{MinCompressedSize - 1}, wantCompression: false},
+		{name: "long", writes: []int{MinCompressedSize}, wantCompression: true},
+		{name: "long, in short writes", writes: []int{100, MinCompressedSize - 200, 100, 1000}, wantCompression: true},
+		{name: "already encoded", encoding: "br", writes: []int{2 * MinCompressedSize}, wantCompression: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var want []byte
+			handler := gzipHandler(func(w http.ResponseWriter, r *http.Request) {
+				if test.encoding != "" {
+					w.Header().Set("Content-Encoding", test.encoding)
+				}
+				for i, size := range test.writes {
+					data := bytes.Repeat([]byte{byte('a' + i)}, size)
+					want = append(want, data...)
+					_, _ = w.Write(data)
+				}
+			})
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+			response := httptest.NewRecorder()
+			handler(response, request)
+
+			body := response.Body.Bytes()
+			isCompressed := response.Header().Get("Content-Encoding") == "gzip"
+			if isCompressed != test.wantCompression {
+				t.Fatalf("compressed = %t, want %t", isCompressed, test.wantCompression)
+			}
+			if isCompressed {
+				gzipReader, err := gzip.NewReader(response.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err = io.ReadAll(gzipReader)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if response.Code != http.StatusOK || !bytes.Equal(body, want) {
+				t.Errorf("response = %d with %d bytes, want %d with %d bytes", response.Code, len(body), http.StatusOK, len(want))
+			}
+			if test.encoding != "" && response.Header().Get("Content-Encoding") != test.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", response.Header().Get("Content-Encoding"), test.encoding)
+			}
+		})
+	}
+}
+
+func TestGzipHandlerETag(t *testing.T) {
+	loadTestData(t)
+	parseTemplates()
+	previousBuildDate := BuildDate
+	t.Cleanup(func() {
+		BuildDate = previousBuildDate
+	})
+	BuildDate = "2025-01-01"
+	mux := newServeMux()
+
+	serveRequest := func(target, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		request.Header.Set("If-None-Match", ifNoneMatch)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// Each representation has its own ETag.
+	plainETag := serveRequest("/concepte/callar", "", "").Header().Get("ETag")
+	gzipETag := serveRequest("/concepte/callar", "gzip", "").Header().Get("ETag")
+	if plainETag == "" || gzipETag != strings.TrimSuffix(plainETag, `"`)+`-gzip"` {
+		t.Fatalf("ETags = %q and %q, want the second with a -gzip suffix", plainETag, gzipETag)
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		ifNoneMatch    string
+		wantCode       int
+		wantETag       string
+	}{
+		{"gzip", gzipETag, http.StatusNotModified, gzipETag},
+		{"", plainETag, http.StatusNotModified, plainETag},
+		{"", gzipETag, http.StatusOK, plainETag},
+		{"gzip", `"other", ` + gzipETag, http.StatusNotModified, gzipETag},
+	}
+	for _, test := range tests {
+		response := serveRequest("/concepte/callar", test.acceptEncoding, test.ifNoneMatch)
+		if response.Code != test.wantCode || response.Header().Get("ETag") != test.wantETag {
+			t.Errorf("GET /concepte/callar with Accept-Encoding %q and If-None-Match %q = %d with ETag %q, want %d with %q",
+				test.acceptEncoding, test.ifNoneMatch, response.Code, response.Header().Get("ETag"), test.wantCode, test.wantETag)
+		}
+		if test.wantCode == http.StatusNotModified && response.Header().Get("Content-Encoding") != "" {
+			t.Errorf("304 response has Content-Encoding %q, want none", response.Header().Get("Content-Encoding"))
+		}
+	}
+
+	// Responses shorter than MinCompressedSize are sent uncompressed, with the ETag of
+	// the uncompressed representation, also when they are not modified.
+	smallETag := serveRequest("/api/index", "gzip", "").Header().Get("ETag")
+	if smallETag == "" || strings.HasSuffix(smallETag, `-gzip"`) {
+		t.Fatalf("ETag of an uncompressed response = %q, want one without a suffix", smallETag)
+	}
+	response := serveRequest("/api/index", "gzip", smallETag)
+	if response.Code != http.StatusNotModified || response.Header().Get("ETag") != smallETag {
+		t.Errorf("GET /api/index with its ETag = %d with ETag %q, want %d with %q",
+			response.Code, response.Header().Get("ETag"), http.StatusNotModified, smallETag)
+	}
+}
+
+func TestDataLockMiddleware(t *testing.T) {
+	// isLocked reports whether a reload would have to wait for the request.
+	isLocked := func() bool {
+		if DataLock.TryLock() {
+			DataLock.Unlock()
+			return false
+		}
+		return true
+	}
+
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+	}{
+		{name: "Write", write: func(w http.ResponseWriter) { _, _ = io.WriteString(w, "ok") }},
+		{name: "WriteHeader", write: func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotModified) }},
+	}
+	for _, test := range tests {
+		var lockedBeforeWrite, lockedAfterWrite bool
+		handler := dataLockMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lockedBeforeWrite = isLocked()
+			test.write(w)
+			lockedAfterWrite = isLocked()
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if !lockedBeforeWrite {
+			t.Errorf("DataLock was not held before %s", test.name)
+		}
+		if lockedAfterWrite {
+			t.Errorf("DataLock was still held after %s", test.name)
+		}
+	}
+
+	// Handlers can release the lock before writing, e.g. the export.
+	var lockedAfterRelease bool
+	handler := dataLockMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		releaseDataLock(r)
+		lockedAfterRelease = isLocked()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if lockedAfterRelease {
+		t.Error("DataLock was still held after releaseDataLock")
+	}
+	if isLocked() {
+		t.Error("DataLock was still held after the request")
+	}
+
+}
+
+func TestSlowRequestMiddleware(t *testing.T) {
+	previousSlowRequestTime := SlowRequestTime
+	t.Cleanup(func() {
+		SlowRequestTime = previousSlowRequestTime
+	})
+	logs := captureLogs(t)
+
+	tests := []struct {
+		name            string
+		slowRequestTime time.Duration
+		handlerTime     time.Duration
+		wantLog         bool
+	}{
+		{name: "above the threshold", slowRequestTime: 10 * time.Millisecond, handlerTime: 30 * time.Millisecond, wantLog: true},
+		{name: "below the threshold", slowRequestTime: time.Second, handlerTime: 0, wantLog: false},
+		{name: "disabled", slowRequestTime: 0, handlerTime: 30 * time.Millisecond, wantLog: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SlowRequestTime = test.slowRequestTime
+			handler := requestLoggerMiddleware(slowRequestMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(test.handlerTime)
+				_, _ = io.WriteString(w, "ok")
+			})))
+
+			response := serveTestRequest(handler.ServeHTTP, "/?frase=mort")
+			if response.Body.String() != "ok" {
+				t.Errorf("response = %q, want %q", response.Body.String(), "ok")
+			}
+
+			var slowRecords []logRecord
+			for _, record := range decodeLogRecords(t, logs) {
+				if record.Message == "Slow request" {
+					slowRecords = append(slowRecords, record)
+				}
+			}
+			if gotLog := len(slowRecords) > 0; gotLog != test.wantLog {
+				t.Fatalf("logged a slow request = %t, want %t", gotLog, test.wantLog)
+			}
+			if test.wantLog {
+				record := slowRecords[0]
+				if record.Level != "WARN" || time.Duration(record.Threshold) != test.slowRequestTime {
+					t.Errorf("logged %+v, want a warning with the threshold %s", record, test.slowRequestTime)
+				}
+				if record.RequestID != response.Header().Get("X-Request-ID") || record.Query != "frase=mort" {
+					t.Errorf("logged %+v, want the request ID and the query", record)
+				}
+			}
+		})
+	}
+}
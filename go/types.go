@@ -1,6 +1,9 @@
 package main
 
-import "html/template"
+import (
+	"html/template"
+	"net/url"
+)
 
 // Represents a dictionary entry.
 // See Drupal export at preprocessNodeJson() in
@@ -23,6 +26,196 @@ type Entry struct {
 	VariantsDialectals string `json:"variants_dialectals"`  // Optional: list of dialectal variants.
 	MarcatgeDialectal  string `json:"marcatge_dialectal"`   // Optional: dialectal information of the phrase.
 	Observacions       string `json:"observacions"`         // Optional: miscellaneous observations.
+	Changed            int64  `json:"changed,omitempty"`    // Optional: Unix timestamp of the last update of the entry.
+
+	// Computed at load time, not part of the export.
+	TitleStemmed             string             `json:"-"` // The phrase without parentheses content, with its words stemmed. See stemPhrase.
+	RelatedPhrasesNormalized []NormalizedPhrase `json:"-"` // Phrases in Sinonims and AltresRelacions, normalized for searching.
+	ExemplesNormalized       string             `json:"-"` // The examples without HTML tags, normalized for searching.
+	DefinicioNormalized      string             `json:"-"` // The definition without HTML tags, normalized for searching.
+	TitleAccented            NormalizedPhrase   `json:"-"` // The phrase normalized keeping its accents, for ranking. Stemmed is not set.
+
+	// Set when rendering search results, not part of the export.
+	TitleHTML string `json:"-"` // Optional: the phrase rendered with getPhrase, with the matches of the query highlighted.
+	// Set in responses of the JSON API rendered as HTML, see getAPIEntries.
+	HTML string `json:"html,omitempty"` // Optional: the whole entry rendered with renderSingleEntry, as in the HTML pages.
+}
+
+// Represents a phrase normalized for searching.
+type NormalizedPhrase struct {
+	Wpc     string // Lowercase, without accents, without parentheses and their contents.
+	Wp      string // Lowercase, without accents, without parentheses.
+	Stemmed string // Wpc, with its words stemmed.
+}
+
+// Represents the form of a phrase that matched a search query.
+// See the MatchedForm constants.
+type MatchedForm string
+
+// Represents an entry that matched a search query.
+type SearchResult struct {
+	Entry       Entry
+	MatchedForm MatchedForm // Which form of the phrase matched, for debugging and API purposes.
+	Position    int         // 1-based position among all the results of the search.
+	Distance    int         // Edit distance between the phrase and the query, in SearchModeAproximat.
+}
+
+// Represents the position of a phrase among the results of a search, as returned by the API.
+type SearchPosition struct {
+	Phrase   string `json:"frase"`    // The phrase, as written in the dictionary.
+	Concept  string `json:"concepte"` // The concept of the entry.
+	Position int    `json:"posicio"`  // 1-based position among all the results.
+	Total    int    `json:"total"`    // Total number of results.
+}
+
+// Represents a rendered page of search results.
+type SearchResultsPage struct {
+	PhrasesHTML   string // The rendered entries of the page.
+	Total         int    // Total number of results.
+	FallbackQuery string // Set if the query had no results, and its hyphen fallback was used instead.
+	// Set if there are no results, to the phrase or concept to suggest instead. See getDidYouMean.
+	DidYouMean        string
+	DidYouMeanConcept string // Set if DidYouMean is a concept, rather than a phrase.
+	// Number of results of each category, ignoring the category filter. See getCategoryCounts.
+	CategoryCounts map[string]int
+}
+
+// Represents a link to filter search results, or the phrases of a concept, by their
+// grammatical category. See getCategoryFacets.
+type CategoryFacet struct {
+	Name     string // The full name of the category, or empty for the link that removes the filter.
+	Count    int    // The number of results of the category, or of all categories.
+	URL      string // The link that applies the filter, or removes it.
+	IsActive bool   // Whether the results are currently filtered this way.
+}
+
+// Represents the options of a search, other than the query itself.
+type SearchOptions struct {
+	Mode  string // One of SearchModes. Defaults to SearchModeConte.
+	Field string // Optional: SearchFieldSinonims to also search in synonyms and related phrases, SearchFieldExemples to also search in examples, or SearchFieldDefinicio to also search in definitions.
+	// Optional: drop the phrases that do not exist on any other source (NovaIncorporacio).
+	ExcludeNew bool
+	// Optional: drop the phrases without examples, e.g. for teaching.
+	OnlyWithExamples bool
+	// Optional: keep only the phrases of a grammatical category, by its key, e.g. "sv".
+	Category string
+	// Optional: keep the results in export order instead of sorting them, for debugging.
+	ExportOrder bool
+	// Optional: the query normalized keeping its accents. If set, the results that match
+	// it with the same accents are ranked first. See normalizeForSearchKeepingAccents.
+	AccentedQuery string
+}
+
+// Represents a synonym of a phrase, as returned by the synonyms API.
+type Synonym struct {
+	Phrase string `json:"frase"`         // The synonym, as written in the Sinonims field.
+	URL    string `json:"url,omitempty"` // Optional: absolute URL of a search for the synonym, if it exists in the dictionary.
+}
+
+// Represents a list of phrases that is split incorrectly. See getWhitelistCandidates.
+type WhitelistCandidate struct {
+	Phrase string // The existing phrase that is broken apart.
+	List   string // The list of phrases that contains it.
+}
+
+// Represents an initial letter and its number of concepts, as returned by the index API.
+type LetterCount struct {
+	Letter       string `json:"lletra"`    // The letter ({A-Z}).
+	ConceptCount int    `json:"conceptes"` // Number of concepts starting with the letter.
+}
+
+// Represents a phrase suggested as the user types a search. See getSuggestions.
+type PhraseSuggestion struct {
+	Normalized string // The phrase without parentheses content, as in Entry.TitleNormalizedWpc.
+	Title      string // The phrase, as written in the entry.
+	// Position of the phrase sorted with the Catalan collation rules. Equal phrases share it.
+	CollationRank int
+}
+
+// Represents a concept suggested instead of a search without results. See getDidYouMean.
+type ConceptSuggestion struct {
+	Normalized string // The title of the concept, normalized for searching. See getConceptTitle.
+	Concept    string // The concept, in its most common spelling. See ConceptsBySlug.
+}
+
+// Represents a normalized phrase of an entry, for finding entries by the start of their
+// phrase. See getEntryIndicesWithPrefix.
+type IndexedPhrase struct {
+	Normalized string // The phrase as in Entry.TitleNormalizedWpc or Entry.TitleNormalizedWp.
+	Index      int    // The index of the entry in AllEntries.
+}
+
+// Represents a page of search results of the JSON API. See apiSearchHandler.
+type SearchAPIResponse struct {
+	Total      int     `json:"total"`    // Number of results of the search.
+	Page       int     `json:"pagina"`   // The current page, starting from 1.
+	TotalPages int     `json:"pagines"`  // Number of pages of results.
+	Entries    []Entry `json:"entrades"` // The entries in the current page. See getAPIEntries.
+}
+
+// Represents the version of the loaded data in the JSON API. See dataVersionHandler.
+type DataVersionResponse struct {
+	Version    string `json:"versio"`   // Hash of the contents of the data. See DataVersion.
+	EntryCount int    `json:"entrades"` // Number of entries in the data.
+}
+
+// Represents an error of the JSON API. See serveJSONError.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Represents the state of the server in the liveness and readiness probes.
+// See livenessHandler and readinessHandler.
+type ProbeResponse struct {
+	Status string `json:"estat"` // Either ProbeStatusOK or ProbeStatusNotReady.
+}
+
+// Represents a concept and its entries in the JSON API. See apiConceptHandler.
+type ConceptAPIResponse struct {
+	Concept    string  `json:"concepte"` // The concept, in its most common spelling.
+	Total      int     `json:"total"`    // Number of entries of the concept.
+	Page       int     `json:"pagina"`   // The current page, starting from 1.
+	TotalPages int     `json:"pagines"`  // Number of pages of entries, 1 if not paginated.
+	Entries    []Entry `json:"entrades"` // The entries of the concept in the current page, in the order of the concept page.
+
+	// Concepts that share phrases with this one. See getRelatedConcepts.
+	Related []RelatedConcept `json:"relacionats"`
+}
+
+// Represents a concept that shares phrases with another one.
+type RelatedConcept struct {
+	ConceptLink
+	SharedPhrases int `json:"frases_comunes"` // Number of phrases shared, compared as in compareConcepts.
+}
+
+// Represents the comparison of the phrases of two concepts. See compareConcepts.
+type ConceptComparison struct {
+	ConceptA string   `json:"a"`       // The first concept, in its most common spelling.
+	ConceptB string   `json:"b"`       // The second concept, in its most common spelling.
+	Shared   []string `json:"comunes"` // Phrases of both concepts.
+	OnlyA    []string `json:"nomes_a"` // Phrases of the first concept only.
+	OnlyB    []string `json:"nomes_b"` // Phrases of the second concept only.
+}
+
+// Represents the concepts starting with a letter, for static generation. See getConceptsByLetter.
+type LetterConcepts struct {
+	Letter   string        `json:"lletra"`    // The letter ({A-Z}).
+	Concepts []ConceptLink `json:"conceptes"` // Concepts starting with the letter, in the order of the letter page.
+}
+
+// Represents a concept along with the location of its page.
+type ConceptLink struct {
+	Concept string `json:"concepte"` // The concept, as written in the data.
+	Slug    string `json:"slug"`     // Slug of the concept. See getConceptSlug.
+	Path    string `json:"ruta"`     // Path of the concept page. See getConceptPath.
+}
+
+// Represents a problem found in an entry of the data file. See getDataProblems.
+type DataProblem struct {
+	Index   int    // Position of the entry in the data file.
+	Title   string // The phrase of the entry, if any.
+	Message string
+	IsFatal bool // True if the entry cannot be served correctly.
 }
 
 // Represents the data for rendering a page.
@@ -30,10 +223,12 @@ type Entry struct {
 type PageData struct {
 	Title        string
 	CanonicalURL string
+	NoIndex      bool // Whether search engines should not index the page, e.g. search results.
 
 	// Flags to indicate the page being rendered
 	IsHomepage         bool
 	IsAbreviaturesPage bool
+	IsCategoryPage     bool
 	IsConceptPage      bool
 	IsConeixPage       bool
 	IsCreditsPage      bool
@@ -41,21 +236,94 @@ type PageData struct {
 	IsPresentacioPage  bool
 
 	// Search functionality
-	SearchQuery  string
-	SearchMode   string
-	SearchModes  []string
-	CurrentPage  int
-	TotalPages   int
-	PreviousPage int
-	NextPage     int
+	SearchQuery      string
+	SearchMode       string
+	SearchField      string
+	GroupByConcept   bool       // Whether to group the results by concept.
+	ExcludeNew       bool       // Whether to exclude new incorporations. Also used in concept pages.
+	OnlyWithExamples bool       // Whether to exclude phrases without examples. Also used in concept pages.
+	Category         string     // The key of the category to keep the phrases of. Also used in concept pages.
+	ExportOrder      bool       // Whether to keep the results in export order. Also used in concept pages.
+	AccentsExact     bool       // Whether to rank the results that match the accents of the query first.
+	SearchFilters    url.Values // The filters above, as query parameters, for building links with searchURL.
+	SearchModes      []string
+	CurrentPage      int
+	PageSize         int // Set only if given explicitly in the request.
+	TotalPages       int
+	TotalResults     int
+	PreviousPage     int
+	NextPage         int
+
+	// Links to filter the results by category, with their counts. Also used in concept pages.
+	CategoryFacets []CategoryFacet
+
+	// Set when the search query has no results, but its hyphen fallback does
+	FallbackQuery string
+
+	// Set when the search query has no results, but a similar phrase or concept exists
+	DidYouMean    string // The phrase or concept, as written in the dictionary.
+	DidYouMeanURL string // A search for the phrase, or the page of the concept.
+
+	// Set when rendering only the search results, as HTML fragments
+	IsFragment bool
+
+	// Set when the search query is shorter than MinQueryLength
+	IsQueryTooShort bool
+	MinQueryLength  int
+
+	// Used in the homepage
+	RecentConceptsHTML template.HTML // List of concepts recently viewed by the client.
 
 	// Used in concept pages
-	Concept template.HTML // The concept title. May contain HTML, e.g. <sup>1</sup>.
+	Concept    template.HTML // The concept title. May contain HTML, e.g. <sup>1</sup>.
+	Homographs template.HTML // Links to the other senses of the concept, if it is a homograph.
 
 	// Used in letter pages
 	Letter     string        // The letter ({A-Z}).
 	LetterHTML template.HTML // Body of the letter page.
 
-	// Used in search and concept pages
+	// Used in category pages
+	CategoryKey  string // The key of the grammatical category, e.g. "sv".
+	CategoryName string // The full name of the category, e.g. "sintagma verbal".
+
+	// Used in search, concept, and category pages
 	PhrasesHTML template.HTML // List of rendered, clickable phrases.
 }
+
+// Represents the data for rendering the OpenSearch description document.
+type OpenSearchData struct {
+	ShortName   string
+	Description string
+	BaseURL     string // Absolute URL of the site, without trailing slash.
+	EntryCount  int    // Number of entries in the dictionary.
+}
+
+// Represents the data for rendering a sitemap, or a sitemap index if Sitemaps is set.
+type SitemapData struct {
+	URLs     []string // Absolute URLs of the pages.
+	Sitemaps []string // Absolute URLs of the sitemaps, in a sitemap index.
+	LastMod  string   // Optional: date of the last modification, in W3C Datetime format.
+}
+
+// Represents the dictionary data loaded from a data file, and the lookup structures
+// derived from it, before they replace the global variables. See parseData.
+type DictionaryData struct {
+	Entries                []Entry
+	Version                string
+	PhrasesMap             map[string]bool
+	ConceptsByFirstLetter  map[string][]string
+	ConceptsBySlug         map[string]string
+	ConceptSlugsByPhrase   map[string][]string
+	EntryIndicesByCategory map[string][]int
+	EntryIndicesByWord     map[string][]int
+	PhrasesByNormalized    []IndexedPhrase
+	PhraseSuggestions      []PhraseSuggestion
+	ConceptSuggestions     []ConceptSuggestion
+}
+
+// Key of the function that releases the read lock of DataLock held for a request, in
+// the context of the request. See dataLockMiddleware.
+type dataLockContextKey struct{}
+
+// Key of the request logger in the context of a request. See requestLoggerMiddleware.
+type loggerContextKey struct{}